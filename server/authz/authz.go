@@ -0,0 +1,269 @@
+// Package authz decides what users may do in workspaces and projects. Every permission is granted by a
+// workspace role, which applies to the workspace and all of its projects, or by a project role, which applies
+// to one project. Handlers check permissions with the Gin helpers here, and list queries filter with the SQL
+// conditions built from the same tables.
+package authz
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Permission names an action on a workspace or project
+type Permission string
+
+// Permissions
+const (
+	WorkspaceUpdate Permission = "workspace.update" // Rename the workspace
+	MemberView      Permission = "member.view"      // List members
+	MemberInvite    Permission = "member.invite"    // Add and invite members
+	MemberManage    Permission = "member.manage"    // Change members' roles and remove them
+	ProjectCreate   Permission = "project.create"
+	ProjectView     Permission = "project.view"
+	ProjectUpdate   Permission = "project.update" // Also covers webhooks and the inbound URL
+	ProjectDelete   Permission = "project.delete"
+	TaskView        Permission = "task.view"
+	TaskCreate      Permission = "task.create"
+	TaskUpdate      Permission = "task.update"
+	TaskDelete      Permission = "task.delete"
+	TaskComment     Permission = "task.comment"
+)
+
+// WorkspaceRoles maps each workspace role to its permissions. They hold in the workspace and in all of its projects.
+var WorkspaceRoles = map[string][]Permission{
+	models.RoleAdmin: {
+		WorkspaceUpdate, MemberView, MemberInvite, MemberManage,
+		ProjectCreate, ProjectView, ProjectUpdate, ProjectDelete,
+		TaskView, TaskCreate, TaskUpdate, TaskDelete, TaskComment,
+	},
+	models.RoleMember: {
+		MemberView, ProjectCreate, ProjectView,
+		TaskView, TaskCreate, TaskUpdate, TaskDelete, TaskComment,
+	},
+	models.RoleGuest: {MemberView},
+}
+
+// ProjectRoles maps each project role to its permissions, which hold in that project only
+var ProjectRoles = map[string][]Permission{
+	models.ProjectRoleOwner: {
+		ProjectView, ProjectUpdate, ProjectDelete, MemberView, MemberInvite, MemberManage,
+		TaskView, TaskCreate, TaskUpdate, TaskDelete, TaskComment,
+	},
+	models.ProjectRoleEditor: {ProjectView, MemberView, TaskView, TaskCreate, TaskUpdate, TaskDelete, TaskComment},
+	models.ProjectRoleViewer: {ProjectView, MemberView, TaskView, TaskComment},
+}
+
+// Roles are a user's roles for a workspace and one of its projects, "" for none
+type Roles struct {
+	Workspace string
+	Project   string
+}
+
+// Can reports whether either role grants perm
+func (r Roles) Can(perm Permission) bool {
+	return grants(WorkspaceRoles[r.Workspace], perm) || grants(ProjectRoles[r.Project], perm)
+}
+
+func grants(perms []Permission, perm Permission) bool {
+	for _, p := range perms {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// rolesGranting returns the roles in roles that grant perm, sorted so generated SQL is stable
+func rolesGranting(roles map[string][]Permission, perm Permission) []string {
+	var names []string
+	for role, perms := range roles {
+		if grants(perms, perm) {
+			names = append(names, role)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sqlList quotes role names for an IN list. Role names are constants, never user input.
+func sqlList(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = "'" + name + "'"
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// Queryer is satisfied by *sql.DB and *sql.Tx
+type Queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// ProjectRolesOf loads userID's roles for a project in the workspace. Project owners get the owner role unless they
+// are guests, so demoting an owner hands their project to the admins. Both roles are "" when the user isn't a member
+// of the workspace, or the project isn't in it or is trashed.
+func ProjectRolesOf(db Queryer, userID, workspaceID, projectID int) (Roles, error) {
+	var roles Roles
+	err := db.QueryRow(`
+	SELECT m.role, CASE WHEN p.user_id = $2 AND m.role <> $4 THEN $5 ELSE COALESCE(pm.role, '') END
+	FROM projects p JOIN workspace_members m ON m.workspace_id = p.workspace_id AND m.user_id = $2
+	LEFT JOIN project_members pm ON pm.project_id = p.id AND pm.user_id = $2
+	WHERE p.id = $1 AND p.workspace_id = $3 AND p.deleted_at IS NULL`,
+		projectID, userID, workspaceID, models.RoleGuest, models.ProjectRoleOwner,
+	).Scan(&roles.Workspace, &roles.Project)
+	if err == sql.ErrNoRows {
+		return Roles{}, nil
+	}
+	return roles, err
+}
+
+// ProjectCondition is an SQL condition on the projects aliased as alias that holds where user $userArg has perm
+// in workspace $workspaceArg. The arguments are the positions of the user and workspace IDs among the query
+// parameters. It matches ProjectRolesOf and Roles.Can, without checking whether the project is trashed.
+func ProjectCondition(perm Permission, alias string, userArg, workspaceArg int) string {
+	granted := grantedCondition(perm, alias, fmt.Sprintf("$%d", userArg))
+	if granted == "" {
+		return "FALSE"
+	}
+	return fmt.Sprintf(
+		"(%[1]s.workspace_id = $%[3]d AND EXISTS (SELECT 1 FROM workspace_members azm WHERE azm.workspace_id = $%[3]d AND azm.user_id = $%[2]d AND (%[4]s)))",
+		alias, userArg, workspaceArg, granted)
+}
+
+// AnyWorkspaceProjectCondition is ProjectCondition across every workspace user $userArg belongs to, for requests
+// with no active workspace such as calendar feeds
+func AnyWorkspaceProjectCondition(perm Permission, alias string, userArg int) string {
+	granted := grantedCondition(perm, alias, fmt.Sprintf("$%d", userArg))
+	if granted == "" {
+		return "FALSE"
+	}
+	return fmt.Sprintf(
+		"EXISTS (SELECT 1 FROM workspace_members azm WHERE azm.workspace_id = %s.workspace_id AND azm.user_id = $%d AND (%s))",
+		alias, userArg, granted)
+}
+
+// grantedCondition is the condition on the membership aliased as azm and the projects aliased as alias under
+// which the user has perm, or "" if no role grants it. user is the SQL for the user's ID, such as a parameter.
+func grantedCondition(perm Permission, alias, user string) string {
+	var granted []string
+	if roles := rolesGranting(WorkspaceRoles, perm); len(roles) > 0 {
+		granted = append(granted, "azm.role IN ("+sqlList(roles)+")")
+	}
+	if grants(ProjectRoles[models.ProjectRoleOwner], perm) {
+		granted = append(granted, fmt.Sprintf("(azm.role <> '%s' AND %s.user_id = %s)", models.RoleGuest, alias, user))
+	}
+	var memberRoles []string
+	for _, role := range rolesGranting(ProjectRoles, perm) {
+		if role != models.ProjectRoleOwner {
+			memberRoles = append(memberRoles, role)
+		}
+	}
+	if len(memberRoles) > 0 {
+		granted = append(granted, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM project_members azpm WHERE azpm.project_id = %s.id AND azpm.user_id = %s AND azpm.role IN (%s))",
+			alias, user, sqlList(memberRoles)))
+	}
+	return strings.Join(granted, " OR ")
+}
+
+// TaskCondition is an SQL condition on the tasks aliased as alias that holds where the user has perm on the task's
+// project, like ProjectCondition
+func TaskCondition(perm Permission, alias string, userArg, workspaceArg int) string {
+	return fmt.Sprintf("%s.project_id IN (SELECT azp.id FROM projects azp WHERE %s)", alias, ProjectCondition(perm, "azp", userArg, workspaceArg))
+}
+
+// AnyWorkspaceTaskCondition is TaskCondition across every workspace the user belongs to, like
+// AnyWorkspaceProjectCondition
+func AnyWorkspaceTaskCondition(perm Permission, alias string, userArg int) string {
+	return fmt.Sprintf("%s.project_id IN (SELECT azp.id FROM projects azp WHERE %s)", alias, AnyWorkspaceProjectCondition(perm, "azp", userArg))
+}
+
+// ProjectUsers lists the users with perm on a project, such as everyone who should see its realtime events.
+// Like ProjectCondition, it doesn't check whether the project is trashed.
+func ProjectUsers(db *sql.DB, perm Permission, projectID int) ([]int, error) {
+	granted := grantedCondition(perm, "p", "azm.user_id")
+	if granted == "" {
+		return nil, nil
+	}
+	rows, err := db.Query("SELECT azm.user_id FROM projects p JOIN workspace_members azm ON azm.workspace_id = p.workspace_id WHERE p.id = $1 AND ("+granted+")", projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// Deny writes the 403 for a missing permission
+func Deny(c *gin.Context, perm Permission) {
+	c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to do this", "permission": perm})
+}
+
+// Check writes a 403 unless the user's role in the active workspace grants perm. WorkspaceMiddleware must run first.
+func Check(c *gin.Context, perm Permission) bool {
+	if !(Roles{Workspace: c.GetString("workspace_role")}).Can(perm) {
+		Deny(c, perm)
+		return false
+	}
+	return true
+}
+
+// Require is middleware that aborts with a 403 unless the user's role in the active workspace grants perm.
+// WorkspaceMiddleware must run first.
+func Require(perm Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !Check(c, perm) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Project parses the :id project in the active workspace and checks the user has perm on it. It writes a 404 if
+// they can't see the project and a 403 if they can but lack perm.
+func Project(c *gin.Context, db Queryer, perm Permission) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return 0, false
+	}
+	if !ProjectAllowed(c, db, id, perm) {
+		return 0, false
+	}
+	return id, true
+}
+
+// ProjectAllowed checks the user has perm on a project in the active workspace, writing a 404 if they can't see it
+// and a 403 if they can but lack perm
+func ProjectAllowed(c *gin.Context, db Queryer, projectID int, perm Permission) bool {
+	roles, err := ProjectRolesOf(db, c.GetInt("user_id"), c.GetInt("workspace_id"), projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return false
+	}
+	if !roles.Can(ProjectView) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Project with ID %d not found", projectID)})
+		return false
+	}
+	if !roles.Can(perm) {
+		Deny(c, perm)
+		return false
+	}
+	return true
+}
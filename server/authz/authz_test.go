@@ -0,0 +1,164 @@
+package authz
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Inengs/realtime-task-app/models"
+)
+
+var allPermissions = []Permission{
+	WorkspaceUpdate, MemberView, MemberInvite, MemberManage,
+	ProjectCreate, ProjectView, ProjectUpdate, ProjectDelete,
+	TaskView, TaskCreate, TaskUpdate, TaskDelete, TaskComment,
+}
+
+// permissionMatrix is the expected grants of each role, written out rather than derived from the role maps
+var permissionMatrix = []struct {
+	name  string
+	roles Roles
+	perms []Permission
+}{
+	{"no roles", Roles{}, nil},
+	{"workspace admin", Roles{Workspace: models.RoleAdmin}, allPermissions},
+	{"workspace member", Roles{Workspace: models.RoleMember}, []Permission{
+		MemberView, ProjectCreate, ProjectView, TaskView, TaskCreate, TaskUpdate, TaskDelete, TaskComment,
+	}},
+	{"workspace guest", Roles{Workspace: models.RoleGuest}, []Permission{MemberView}},
+	{"project owner", Roles{Project: models.ProjectRoleOwner}, []Permission{
+		MemberView, MemberInvite, MemberManage, ProjectView, ProjectUpdate, ProjectDelete,
+		TaskView, TaskCreate, TaskUpdate, TaskDelete, TaskComment,
+	}},
+	{"project editor", Roles{Project: models.ProjectRoleEditor}, []Permission{
+		MemberView, ProjectView, TaskView, TaskCreate, TaskUpdate, TaskDelete, TaskComment,
+	}},
+	{"project viewer", Roles{Project: models.ProjectRoleViewer}, []Permission{
+		MemberView, ProjectView, TaskView, TaskComment,
+	}},
+	{"guest with editor role", Roles{Workspace: models.RoleGuest, Project: models.ProjectRoleEditor}, []Permission{
+		MemberView, ProjectView, TaskView, TaskCreate, TaskUpdate, TaskDelete, TaskComment,
+	}},
+	{"member who owns the project", Roles{Workspace: models.RoleMember, Project: models.ProjectRoleOwner}, []Permission{
+		MemberView, MemberInvite, MemberManage, ProjectCreate, ProjectView, ProjectUpdate, ProjectDelete,
+		TaskView, TaskCreate, TaskUpdate, TaskDelete, TaskComment,
+	}},
+	{"member with viewer role", Roles{Workspace: models.RoleMember, Project: models.ProjectRoleViewer}, []Permission{
+		MemberView, ProjectCreate, ProjectView, TaskView, TaskCreate, TaskUpdate, TaskDelete, TaskComment,
+	}},
+	{"unknown roles", Roles{Workspace: "owner", Project: "admin"}, nil},
+}
+
+func TestRolesCan(t *testing.T) {
+	for _, tc := range permissionMatrix {
+		granted := map[Permission]bool{}
+		for _, perm := range tc.perms {
+			granted[perm] = true
+		}
+		for _, perm := range allPermissions {
+			if got := tc.roles.Can(perm); got != granted[perm] {
+				t.Errorf("%s: Can(%s) = %v, want %v", tc.name, perm, got, granted[perm])
+			}
+		}
+	}
+}
+
+// TestEveryRoleIsCovered keeps the matrix in step with the role maps
+func TestEveryRoleIsCovered(t *testing.T) {
+	covered := map[Roles]bool{}
+	for _, tc := range permissionMatrix {
+		covered[tc.roles] = true
+	}
+	for role := range WorkspaceRoles {
+		if !covered[Roles{Workspace: role}] {
+			t.Errorf("workspace role %q has no case in the permission matrix", role)
+		}
+	}
+	for role := range ProjectRoles {
+		if !covered[Roles{Project: role}] {
+			t.Errorf("project role %q has no case in the permission matrix", role)
+		}
+	}
+}
+
+func TestProjectCondition(t *testing.T) {
+	const memberOf = "(p.workspace_id = $2 AND EXISTS (SELECT 1 FROM workspace_members azm WHERE azm.workspace_id = $2 AND azm.user_id = $1 AND ("
+
+	tests := []struct {
+		perm Permission
+		want string
+	}{
+		{WorkspaceUpdate, memberOf + "azm.role IN ('admin'))))"},
+		{MemberInvite, memberOf + "azm.role IN ('admin') OR (azm.role <> 'guest' AND p.user_id = $1))))"},
+		{ProjectUpdate, memberOf + "azm.role IN ('admin') OR (azm.role <> 'guest' AND p.user_id = $1))))"},
+		{ProjectCreate, memberOf + "azm.role IN ('admin', 'member'))))"},
+		{TaskUpdate, memberOf + "azm.role IN ('admin', 'member') OR (azm.role <> 'guest' AND p.user_id = $1) OR " +
+			"EXISTS (SELECT 1 FROM project_members azpm WHERE azpm.project_id = p.id AND azpm.user_id = $1 AND azpm.role IN ('editor')))))"},
+		{TaskView, memberOf + "azm.role IN ('admin', 'member') OR (azm.role <> 'guest' AND p.user_id = $1) OR " +
+			"EXISTS (SELECT 1 FROM project_members azpm WHERE azpm.project_id = p.id AND azpm.user_id = $1 AND azpm.role IN ('editor', 'viewer')))))"},
+		{MemberView, memberOf + "azm.role IN ('admin', 'guest', 'member') OR (azm.role <> 'guest' AND p.user_id = $1) OR " +
+			"EXISTS (SELECT 1 FROM project_members azpm WHERE azpm.project_id = p.id AND azpm.user_id = $1 AND azpm.role IN ('editor', 'viewer')))))"},
+		{Permission("nothing.grants"), "FALSE"},
+	}
+	for _, tc := range tests {
+		if got := ProjectCondition(tc.perm, "p", 1, 2); got != tc.want {
+			t.Errorf("ProjectCondition(%s)\n got %s\nwant %s", tc.perm, got, tc.want)
+		}
+	}
+}
+
+// TestOwnerConditionExcludesGuests checks that owning a project only grants the owner role to non-guests,
+// as ProjectRolesOf does, for every permission owners have
+func TestOwnerConditionExcludesGuests(t *testing.T) {
+	for _, perm := range ProjectRoles[models.ProjectRoleOwner] {
+		cond := ProjectCondition(perm, "p", 3, 4)
+		if !strings.Contains(cond, "(azm.role <> 'guest' AND p.user_id = $3)") {
+			t.Errorf("ProjectCondition(%s) doesn't exclude guest owners: %s", perm, cond)
+		}
+		if strings.Contains(cond, "azm.role IN ('guest'") && perm != MemberView {
+			t.Errorf("ProjectCondition(%s) grants guests by workspace role: %s", perm, cond)
+		}
+	}
+}
+
+func TestAnyWorkspaceProjectCondition(t *testing.T) {
+	tests := []struct {
+		perm Permission
+		want string
+	}{
+		{TaskView, "EXISTS (SELECT 1 FROM workspace_members azm WHERE azm.workspace_id = p.workspace_id AND azm.user_id = $1 AND (" +
+			"azm.role IN ('admin', 'member') OR (azm.role <> 'guest' AND p.user_id = $1) OR " +
+			"EXISTS (SELECT 1 FROM project_members azpm WHERE azpm.project_id = p.id AND azpm.user_id = $1 AND azpm.role IN ('editor', 'viewer'))))"},
+		{Permission("nothing.grants"), "FALSE"},
+	}
+	for _, tc := range tests {
+		if got := AnyWorkspaceProjectCondition(tc.perm, "p", 1); got != tc.want {
+			t.Errorf("AnyWorkspaceProjectCondition(%s)\n got %s\nwant %s", tc.perm, got, tc.want)
+		}
+	}
+
+	want := "t.project_id IN (SELECT azp.id FROM projects azp WHERE " + AnyWorkspaceProjectCondition(TaskView, "azp", 1) + ")"
+	if got := AnyWorkspaceTaskCondition(TaskView, "t", 1); got != want {
+		t.Errorf("AnyWorkspaceTaskCondition(%s)\n got %s\nwant %s", TaskView, got, want)
+	}
+}
+
+func TestTaskCondition(t *testing.T) {
+	tests := []struct {
+		perm Permission
+		want string
+	}{
+		{TaskDelete, "t.project_id IN (SELECT azp.id FROM projects azp WHERE " + ProjectCondition(TaskDelete, "azp", 1, 2) + ")"},
+		{Permission("nothing.grants"), "t.project_id IN (SELECT azp.id FROM projects azp WHERE FALSE)"},
+	}
+	for _, tc := range tests {
+		if got := TaskCondition(tc.perm, "t", 1, 2); got != tc.want {
+			t.Errorf("TaskCondition(%s)\n got %s\nwant %s", tc.perm, got, tc.want)
+		}
+	}
+
+	// The project alias is azp, so conditions never refer to the task alias for ownership
+	cond := TaskCondition(TaskUpdate, "t", 1, 2)
+	if !strings.Contains(cond, "azp.user_id = $1") || strings.Contains(cond, "t.user_id") {
+		t.Errorf("TaskCondition should check ownership on the project: %s", cond)
+	}
+}
@@ -0,0 +1,224 @@
+package config
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/mailer"
+	"github.com/Inengs/realtime-task-app/mailin"
+	"github.com/Inengs/realtime-task-app/middleware"
+)
+
+// getEnvInt gets an integer environment variable or returns a default value
+func getEnvInt(key string, defaultValue int) int {
+	value := getEnv(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid value for %s: %q, using default %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// TrashRetention returns how long soft-deleted tasks and projects are kept before being purged
+func TrashRetention() time.Duration {
+	days := getEnvInt("TRASH_RETENTION_DAYS", 30)
+	if days < 1 {
+		days = 1
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// JobWorkers returns how many background job workers to run
+func JobWorkers() int {
+	workers := getEnvInt("JOB_WORKERS", 4)
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+// JobMaxAttempts returns how many times a background job is tried before it is marked dead
+func JobMaxAttempts() int {
+	attempts := getEnvInt("JOB_MAX_ATTEMPTS", 8)
+	if attempts < 1 {
+		attempts = 1
+	}
+	return attempts
+}
+
+// AccountExportTTL returns how long a finished account data export can be downloaded
+func AccountExportTTL() time.Duration {
+	hours := getEnvInt("ACCOUNT_EXPORT_TTL_HOURS", 24)
+	if hours < 1 {
+		hours = 1
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// AccountDeletionGrace returns how long a deleted account can still be restored before it is removed
+func AccountDeletionGrace() time.Duration {
+	days := getEnvInt("ACCOUNT_DELETION_GRACE_DAYS", 14)
+	if days < 0 {
+		days = 0
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// InviteTTL returns how long an emailed workspace or project invite can be accepted
+func InviteTTL() time.Duration {
+	days := getEnvInt("INVITE_TTL_DAYS", 7)
+	if days < 1 {
+		days = 1
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// LoginMaxFailures returns how many failed logins in a row lock an account, LOGIN_MAX_FAILURES (default 5)
+func LoginMaxFailures() int {
+	failures := getEnvInt("LOGIN_MAX_FAILURES", 5)
+	if failures < 1 {
+		failures = 1
+	}
+	return failures
+}
+
+// LoginLockout returns how long an account stays locked after too many failed logins
+func LoginLockout() time.Duration {
+	minutes := getEnvInt("LOGIN_LOCKOUT_MINUTES", 15)
+	if minutes < 1 {
+		minutes = 1
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// LoginBackoff returns the delay after the first failed login, which doubles with each further failure
+func LoginBackoff() time.Duration {
+	seconds := getEnvInt("LOGIN_BACKOFF_SECONDS", 1)
+	if seconds < 0 {
+		seconds = 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// AdminEmails returns the emails in ADMIN_EMAILS (comma-separated) whose accounts are made system administrators at startup
+func AdminEmails() []string {
+	var emails []string
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
+
+// ImpersonationTTL returns how long an admin's impersonation session lasts, IMPERSONATION_TTL_MINUTES (default 60)
+func ImpersonationTTL() time.Duration {
+	minutes := getEnvInt("IMPERSONATION_TTL_MINUTES", 60)
+	if minutes < 1 {
+		minutes = 1
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// RateLimitPolicies returns the rate limit policies, each overridable with RATE_LIMIT_<NAME> set to
+// <requests>/<period>[,<key>] such as "120/1m,user", or "off". Invalid values keep the default.
+func RateLimitPolicies() map[string]middleware.RateLimitPolicy {
+	policies := make(map[string]middleware.RateLimitPolicy, len(middleware.RateLimitPolicies))
+	for name, policy := range middleware.RateLimitPolicies {
+		key := "RATE_LIMIT_" + strings.ToUpper(name)
+		if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+			if parsed, ok := parseRateLimitPolicy(value, policy.Key); ok {
+				policy = parsed
+			} else {
+				log.Printf("Invalid value for %s: %q, using default %d/%s,%s", key, value, policy.Requests, policy.Period, policy.Key)
+			}
+		}
+		policies[name] = policy
+	}
+	return policies
+}
+
+// parseRateLimitPolicy parses a RATE_LIMIT_<NAME> value, keeping defaultKey when none is given
+func parseRateLimitPolicy(value, defaultKey string) (middleware.RateLimitPolicy, bool) {
+	if strings.EqualFold(value, "off") {
+		return middleware.RateLimitPolicy{Period: time.Minute, Key: defaultKey}, true
+	}
+
+	limit, key, hasKey := strings.Cut(value, ",")
+	if !hasKey {
+		key = defaultKey
+	}
+	key = strings.ToLower(strings.TrimSpace(key))
+	if key != middleware.RateLimitByIP && key != middleware.RateLimitByUser && key != middleware.RateLimitByToken {
+		return middleware.RateLimitPolicy{}, false
+	}
+
+	requestsStr, periodStr, ok := strings.Cut(limit, "/")
+	if !ok {
+		return middleware.RateLimitPolicy{}, false
+	}
+	requests, err := strconv.Atoi(strings.TrimSpace(requestsStr))
+	if err != nil || requests < 1 {
+		return middleware.RateLimitPolicy{}, false
+	}
+	period, err := time.ParseDuration(strings.TrimSpace(periodStr))
+	if err != nil || period < time.Second {
+		return middleware.RateLimitPolicy{}, false
+	}
+	return middleware.RateLimitPolicy{Requests: requests, Period: period, Key: key}, true
+}
+
+// WebhookAllowedNetworks returns the private networks in WEBHOOK_ALLOWED_CIDRS (comma-separated)
+// that webhooks may still be sent to, such as "10.1.0.0/16". Invalid entries are skipped.
+func WebhookAllowedNetworks() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(os.Getenv("WEBHOOK_ALLOWED_CIDRS"), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Printf("Invalid network in WEBHOOK_ALLOWED_CIDRS: %q", cidr)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// MailConfig returns the mail driver configuration. MAIL_DRIVER is smtp (default), file or log.
+func MailConfig() mailer.Config {
+	return mailer.Config{
+		Driver:   getEnv("MAIL_DRIVER", mailer.DriverSMTP),
+		From:     getEnv("EMAIL_FROM", "TaskFlow <noreply@localhost>"),
+		Host:     getEnv("EMAIL_SMTP_HOST", ""),
+		Port:     getEnv("EMAIL_SMTP_PORT", ""),
+		Username: getEnv("EMAIL_USERNAME", ""),
+		Password: getEnv("EMAIL_PASSWORD", ""),
+		TLS:      getEnv("EMAIL_SMTP_TLS", ""),
+		Dir:      getEnv("MAIL_FILE_DIR", "mail"),
+	}
+}
+
+// InboundMailConfig returns the inbound mail listener configuration. The listener only runs
+// when MAIL_LISTEN_ADDR is set; MAIL_LISTEN_PROTOCOL is smtp (default) or lmtp.
+func InboundMailConfig() mailin.Config {
+	maxMB := getEnvInt("MAIL_MAX_MESSAGE_MB", 10)
+	if maxMB < 1 {
+		maxMB = 1
+	}
+	return mailin.Config{
+		Addr:     getEnv("MAIL_LISTEN_ADDR", ""),
+		Protocol: getEnv("MAIL_LISTEN_PROTOCOL", mailin.ProtocolSMTP),
+		Hostname: getEnv("MAIL_INBOUND_DOMAIN", ""),
+		MaxSize:  int64(maxMB) << 20,
+	}
+}
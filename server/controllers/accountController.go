@@ -0,0 +1,672 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/jobs"
+	"github.com/Inengs/realtime-task-app/middleware"
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/Inengs/realtime-task-app/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Account lifetimes; main sets them from config
+var (
+	AccountExportTTL     = 24 * time.Hour
+	AccountDeletionGrace = 14 * 24 * time.Hour
+)
+
+// staleExportAge is how long a pending export blocks new requests before it is assumed to have failed
+const staleExportAge = time.Hour
+
+// accountExportColumns lists the account export columns, without the file, in the order scanAccountExport expects them
+const accountExportColumns = "id, user_id, status, size, created_at, updated_at, expires_at"
+
+// scanAccountExport scans a row selected with accountExportColumns into export and signs its download link
+func scanAccountExport(row rowScanner, export *models.AccountExport) error {
+	if err := row.Scan(&export.ID, &export.UserID, &export.Status, &export.Size, &export.CreatedAt, &export.UpdatedAt, &export.ExpiresAt); err != nil {
+		return err
+	}
+	if export.Status == models.AccountExportReady && export.ExpiresAt != nil && export.ExpiresAt.After(time.Now()) {
+		export.DownloadURL = accountExportURL(export.ID, export.ExpiresAt.Unix())
+	}
+	return nil
+}
+
+// accountExportURL returns the signed download link of an export
+func accountExportURL(exportID int, expires int64) string {
+	return fmt.Sprintf("%s/account/exports/%d/download?expires=%d&signature=%s", utils.APIBaseURL(), exportID, expires, utils.SignExportDownload(exportID, expires))
+}
+
+// RequestAccountExport handles POST /account/export. The ZIP is built by a background job,
+// and the user is notified with its download link when it is ready.
+func RequestAccountExport(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the user so two requests can't both queue an export
+	if _, err := tx.Exec("SELECT 1 FROM users WHERE id = $1 FOR UPDATE", userIDInt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	var pending bool
+	err = tx.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM account_exports WHERE user_id = $1 AND status = $2 AND created_at > $3)",
+		userIDInt, models.AccountExportPending, time.Now().UTC().Add(-staleExportAge),
+	).Scan(&pending)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if pending {
+		c.JSON(http.StatusConflict, gin.H{"error": "An export is already being prepared"})
+		return
+	}
+
+	var export models.AccountExport
+	if err := scanAccountExport(tx.QueryRow("INSERT INTO account_exports (user_id) VALUES ($1) RETURNING "+accountExportColumns, userIDInt), &export); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := jobs.Enqueue(tx, jobs.TypeAccountExport, jobs.AccountExportPayload{ExportID: export.ID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := recordAudit(c, tx, auditEvent{Action: models.AuditAccountExportRequested, TargetType: models.AuditTargetUser, TargetID: userIDInt, Details: map[string]interface{}{"export_id": export.ID}}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Export requested successfully", "export": export})
+}
+
+// ListAccountExports handles GET /account/exports, newest first
+func ListAccountExports(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	rows, err := db.Query("SELECT "+accountExportColumns+" FROM account_exports WHERE user_id = $1 ORDER BY id DESC", userIDInt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	exports := []models.AccountExport{}
+	for rows.Next() {
+		var export models.AccountExport
+		if err := scanAccountExport(rows, &export); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		exports = append(exports, export)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Exports retrieved successfully", "exports": exports})
+}
+
+// DownloadAccountExport handles GET /account/exports/:id/download?expires=...&signature=...
+// The signed link authenticates the request, so it works without a session until it expires.
+func DownloadAccountExport(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	exportID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export ID"})
+		return
+	}
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || utils.VerifyExportDownload(exportID, expires, c.Query("signature")) != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This download link is invalid or has expired"})
+		return
+	}
+
+	var file []byte
+	var createdAt time.Time
+	err = db.QueryRow("SELECT file, created_at FROM account_exports WHERE id = $1 AND status = $2", exportID, models.AccountExportReady).Scan(&file, &createdAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="taskflow-export-%s.zip"`, createdAt.Format("2006-01-02")))
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, "application/zip", file)
+}
+
+// archiveJSON writes value as an indented JSON file in the archive
+func archiveJSON(zw *zip.Writer, name string, value interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// archiveRows writes the rows of a query as a JSON array file, scanning each row with scan
+func archiveRows(db *sql.DB, zw *zip.Writer, name, query string, userID int, scan func(row rowScanner) (interface{}, error)) error {
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := []interface{}{}
+	for rows.Next() {
+		value, err := scan(rows)
+		if err != nil {
+			return err
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return archiveJSON(zw, name, values)
+}
+
+// archiveFilename makes an attachment filename safe to use as a path inside the archive
+func archiveFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == ".." {
+		name = "attachment"
+	}
+	return name
+}
+
+// writeAccountArchive writes everything stored about a user: their account, projects, tasks they own
+// or are assigned, comments they wrote or that are on their tasks, notifications and attachments
+func writeAccountArchive(db *sql.DB, zw *zip.Writer, userID int) error {
+	var account struct {
+		ID              int       `json:"id"`
+		Username        string    `json:"username"`
+		Email           string    `json:"email"`
+		Verified        bool      `json:"verified"`
+		Timezone        string    `json:"timezone"`
+		DigestFrequency string    `json:"digest_frequency"`
+		CreatedAt       time.Time `json:"created_at"`
+	}
+	err := db.QueryRow("SELECT id, username, email, COALESCE(verified, false), timezone, digest_frequency, created_at FROM users WHERE id = $1", userID).Scan(
+		&account.ID, &account.Username, &account.Email, &account.Verified, &account.Timezone, &account.DigestFrequency, &account.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if err := archiveJSON(zw, "account.json", account); err != nil {
+		return err
+	}
+
+	err = archiveRows(db, zw, "projects.json", "SELECT "+projectColumns+" FROM projects WHERE user_id = $1 ORDER BY id", userID, func(row rowScanner) (interface{}, error) {
+		var project models.Project
+		err := scanProject(row, &project)
+		return project, err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = archiveRows(db, zw, "tasks.json", "SELECT "+taskColumns+" FROM tasks WHERE user_id = $1 OR assignee_id = $1 ORDER BY id", userID, func(row rowScanner) (interface{}, error) {
+		var task models.Task
+		err := scanTask(row, &task)
+		return task, err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = archiveRows(db, zw, "comments.json",
+		"SELECT "+commentColumns+" FROM task_comments WHERE user_id = $1 OR task_id IN (SELECT id FROM tasks WHERE user_id = $1) ORDER BY id", userID,
+		func(row rowScanner) (interface{}, error) {
+			var comment models.TaskComment
+			err := scanComment(row, &comment)
+			return comment, err
+		})
+	if err != nil {
+		return err
+	}
+
+	err = archiveRows(db, zw, "notifications.json", "SELECT "+notificationColumns+" FROM notifications WHERE user_id = $1 ORDER BY id", userID, func(row rowScanner) (interface{}, error) {
+		var notification models.Notifications
+		err := scanNotification(row, &notification)
+		return notification, err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Attachments are listed in attachments.json and stored as attachments/<id>-<filename>
+	rows, err := db.Query("SELECT "+attachmentColumns+", content FROM task_attachments WHERE user_id = $1 OR task_id IN (SELECT id FROM tasks WHERE user_id = $1) ORDER BY id", userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	attachments := []models.TaskAttachment{}
+	for rows.Next() {
+		var attachment models.TaskAttachment
+		var content []byte
+		if err := scanAttachment(extraScanner{row: rows, extra: []interface{}{&content}}, &attachment); err != nil {
+			return err
+		}
+		w, err := zw.Create(fmt.Sprintf("attachments/%d-%s", attachment.ID, archiveFilename(attachment.Filename)))
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(content); err != nil {
+			return err
+		}
+		attachments = append(attachments, attachment)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return archiveJSON(zw, "attachments.json", attachments)
+}
+
+// BuildAccountExport handles jobs.TypeAccountExport jobs: it stores the ZIP on the export and
+// notifies the user with the download link
+func BuildAccountExport(ctx context.Context, db *sql.DB, payload json.RawMessage) error {
+	var p jobs.AccountExportPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	var userID int
+	var status string
+	err := db.QueryRowContext(ctx, "SELECT user_id, status FROM account_exports WHERE id = $1", p.ExportID).Scan(&userID, &status)
+	if err == sql.ErrNoRows {
+		return nil // The account was deleted
+	}
+	if err != nil {
+		return err
+	}
+	if status != models.AccountExportPending {
+		return nil
+	}
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	if err := writeAccountArchive(db, zw, userID); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().UTC().Add(AccountExportTTL).Truncate(time.Second)
+	var export models.AccountExport
+	err = scanAccountExport(db.QueryRow(
+		"UPDATE account_exports SET status = $1, file = $2, size = $3, expires_at = $4, updated_at = CURRENT_TIMESTAMP WHERE id = $5 RETURNING "+accountExportColumns,
+		models.AccountExportReady, archive.Bytes(), archive.Len(), expiresAt, p.ExportID,
+	), &export)
+	if err != nil {
+		return err
+	}
+
+	return Notify(db, NotificationEvent{
+		UserID: userID,
+		Type:   models.NotificationAccountExportReady,
+		Data: map[string]interface{}{
+			"export_id":  export.ID,
+			"url":        export.DownloadURL,
+			"expires_at": expiresAt.Format(time.RFC1123),
+		},
+	})
+}
+
+// DeleteAccount handles DELETE /account. After the password is confirmed the account is scheduled
+// for deletion at the end of the grace period and the session is ended; until then it can be restored.
+func DeleteAccount(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	// Bind and validate request body
+	var input models.DeleteAccountInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(input); err != nil {
+		var errs []string
+		for _, err := range err.(validator.ValidationErrors) {
+			errs = append(errs, fmt.Sprintf("Field '%s' failed on '%s'", err.Field(), err.Tag()))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+	if input.Projects == "" {
+		input.Projects = models.ProjectPolicyTransfer
+	}
+
+	var storedHash string
+	var scheduled *time.Time
+	err := db.QueryRow("SELECT password, deletion_scheduled_for FROM users WHERE id = $1", userIDInt).Scan(&storedHash, &scheduled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(SanitizeInput(input.Password))) != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Incorrect password"})
+		return
+	}
+	if scheduled != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Account deletion is already scheduled"})
+		return
+	}
+
+	if input.TransferTo != nil {
+		if *input.TransferTo == userIDInt {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Projects can't be transferred to yourself"})
+			return
+		}
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND deletion_scheduled_for IS NULL)", *input.TransferTo).Scan(&exists); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User to transfer projects to not found"})
+			return
+		}
+	}
+
+	deletion := models.AccountDeletion{
+		ScheduledFor: time.Now().UTC().Add(AccountDeletionGrace).Truncate(time.Second),
+		Projects:     input.Projects,
+		TransferTo:   input.TransferTo,
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"UPDATE users SET deletion_scheduled_for = $1, deletion_policy = $2, deletion_transfer_to = $3, calendar_token = NULL WHERE id = $4",
+		deletion.ScheduledFor, deletion.Projects, deletion.TransferTo, userIDInt,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	err = recordAudit(c, tx, auditEvent{
+		Action: models.AuditAccountDeletionRequested, TargetType: models.AuditTargetUser, TargetID: userIDInt,
+		Details: map[string]interface{}{"scheduled_for": deletion.ScheduledFor, "projects": deletion.Projects, "transfer_to": deletion.TransferTo},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// End the session; logging in again during the grace period allows restoring the account
+	session, err := middleware.Store.Get(c.Request, "auth-session")
+	if err == nil {
+		session.Values = make(map[interface{}]interface{})
+		session.Options.MaxAge = -1
+		if err := session.Save(c.Request, c.Writer); err != nil {
+			log.Printf("Error clearing session of deleted account %d: %v", userIDInt, err)
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Account deletion scheduled successfully", "deletion": deletion})
+}
+
+// RestoreAccount handles POST /account/restore, cancelling a scheduled deletion that has not started yet
+func RestoreAccount(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"UPDATE users SET deletion_scheduled_for = NULL, deletion_policy = NULL, deletion_transfer_to = NULL WHERE id = $1 AND deletion_scheduled_for IS NOT NULL AND deletion_queued_at IS NULL",
+		userIDInt,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "No account deletion to cancel"})
+		return
+	}
+	if err := recordAudit(c, tx, auditEvent{Action: models.AuditAccountDeletionCancelled, TargetType: models.AuditTargetUser, TargetID: userIDInt}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account restored successfully"})
+}
+
+// projectSuccessor picks who takes over a project when its owner's account is deleted, among the other
+// active admins and members of the project's workspace: transferTo when they are one of them, otherwise
+// the one with the most assigned tasks and comments in the project, preferring admins on a tie.
+// It returns 0 when nobody else in the workspace can take the project over.
+func projectSuccessor(db dbExecutor, project models.Project, transferTo *int) (int, error) {
+	var successorID int
+	err := db.QueryRow(`
+	SELECT m.user_id FROM workspace_members m JOIN users u ON u.id = m.user_id LEFT JOIN (
+		SELECT assignee_id AS user_id FROM tasks WHERE project_id = $1 AND assignee_id IS NOT NULL
+		UNION ALL
+		SELECT c.user_id FROM task_comments c JOIN tasks t ON t.id = c.task_id WHERE t.project_id = $1
+	) participants ON participants.user_id = m.user_id
+	WHERE m.workspace_id = $2 AND m.user_id <> $3 AND m.role <> 'guest' AND u.deletion_scheduled_for IS NULL
+	GROUP BY m.user_id, m.role
+	ORDER BY COALESCE(m.user_id = $4, false) DESC, COUNT(participants.user_id) DESC, m.role = 'admin' DESC, m.user_id LIMIT 1`,
+		project.ID, project.WorkspaceID, project.UserID, transferTo,
+	).Scan(&successorID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return successorID, err
+}
+
+// RunAccountDeletion handles jobs.TypeAccountDeletion jobs. Instead of letting ON DELETE CASCADE
+// remove everything the user owns, shared projects are transferred according to the user's policy
+// and tasks they created in other people's projects are kept by those projects' owners. Their comments
+// and attachments on the remaining tasks lose their author through ON DELETE SET NULL.
+func RunAccountDeletion(ctx context.Context, db *sql.DB, payload json.RawMessage) error {
+	var p jobs.AccountDeletionPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	var username string
+	var policy sql.NullString
+	var transferTo *int
+	err := db.QueryRowContext(ctx, "SELECT username, deletion_policy, deletion_transfer_to FROM users WHERE id = $1 AND deletion_queued_at IS NOT NULL", p.UserID).Scan(&username, &policy, &transferTo)
+	if err == sql.ErrNoRows {
+		return nil // Already deleted
+	}
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT "+projectColumns+" FROM projects WHERE user_id = $1 ORDER BY id FOR UPDATE", p.UserID)
+	if err != nil {
+		return err
+	}
+	var projects []models.Project
+	for rows.Next() {
+		var project models.Project
+		if err := scanProject(rows, &project); err != nil {
+			rows.Close()
+			return err
+		}
+		projects = append(projects, project)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var transferred []models.Project
+	for _, project := range projects {
+		successorID := 0
+		if policy.String != models.ProjectPolicyDelete && project.DeletedAt == nil {
+			if successorID, err = projectSuccessor(tx, project, transferTo); err != nil {
+				return err
+			}
+		}
+		if successorID == 0 {
+			if _, err := tx.Exec("DELETE FROM projects WHERE id = $1", project.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := tx.Exec("UPDATE projects SET user_id = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", successorID, project.ID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE tasks SET user_id = $1 WHERE project_id = $2", successorID, project.ID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE webhooks SET user_id = $1 WHERE project_id = $2", successorID, project.ID); err != nil {
+			return err
+		}
+		project.UserID = successorID
+		transferred = append(transferred, project)
+	}
+
+	// Tasks the user created in projects they don't own stay in those projects
+	if _, err := tx.Exec("UPDATE tasks SET user_id = p.user_id FROM projects p WHERE tasks.project_id = p.id AND tasks.user_id = $1", p.UserID); err != nil {
+		return err
+	}
+	// Workspaces the user was the only admin of are handed to their longest-standing member, guests last,
+	// and workspaces nobody else belongs to are deleted
+	_, err = tx.Exec(`
+	UPDATE workspace_members m SET role = 'admin' FROM (
+		SELECT DISTINCT ON (o.workspace_id) o.workspace_id, o.user_id FROM workspace_members o
+		JOIN workspace_members mine ON mine.workspace_id = o.workspace_id AND mine.user_id = $1 AND mine.role = 'admin'
+		WHERE o.user_id <> $1 AND NOT EXISTS (SELECT 1 FROM workspace_members a WHERE a.workspace_id = o.workspace_id AND a.user_id <> $1 AND a.role = 'admin')
+		ORDER BY o.workspace_id, o.role = 'guest', o.created_at, o.user_id
+	) heir WHERE m.workspace_id = heir.workspace_id AND m.user_id = heir.user_id`, p.UserID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	DELETE FROM workspaces w WHERE EXISTS (SELECT 1 FROM workspace_members WHERE workspace_id = w.id AND user_id = $1)
+		AND NOT EXISTS (SELECT 1 FROM workspace_members WHERE workspace_id = w.id AND user_id <> $1)`, p.UserID)
+	if err != nil {
+		return err
+	}
+	// Comments and attachments left on remaining tasks stay with a NULL author, shown as DeletedUserName.
+	// Everything else, such as their notifications and exports, goes with the account.
+	if _, err := tx.Exec("DELETE FROM users WHERE id = $1", p.UserID); err != nil {
+		return err
+	}
+	// The deletion job has no request, so the entry has no actor, IP address or request ID
+	err = recordAudit(nil, tx, auditEvent{
+		Action: models.AuditAccountDeleted, TargetType: models.AuditTargetUser, TargetID: p.UserID,
+		Details: map[string]interface{}{"username": username, "projects": len(projects), "transferred": len(transferred)},
+	})
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Deleted account %d; transferred %d of %d projects", p.UserID, len(transferred), len(projects))
+
+	for _, project := range transferred {
+		err := Notify(db, NotificationEvent{
+			UserID:     project.UserID,
+			Type:       models.NotificationProjectTransferred,
+			EntityType: models.EntityProject,
+			EntityID:   project.ID,
+			Data:       map[string]interface{}{"project_id": project.ID, "name": project.Name, "previous_owner": username},
+		})
+		if err != nil {
+			log.Printf("Error notifying user %d of transferred project %d: %v", project.UserID, project.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,648 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/middleware"
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	defaultAdminLimit = 50
+	maxAdminLimit     = 200
+)
+
+// ImpersonationTTL is how long an admin can act as another user before the session ends. Set from config in main.
+var ImpersonationTTL = time.Hour
+
+// adminUserColumns lists the users columns in the order scanAdminUser expects them
+const adminUserColumns = "id, username, email, COALESCE(verified, FALSE), is_admin, disabled_at, disabled_reason, deletion_scheduled_for, created_at"
+
+// scanAdminUser scans a row selected with adminUserColumns into user
+func scanAdminUser(row rowScanner, user *models.AdminUser) error {
+	return row.Scan(&user.ID, &user.Username, &user.Email, &user.Verified, &user.IsAdmin, &user.DisabledAt, &user.DisabledReason, &user.DeletionScheduledFor, &user.CreatedAt)
+}
+
+// BootstrapAdmins promotes the accounts with the given emails to system administrators.
+// It only ever grants the role, so removing an email from the list doesn't demote anyone.
+func BootstrapAdmins(db *sql.DB, emails []string) error {
+	for _, email := range emails {
+		result, err := db.Exec("UPDATE users SET is_admin = TRUE WHERE LOWER(email) = $1 AND NOT is_admin", email)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			log.Printf("Promoted %s to system administrator", email)
+		}
+	}
+	return nil
+}
+
+// adminUserID parses the :id path parameter
+func adminUserID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+// ListAdminUsers handles GET /admin/users to list and search every account on the instance.
+// q matches username or email; disabled and admin filter by true or false.
+func ListAdminUsers(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	var conditions []string
+	var args []interface{}
+
+	if q := strings.TrimSpace(SanitizeInput(c.Query("q"))); q != "" {
+		// strpos rather than ILIKE so % and _ in the query match literally
+		args = append(args, strings.ToLower(q))
+		conditions = append(conditions, fmt.Sprintf("(strpos(LOWER(username), $%d) > 0 OR strpos(LOWER(email), $%d) > 0)", len(args), len(args)))
+	}
+
+	for _, filter := range []struct{ param, condition string }{
+		{"disabled", "disabled_at IS NOT NULL"},
+		{"admin", "is_admin"},
+	} {
+		value := c.Query(filter.param)
+		if value == "" {
+			continue
+		}
+		set, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s filter", filter.param)})
+			return
+		}
+		if set {
+			conditions = append(conditions, filter.condition)
+		} else {
+			conditions = append(conditions, "NOT ("+filter.condition+")")
+		}
+	}
+
+	limit := defaultAdminLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		if limit > maxAdminLimit {
+			limit = maxAdminLimit
+		}
+	}
+
+	offset := 0
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		var err error
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users"+where, args...).Scan(&total); err != nil {
+		log.Printf("Error counting users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	args = append(args, limit, offset)
+	rows, err := db.Query(
+		"SELECT "+adminUserColumns+" FROM users"+where+fmt.Sprintf(" ORDER BY id LIMIT $%d OFFSET $%d", len(args)-1, len(args)),
+		args...,
+	)
+	if err != nil {
+		log.Printf("Error listing users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	users := []models.AdminUser{}
+	for rows.Next() {
+		var user models.AdminUser
+		if err := scanAdminUser(rows, &user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Users retrieved successfully", "users": users, "total": total})
+}
+
+// GetAdminUser handles GET /admin/users/:id
+func GetAdminUser(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	id, ok := adminUserID(c)
+	if !ok {
+		return
+	}
+
+	var user models.AdminUser
+	err := scanAdminUser(db.QueryRow("SELECT "+adminUserColumns+" FROM users WHERE id = $1", id), &user)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("User with ID %d not found", id)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User retrieved successfully", "user": user})
+}
+
+// DisableUser handles POST /admin/users/:id/disable. The user's sessions stop working at their next
+// request and their WebSocket connections are closed straight away.
+func DisableUser(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+	adminID := c.GetInt("user_id")
+
+	id, ok := adminUserID(c)
+	if !ok {
+		return
+	}
+	if id == adminID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't disable your own account"})
+		return
+	}
+
+	var input models.DisableUserInput
+	// The body is optional since the reason is
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(input); err != nil {
+		var errs []string
+		for _, err := range err.(validator.ValidationErrors) {
+			errs = append(errs, fmt.Sprintf("Field '%s' failed on '%s'", err.Field(), err.Tag()))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	var reason *string
+	if input.Reason = SanitizeInput(input.Reason); input.Reason != "" {
+		reason = &input.Reason
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var user models.AdminUser
+	err = scanAdminUser(tx.QueryRow(
+		"UPDATE users SET disabled_at = COALESCE(disabled_at, CURRENT_TIMESTAMP), disabled_reason = $1 WHERE id = $2 RETURNING "+adminUserColumns,
+		reason, id,
+	), &user)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("User with ID %d not found", id)})
+		return
+	}
+	if err != nil {
+		log.Printf("Error disabling user %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	err = recordAudit(c, tx, auditEvent{Action: models.AuditAdminUserDisabled, TargetType: models.AuditTargetUser, TargetID: id, Details: map[string]interface{}{"reason": input.Reason}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	manager.CloseUser(id)
+
+	c.JSON(http.StatusOK, gin.H{"message": "User disabled successfully", "user": user})
+}
+
+// EnableUser handles POST /admin/users/:id/enable
+func EnableUser(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	id, ok := adminUserID(c)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var user models.AdminUser
+	err = scanAdminUser(tx.QueryRow(
+		"UPDATE users SET disabled_at = NULL, disabled_reason = NULL WHERE id = $1 RETURNING "+adminUserColumns,
+		id,
+	), &user)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("User with ID %d not found", id)})
+		return
+	}
+	if err != nil {
+		log.Printf("Error enabling user %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := recordAudit(c, tx, auditEvent{Action: models.AuditAdminUserEnabled, TargetType: models.AuditTargetUser, TargetID: id}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User enabled successfully", "user": user})
+}
+
+// VerifyUser handles POST /admin/users/:id/verify to mark a user's email verified without the emailed link
+func VerifyUser(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	id, ok := adminUserID(c)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var user models.AdminUser
+	err = scanAdminUser(tx.QueryRow(
+		"UPDATE users SET verified = TRUE, verification_token = NULL, verification_token_expiry = NULL WHERE id = $1 RETURNING "+adminUserColumns,
+		id,
+	), &user)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("User with ID %d not found", id)})
+		return
+	}
+	if err != nil {
+		log.Printf("Error verifying user %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := recordAudit(c, tx, auditEvent{Action: models.AuditAdminUserVerified, TargetType: models.AuditTargetUser, TargetID: id}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User verified successfully", "user": user})
+}
+
+// ImpersonateUser handles POST /admin/users/:id/impersonate to act as a user for support.
+// The session switches to the user until ImpersonationTTL passes or the admin calls
+// POST /auth/impersonation/stop, and every session is kept in the impersonations audit trail.
+func ImpersonateUser(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+	adminID := c.GetInt("user_id")
+
+	id, ok := adminUserID(c)
+	if !ok {
+		return
+	}
+	if id == adminID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't impersonate yourself"})
+		return
+	}
+
+	var input models.ImpersonateInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	input.Reason = SanitizeInput(input.Reason)
+
+	validate := validator.New()
+	if err := validate.Struct(input); err != nil {
+		var errs []string
+		for _, err := range err.(validator.ValidationErrors) {
+			errs = append(errs, fmt.Sprintf("Field '%s' failed on '%s'", err.Field(), err.Tag()))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	var user models.AdminUser
+	err := scanAdminUser(db.QueryRow("SELECT "+adminUserColumns+" FROM users WHERE id = $1", id), &user)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("User with ID %d not found", id)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	// Acting as another admin would hand out their admin role, and disabled users can't have sessions
+	if user.IsAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admins can't be impersonated"})
+		return
+	}
+	if user.DisabledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Account disabled"})
+		return
+	}
+
+	session, err := middleware.Store.Get(c.Request, "auth-session")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Session error"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var impersonation models.Impersonation
+	expires := time.Now().Add(ImpersonationTTL)
+	err = tx.QueryRow(
+		`INSERT INTO impersonations (admin_id, user_id, reason, ip_address, expires_at) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, admin_id, user_id, reason, ip_address, started_at, expires_at, ended_at`,
+		adminID, id, input.Reason, c.ClientIP(), expires,
+	).Scan(&impersonation.ID, &impersonation.AdminID, &impersonation.UserID, &impersonation.Reason, &impersonation.IPAddress, &impersonation.StartedAt, &impersonation.ExpiresAt, &impersonation.EndedAt)
+	if err != nil {
+		log.Printf("Error starting impersonation of user %d by admin %d: %v", id, adminID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	impersonation.Username = &user.Username
+	err = recordAudit(c, tx, auditEvent{
+		Action: models.AuditImpersonationStarted, TargetType: models.AuditTargetUser, TargetID: id,
+		Details: map[string]interface{}{"impersonation_id": impersonation.ID, "reason": input.Reason, "expires_at": impersonation.ExpiresAt},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	session.Values["user_id"] = id
+	session.Values["impersonator_id"] = adminID
+	session.Values["impersonation_id"] = impersonation.ID
+	session.Values["impersonation_expires"] = expires.Unix()
+	if err := session.Save(c.Request, c.Writer); err != nil {
+		db.Exec("UPDATE impersonations SET ended_at = CURRENT_TIMESTAMP WHERE id = $1", impersonation.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save session"})
+		return
+	}
+
+	log.Printf("Admin %d started impersonating user %d (impersonation %d)", adminID, id, impersonation.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Impersonation started successfully", "impersonation": impersonation})
+}
+
+// StopImpersonation handles POST /auth/impersonation/stop to return the session to the admin
+func StopImpersonation(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	adminID := c.GetInt("impersonator_id")
+	if adminID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not impersonating anyone"})
+		return
+	}
+	impersonationID := c.GetInt("impersonation_id")
+
+	session, err := middleware.Store.Get(c.Request, "auth-session")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Session error"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE impersonations SET ended_at = CURRENT_TIMESTAMP WHERE id = $1 AND ended_at IS NULL", impersonationID); err != nil {
+		log.Printf("Error ending impersonation %d: %v", impersonationID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	err = recordAudit(c, tx, auditEvent{
+		Action: models.AuditImpersonationStopped, ActorID: adminID, TargetType: models.AuditTargetUser, TargetID: c.GetInt("user_id"),
+		Details: map[string]interface{}{"impersonation_id": impersonationID},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	session.Values["user_id"] = adminID
+	delete(session.Values, "impersonator_id")
+	delete(session.Values, "impersonation_id")
+	delete(session.Values, "impersonation_expires")
+	if err := session.Save(c.Request, c.Writer); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Impersonation stopped successfully", "user_id": adminID})
+}
+
+// ListImpersonations handles GET /admin/impersonations to return the impersonation audit trail, newest first.
+// user_id and admin_id filter by the impersonated user and the admin.
+func ListImpersonations(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	var conditions []string
+	var args []interface{}
+	for _, filter := range []struct{ param, column string }{
+		{"user_id", "i.user_id"},
+		{"admin_id", "i.admin_id"},
+	} {
+		value := c.Query(filter.param)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s", filter.param)})
+			return
+		}
+		args = append(args, id)
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", filter.column, len(args)))
+	}
+
+	limit := defaultAdminLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		if limit > maxAdminLimit {
+			limit = maxAdminLimit
+		}
+	}
+
+	offset := 0
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		var err error
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit, offset)
+
+	rows, err := db.Query(
+		`SELECT i.id, i.admin_id, a.username, i.user_id, u.username, i.reason, i.ip_address, i.started_at, i.expires_at, i.ended_at
+		FROM impersonations i
+		LEFT JOIN users a ON a.id = i.admin_id
+		LEFT JOIN users u ON u.id = i.user_id`+where+
+			fmt.Sprintf(" ORDER BY i.started_at DESC, i.id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args)),
+		args...,
+	)
+	if err != nil {
+		log.Printf("Error listing impersonations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	impersonations := []models.Impersonation{}
+	for rows.Next() {
+		var i models.Impersonation
+		if err := rows.Scan(&i.ID, &i.AdminID, &i.AdminUsername, &i.UserID, &i.Username, &i.Reason, &i.IPAddress, &i.StartedAt, &i.ExpiresAt, &i.EndedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		impersonations = append(impersonations, i)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Impersonations retrieved successfully", "impersonations": impersonations})
+}
+
+// AdminStatsFunc handles GET /admin/stats to summarise users, content, background jobs and live connections
+func AdminStatsFunc(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	var stats models.AdminStats
+	err := db.QueryRow(`
+	SELECT
+		(SELECT COUNT(*) FROM users),
+		(SELECT COUNT(*) FROM users WHERE verified),
+		(SELECT COUNT(*) FROM users WHERE is_admin),
+		(SELECT COUNT(*) FROM users WHERE disabled_at IS NOT NULL),
+		(SELECT COUNT(*) FROM users WHERE deletion_scheduled_for IS NOT NULL),
+		(SELECT COUNT(*) FROM users WHERE created_at > CURRENT_TIMESTAMP - INTERVAL '7 days'),
+		(SELECT COUNT(*) FROM workspaces),
+		(SELECT COUNT(*) FROM projects WHERE deleted_at IS NULL),
+		(SELECT COUNT(*) FROM projects WHERE deleted_at IS NOT NULL),
+		(SELECT COUNT(*) FROM tasks WHERE deleted_at IS NULL),
+		(SELECT COUNT(*) FROM tasks WHERE deleted_at IS NOT NULL),
+		(SELECT COUNT(*) FROM impersonations WHERE ended_at IS NULL AND expires_at > CURRENT_TIMESTAMP)
+	`).Scan(
+		&stats.Users.Total, &stats.Users.Verified, &stats.Users.Admins, &stats.Users.Disabled, &stats.Users.PendingDeletion, &stats.Users.NewLast7Days,
+		&stats.Workspaces, &stats.Projects.Active, &stats.Projects.Trashed, &stats.Tasks.Total, &stats.Tasks.Trashed, &stats.ActiveImpersonations,
+	)
+	if err != nil {
+		log.Printf("Error computing admin stats: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	stats.Tasks.ByStatus, err = countBy(db, "SELECT status, COUNT(*) FROM tasks WHERE deleted_at IS NULL GROUP BY status")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	stats.Jobs, err = countBy(db, "SELECT status, COUNT(*) FROM jobs GROUP BY status")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	stats.ActiveConnections = manager.ConnectionCount()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stats retrieved successfully", "stats": stats})
+}
+
+// countBy runs a two-column key, count query and collects it into a map
+func countBy(db *sql.DB, query string) (map[string]int, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var key string
+		var count int
+		if err := rows.Scan(&key, &count); err != nil {
+			return nil, err
+		}
+		counts[key] = count
+	}
+	return counts, rows.Err()
+}
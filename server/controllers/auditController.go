@@ -0,0 +1,271 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/gin-gonic/gin"
+)
+
+const maxUserAgentLength = 512
+
+// auditColumns lists the audit_log columns in the order scanAuditEntry expects them
+const auditColumns = "a.id, a.action, a.actor_id, u.username, a.impersonator_id, a.target_type, a.target_id, a.details, a.ip_address, a.user_agent, a.request_id, a.created_at"
+
+// auditEvent is a security-relevant event to append to the audit log
+type auditEvent struct {
+	Action     string
+	ActorID    int // Defaults to the signed-in user; 0 when there is none, such as a failed login
+	TargetType string
+	TargetID   int
+	Details    map[string]interface{}
+}
+
+// recordAudit appends event to the audit log with the request's IP address, user agent and request ID.
+// Pass the transaction making the change so the entry commits with it. c is nil for background jobs.
+func recordAudit(c *gin.Context, db dbExecutor, event auditEvent) error {
+	var impersonatorID int
+	var ip, userAgent, requestID string
+	if c != nil {
+		if event.ActorID == 0 {
+			event.ActorID = c.GetInt("user_id")
+		}
+		impersonatorID = c.GetInt("impersonator_id")
+		ip = c.ClientIP()
+		userAgent = c.Request.UserAgent()
+		if len(userAgent) > maxUserAgentLength {
+			userAgent = userAgent[:maxUserAgentLength]
+		}
+		requestID = c.GetString("request_id")
+	}
+	if event.Details == nil {
+		event.Details = map[string]interface{}{}
+	}
+
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO audit_log (action, actor_id, impersonator_id, target_type, target_id, details, ip_address, user_agent, request_id)
+		VALUES ($1, NULLIF($2, 0), NULLIF($3, 0), NULLIF($4, ''), NULLIF($5, 0), $6, NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''))`,
+		event.Action, event.ActorID, impersonatorID, event.TargetType, event.TargetID, details, ip, userAgent, requestID,
+	)
+	if err != nil {
+		log.Printf("Error recording audit event %s: %v", event.Action, err)
+	}
+	return err
+}
+
+// scanAuditEntry scans a row selected with auditColumns into entry
+func scanAuditEntry(row rowScanner, entry *models.AuditEntry) error {
+	var details []byte
+	if err := row.Scan(&entry.ID, &entry.Action, &entry.ActorID, &entry.ActorUsername, &entry.ImpersonatorID, &entry.TargetType, &entry.TargetID, &details, &entry.IPAddress, &entry.UserAgent, &entry.RequestID, &entry.CreatedAt); err != nil {
+		return err
+	}
+	return json.Unmarshal(details, &entry.Details)
+}
+
+// parseAuditTime accepts an RFC 3339 timestamp or a YYYY-MM-DD date
+func parseAuditTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// auditFilter builds the WHERE clause for the audit log query parameters. It writes a 400 and returns
+// false if one is invalid.
+func auditFilter(c *gin.Context) (string, []interface{}, bool) {
+	var conditions []string
+	var args []interface{}
+
+	if action := c.Query("action"); action != "" {
+		// A bare area such as "auth" matches every action in it
+		args = append(args, action)
+		if strings.Contains(action, ".") {
+			conditions = append(conditions, fmt.Sprintf("a.action = $%d", len(args)))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("split_part(a.action, '.', 1) = $%d", len(args)))
+		}
+	}
+
+	for _, filter := range []struct{ param, column string }{
+		{"actor_id", "a.actor_id"},
+		{"target_id", "a.target_id"},
+	} {
+		value := c.Query(filter.param)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s", filter.param)})
+			return "", nil, false
+		}
+		args = append(args, id)
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", filter.column, len(args)))
+	}
+
+	for _, filter := range []struct{ param, column string }{
+		{"target_type", "a.target_type"},
+		{"ip", "a.ip_address"},
+		{"request_id", "a.request_id"},
+	} {
+		if value := c.Query(filter.param); value != "" {
+			args = append(args, value)
+			conditions = append(conditions, fmt.Sprintf("%s = $%d", filter.column, len(args)))
+		}
+	}
+
+	for _, filter := range []struct{ param, operator string }{
+		{"since", ">="},
+		{"until", "<"},
+	} {
+		value := c.Query(filter.param)
+		if value == "" {
+			continue
+		}
+		t, err := parseAuditTime(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s, use RFC 3339 or YYYY-MM-DD", filter.param)})
+			return "", nil, false
+		}
+		args = append(args, t.UTC())
+		conditions = append(conditions, fmt.Sprintf("a.created_at %s $%d", filter.operator, len(args)))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+	return where, args, true
+}
+
+// ListAuditLog handles GET /admin/audit to query the audit log, newest first.
+// Pass the last seen entry ID as ?before= to page backwards.
+func ListAuditLog(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	where, args, ok := auditFilter(c)
+	if !ok {
+		return
+	}
+
+	limit := defaultAdminLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		if limit > maxAdminLimit {
+			limit = maxAdminLimit
+		}
+	}
+
+	if beforeStr := c.Query("before"); beforeStr != "" {
+		before, err := strconv.ParseInt(beforeStr, 10, 64)
+		if err != nil || before <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		args = append(args, before)
+		if where == "" {
+			where = " WHERE "
+		} else {
+			where += " AND "
+		}
+		where += fmt.Sprintf("a.id < $%d", len(args))
+	}
+
+	args = append(args, limit)
+	rows, err := db.Query(
+		"SELECT "+auditColumns+" FROM audit_log a LEFT JOIN users u ON u.id = a.actor_id"+where+fmt.Sprintf(" ORDER BY a.id DESC LIMIT $%d", len(args)),
+		args...,
+	)
+	if err != nil {
+		log.Printf("Error querying audit log: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var entry models.AuditEntry
+		if err := scanAuditEntry(rows, &entry); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	response := gin.H{"message": "Audit log retrieved successfully", "entries": entries}
+	if len(entries) == limit {
+		response["next_cursor"] = entries[len(entries)-1].ID
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ExportAuditLog handles GET /admin/audit/export to stream the matching audit log entries as NDJSON,
+// oldest first. The export itself is recorded in the audit log.
+func ExportAuditLog(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	where, args, ok := auditFilter(c)
+	if !ok {
+		return
+	}
+
+	if err := recordAudit(c, db, auditEvent{Action: models.AuditLogExported, Details: map[string]interface{}{"query": c.Request.URL.RawQuery}}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	rows, err := db.Query("SELECT "+auditColumns+" FROM audit_log a LEFT JOIN users u ON u.id = a.actor_id"+where+" ORDER BY a.id", args...)
+	if err != nil {
+		log.Printf("Error exporting audit log: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Type", exportContentTypes[exportNDJSON])
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s.ndjson"`, time.Now().UTC().Format("20060102-150405")))
+	c.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(c.Writer)
+	count := 0
+	for rows.Next() {
+		var entry models.AuditEntry
+		if err := scanAuditEntry(rows, &entry); err != nil {
+			log.Printf("Audit export scan error: %v", err)
+			return
+		}
+		if err := encoder.Encode(entry); err != nil {
+			log.Printf("Audit export encode error for entry %d: %v", entry.ID, err)
+			return
+		}
+		count++
+		if count%exportFlushEvery == 0 {
+			c.Writer.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Audit export rows error: %v", err)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"unicode"
 
 	_ "github.com/Inengs/realtime-task-app/db"
+	"github.com/Inengs/realtime-task-app/jobs"
 	"github.com/Inengs/realtime-task-app/middleware"
 	"github.com/Inengs/realtime-task-app/models"
 	"github.com/Inengs/realtime-task-app/utils"
@@ -21,9 +22,10 @@ import (
 )
 
 type User struct {
-	Username string `json:"username" binding:"required,min=3,max=20"`
-	Email    string `json:"email" binding:"required"`
-	Password string `json:"password" binding:"required,min=6,max=32"`
+	Username    string `json:"username" binding:"required,min=3,max=20"`
+	Email       string `json:"email" binding:"required"`
+	Password    string `json:"password" binding:"required,min=6,max=32"`
+	InviteToken string `json:"invite_token"` // Optional; registering from an invite link skips email verification
 }
 
 // LoginRequest defines the structure for login payload
@@ -150,6 +152,17 @@ func RegisterFunc(c *gin.Context) {
 		return
 	}
 
+	// An invite token proves the invitee owns the email it was sent to
+	var inviteID int
+	var inviteExpires int64
+	if user.InviteToken != "" {
+		inviteID, inviteExpires, err = utils.VerifyInviteToken(user.InviteToken)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invite"})
+			return
+		}
+	}
+
 	token, err := utils.GenerateVerificationToken()
 	if err != nil {
 		log.Printf("Token generation error: %v", err)
@@ -159,25 +172,84 @@ func RegisterFunc(c *gin.Context) {
 
 	expiry := time.Now().Add(24 * time.Hour)
 
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
 	// INSERT USER
 	var userID int
-	err = db.QueryRow(
-		`INSERT INTO users (username, email, password, verification_token, verification_token_expiry) 
+	if user.InviteToken != "" {
+		err = tx.QueryRow(
+			`INSERT INTO users (username, email, password, verified) VALUES ($1, $2, $3, TRUE) RETURNING id`,
+			user.Username, user.Email, string(hashedPassword),
+		).Scan(&userID)
+	} else {
+		err = tx.QueryRow(
+			`INSERT INTO users (username, email, password, verification_token, verification_token_expiry) 
      VALUES ($1, $2, $3, $4, $5) RETURNING id`,
-		user.Username, user.Email, string(hashedPassword), token, expiry,
-	).Scan(&userID)
+			user.Username, user.Email, string(hashedPassword), token, expiry,
+		).Scan(&userID)
+	}
 	if err != nil {
 		log.Printf("User insert error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
-	if err := utils.SendVerificationEmail(user.Email, token); err != nil {
-		log.Printf("Email sending error: %v", err)
+	var invite models.Invite
+	if user.InviteToken != "" {
+		// Join the invite's workspace and project with the account, so a rejected invite creates neither
+		invite, err = acceptInvite(tx, inviteID, inviteExpires, userID, user.Email)
+		if err == errInvalidInvite || err == errInviteEmail {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invite"})
+			return
+		}
+		if err != nil {
+			log.Printf("Invite accept error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+			return
+		}
+		if err := auditInviteAccepted(c, tx, userID, invite); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+			return
+		}
+	} else if err := jobs.Enqueue(tx, jobs.TypeVerificationEmail, jobs.VerificationEmailPayload{Email: user.Email, Token: token}); err != nil {
+		// Queue the verification email with the user so neither exists without the other
+		log.Printf("Email queue error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
 		return
 	}
 
+	err = recordAudit(c, tx, auditEvent{
+		Action: models.AuditRegistered, ActorID: userID, TargetType: models.AuditTargetUser, TargetID: userID,
+		Details: map[string]interface{}{"username": user.Username, "email": user.Email},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("User insert error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+
+	if user.InviteToken != "" {
+		if err := notifyInviteAccepted(db, invite, userID); err != nil {
+			log.Printf("Error notifying inviter of invite %d: %v", invite.ID, err)
+		}
+		c.JSON(http.StatusCreated, gin.H{
+			"message":      "User registered successfully. You can log in now.",
+			"user_id":      userID,
+			"workspace_id": invite.WorkspaceID,
+		})
+		return
+	}
+
 	// SUCCESS RESPONSE
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "User registered. Please check your email to verify your account.",
@@ -286,12 +358,31 @@ func LoginFunc(c *gin.Context) {
 	// 	return
 	// }
 
+	// Count the attempt up front, refusing it during the backoff after a failed attempt or while the email is locked out
+	attempt, wait, err := reserveLoginAttempt(db, login.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if wait > 0 {
+		recordAudit(c, db, auditEvent{Action: models.AuditLoginFailed, Details: map[string]interface{}{"email": login.Email, "reason": "throttled"}})
+		tooManyLoginAttempts(c, wait)
+		return
+	}
+
 	// QUERY USER BY EMAIL
 	var userID int
 	var storedHash string
-	query := `SELECT id, password FROM users WHERE email=$1`
-	err = db.QueryRow(query, login.Email).Scan(&userID, &storedHash)
+	var disabled bool
+	query := `SELECT id, password, disabled_at IS NOT NULL FROM users WHERE email=$1`
+	err = db.QueryRow(query, login.Email).Scan(&userID, &storedHash, &disabled)
 	if err == sql.ErrNoRows {
+		// Spend as long as a real password check, so unknown emails behave like wrong passwords
+		comparePassword(dummyPasswordHash, []byte(login.Password))
+		if err := reportLoginLockout(c, db, login.Email, 0, attempt); err != nil {
+			log.Printf("Error reporting login lockout: %v", err)
+		}
+		recordAudit(c, db, auditEvent{Action: models.AuditLoginFailed, Details: map[string]interface{}{"email": login.Email, "reason": "unknown_email"}})
 		// Return 401 for non-existent user or incorrect password to avoid information leakage
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		return
@@ -303,13 +394,30 @@ func LoginFunc(c *gin.Context) {
 	}
 
 	// VERIFY PASSWORD
-	err = bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(login.Password))
+	err = comparePassword([]byte(storedHash), []byte(login.Password))
 	if err != nil {
+		if err := reportLoginLockout(c, db, login.Email, userID, attempt); err != nil {
+			log.Printf("Error reporting login lockout for user %d: %v", userID, err)
+		}
+		recordAudit(c, db, auditEvent{Action: models.AuditLoginFailed, ActorID: userID, TargetType: models.AuditTargetUser, TargetID: userID, Details: map[string]interface{}{"email": login.Email, "reason": "wrong_password"}})
 		// Return 401 if password doesn't match
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		return
 	}
 
+	// The right password resets the failed login count
+	if err := clearLoginFailures(db, login.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Disabled accounts can't log in; only say so once the password has been checked
+	if disabled {
+		recordAudit(c, db, auditEvent{Action: models.AuditLoginFailed, ActorID: userID, TargetType: models.AuditTargetUser, TargetID: userID, Details: map[string]interface{}{"email": login.Email, "reason": "disabled"}})
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+		return
+	}
+
 	// CREATE SESSION
 	session, err := middleware.Store.Get(c.Request, "auth-session")
 	if err != nil {
@@ -325,6 +433,9 @@ func LoginFunc(c *gin.Context) {
 		return
 	}
 
+	// The session is already saved, so a failed audit write is only logged
+	recordAudit(c, db, auditEvent{Action: models.AuditLoginSucceeded, ActorID: userID, TargetType: models.AuditTargetUser, TargetID: userID})
+
 	// SUCCESS RESPONSE
 	// Return user ID and success message (no sensitive data like password)
 	c.JSON(http.StatusOK, gin.H{"message": "Login successful", "user_id": userID})
@@ -346,6 +457,16 @@ func LogoutFunc(c *gin.Context) {
 		return
 	}
 
+	// End any impersonation in the audit trail before the session goes
+	db := c.MustGet("db").(*sql.DB)
+	if impersonationID, ok := session.Values["impersonation_id"].(int); ok {
+		if _, err := db.Exec("UPDATE impersonations SET ended_at = CURRENT_TIMESTAMP WHERE id = $1 AND ended_at IS NULL", impersonationID); err != nil {
+			log.Printf("Error ending impersonation %d: %v", impersonationID, err)
+		}
+	}
+	userID, _ := session.Values["user_id"].(int)
+	recordAudit(c, db, auditEvent{Action: models.AuditLogout, ActorID: userID, TargetType: models.AuditTargetUser, TargetID: userID})
+
 	// CLEAR SESSION
 	// Remove all session data
 	session.Values = make(map[interface{}]interface{})
@@ -397,8 +518,9 @@ func MeFunc(c *gin.Context) {
 
 	// Query user details from database
 	var user models.UserResponse
-	query := `SELECT id, username, email FROM users WHERE id=$1`
-	err = db.QueryRow(query, userID).Scan(&user.UserID, &user.Username, &user.Email)
+	var isAdmin bool
+	query := `SELECT id, username, email, is_admin FROM users WHERE id=$1`
+	err = db.QueryRow(query, userID).Scan(&user.UserID, &user.Username, &user.Email, &isAdmin)
 	if err == sql.ErrNoRows {
 		log.Printf("User not found for user_id: %d", userID) // Debug: Log missing user
 		// Return 404 if user not found (handles edge cases like deleted users)
@@ -415,7 +537,20 @@ func MeFunc(c *gin.Context) {
 	// SUCCESS RESPONSE
 	// Return user details
 	log.Printf("Successfully retrieved user: id=%d, username=%s, email=%s", user.UserID, user.Username, user.Email) // Debug: Log user details
-	c.JSON(http.StatusOK, gin.H{"message": "User info retrieved", "user": user})
+	response := gin.H{"message": "User info retrieved", "user": user, "is_admin": isAdmin, "impersonating": false}
+
+	// Tell the client an admin is acting as this user so it can show a banner
+	if impersonatorID := c.GetInt("impersonator_id"); impersonatorID != 0 {
+		var impersonator models.UserResponse
+		err = db.QueryRow(`SELECT id, username, email FROM users WHERE id=$1`, impersonatorID).Scan(&impersonator.UserID, &impersonator.Username, &impersonator.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		response["impersonating"] = true
+		response["impersonator"] = gin.H{"user_id": impersonator.UserID, "username": impersonator.Username}
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 
@@ -472,9 +607,16 @@ func ResendVerificationEmail(c *gin.Context) {
 		return
 	}
 
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
 	// Update token and expiry
 	expiry := time.Now().Add(24 * time.Hour)
-	_, err = db.Exec(
+	_, err = tx.Exec(
 		`UPDATE users SET verification_token = $1, verification_token_expiry = $2 WHERE id = $3`,
 		token, expiry, userID,
 	)
@@ -484,12 +626,18 @@ func ResendVerificationEmail(c *gin.Context) {
 		return
 	}
 
-	// Send email
-	if err := utils.SendVerificationEmail(email, token); err != nil {
-		log.Printf("Email sending error: %v", err)
+	// Queue email
+	if err := jobs.Enqueue(tx, jobs.TypeVerificationEmail, jobs.VerificationEmailPayload{Email: email, Token: token}); err != nil {
+		log.Printf("Email queue error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		log.Printf("Token update error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Verification email resent successfully"})
 }
\ No newline at end of file
@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/Inengs/realtime-task-app/authz"
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// errBulkTaskNotFound is returned for IDs that don't exist, are outside the user's projects or are trashed
+var errBulkTaskNotFound = errors.New("task not found")
+
+// errBulkTaskForbidden is returned for tasks the user can see but whose project role doesn't allow the operation
+var errBulkTaskForbidden = errors.New("task operation not permitted")
+
+// bulkUpdate returns the SET clause, its arguments and the revision action for a bulk operation.
+// The task ID is bound after the returned arguments.
+func bulkUpdate(input models.BulkTaskInput) (string, []interface{}, string) {
+	switch input.Operation {
+	case models.BulkSetStatus:
+		return "status = $1, updated_at = CURRENT_TIMESTAMP", []interface{}{input.Status}, models.RevisionStatusChanged
+	case models.BulkMove:
+		return "project_id = $1, updated_at = CURRENT_TIMESTAMP", []interface{}{input.ProjectID}, models.RevisionUpdated
+	case models.BulkAssign:
+		return "assignee_id = $1, updated_at = CURRENT_TIMESTAMP", []interface{}{assigneeValue(input.AssigneeID)}, models.RevisionUpdated
+	case models.BulkAddLabel:
+		return "labels = CASE WHEN $1 = ANY(labels) THEN labels ELSE array_append(labels, $1) END, updated_at = CURRENT_TIMESTAMP", []interface{}{input.Label}, models.RevisionUpdated
+	case models.BulkRemoveLabel:
+		return "labels = array_remove(labels, $1), updated_at = CURRENT_TIMESTAMP", []interface{}{input.Label}, models.RevisionUpdated
+	default: // models.BulkDelete
+		return "deleted_at = CURRENT_TIMESTAMP", nil, models.RevisionDeleted
+	}
+}
+
+// applyBulkItem applies the operation to a single task inside tx and records its revision
+func applyBulkItem(tx *sql.Tx, userID, workspaceID, taskID int, input models.BulkTaskInput) (models.Task, error) {
+	var before models.Task
+	err := scanTask(tx.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = $1 AND deleted_at IS NULL AND "+authz.TaskCondition(authz.TaskView, "tasks", 2, 3)+" FOR UPDATE", taskID, userID, workspaceID), &before)
+	if err == sql.ErrNoRows {
+		return before, errBulkTaskNotFound
+	}
+	if err != nil {
+		return before, err
+	}
+
+	perm := authz.TaskUpdate
+	if input.Operation == models.BulkDelete {
+		perm = authz.TaskDelete
+	}
+	roles, err := authz.ProjectRolesOf(tx, userID, workspaceID, before.ProjectID)
+	if err != nil {
+		return before, err
+	}
+	if !roles.Can(perm) {
+		return before, errBulkTaskForbidden
+	}
+
+	setClause, args, action := bulkUpdate(input)
+	args = append(args, taskID)
+	var task models.Task
+	err = scanTask(tx.QueryRow(
+		fmt.Sprintf("UPDATE tasks SET %s, version = version + 1 WHERE id = $%d RETURNING %s", setClause, len(args), taskColumns),
+		args...,
+	), &task)
+	if err != nil {
+		return before, err
+	}
+
+	if action == models.RevisionDeleted {
+		err = recordTaskRevision(tx, userID, action, &before, nil)
+	} else {
+		err = recordTaskRevision(tx, userID, action, &before, &task)
+	}
+	return task, err
+}
+
+// BulkUpdateTasks handles POST /tasks/bulk to apply one operation to many tasks in a single transaction.
+// Each task runs under its own savepoint so failures are reported per item; with "atomic"
+// any failure rolls back the whole batch.
+func BulkUpdateTasks(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+	workspaceID := c.GetInt("workspace_id")
+
+	// Bind and validate request body
+	var input models.BulkTaskInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(input); err != nil {
+		var errs []string
+		for _, err := range err.(validator.ValidationErrors) {
+			errs = append(errs, fmt.Sprintf("Field '%s' failed on '%s'", err.Field(), err.Tag()))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+	input.Label = SanitizeInput(input.Label)
+
+	// Check the operation's target once rather than per task
+	switch input.Operation {
+	case models.BulkMove:
+		exists, err := checkProject(db, authz.TaskCreate, userIDInt, workspaceID, input.ProjectID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Project with ID %d not found", input.ProjectID)})
+			return
+		}
+	case models.BulkAssign:
+		if ok, err := checkAssignee(db, workspaceID, input.AssigneeID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		} else if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Assignee not found"})
+			return
+		}
+	case models.BulkAddLabel, models.BulkRemoveLabel:
+		if input.Label == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Label is required"})
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	seen := make(map[int]bool)
+	results := []models.BulkItemResult{}
+	var changed []models.Task
+	failed := 0
+	for _, taskID := range input.TaskIDs {
+		if seen[taskID] {
+			continue
+		}
+		seen[taskID] = true
+
+		if _, err := tx.Exec("SAVEPOINT bulk_item"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		task, err := applyBulkItem(tx, userIDInt, workspaceID, taskID, input)
+		if err != nil {
+			if _, rbErr := tx.Exec("ROLLBACK TO SAVEPOINT bulk_item"); rbErr != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			failed++
+			message := "Database error"
+			if errors.Is(err, errBulkTaskNotFound) {
+				message = fmt.Sprintf("Task with ID %d not found", taskID)
+			} else if errors.Is(err, errBulkTaskForbidden) {
+				message = fmt.Sprintf("You don't have permission to change task %d", taskID)
+			} else {
+				log.Printf("Bulk %s error for task %d: %v", input.Operation, taskID, err)
+			}
+			results = append(results, models.BulkItemResult{TaskID: taskID, Error: message})
+			continue
+		}
+
+		if _, err := tx.Exec("RELEASE SAVEPOINT bulk_item"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		changed = append(changed, task)
+		results = append(results, models.BulkItemResult{TaskID: taskID, Success: true, Task: &task})
+	}
+
+	summary := gin.H{"total": len(results), "succeeded": len(changed), "failed": failed}
+
+	// In atomic mode a single failure discards the whole batch
+	if input.Atomic && failed > 0 {
+		for i := range results {
+			if results[i].Success {
+				results[i].Success = false
+				results[i].Task = nil
+				results[i].Error = "Rolled back"
+			}
+		}
+		summary["succeeded"] = 0
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Bulk operation rolled back", "summary": summary, "results": results})
+		return
+	}
+
+	if input.Operation == models.BulkDelete {
+		for _, task := range changed {
+			err := recordAudit(c, tx, auditEvent{
+				Action: models.AuditTaskDeleted, TargetType: models.EntityTask, TargetID: task.ID,
+				Details: map[string]interface{}{"title": task.Title, "project_id": task.ProjectID, "bulk": true},
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Send one aggregated notification and a single batched event instead of one per task
+	if len(changed) > 0 {
+		taskIDs := make([]int, len(changed))
+		for i, task := range changed {
+			taskIDs[i] = task.ID
+		}
+		data := map[string]interface{}{"operation": input.Operation, "count": len(changed), "task_ids": taskIDs}
+
+		if err := Notify(db, NotificationEvent{UserID: userIDInt, ActorID: userIDInt, Type: models.NotificationTasksBulkUpdated, Data: data}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send notification"})
+			return
+		}
+		if input.Operation == models.BulkAssign && input.AssigneeID != nil && *input.AssigneeID != 0 && *input.AssigneeID != userIDInt {
+			if err := Notify(db, NotificationEvent{UserID: *input.AssigneeID, ActorID: userIDInt, Type: models.NotificationTasksBulkAssigned, Data: data}); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send notification"})
+				return
+			}
+		}
+		broadcastTaskBatch(db, input.Operation, changed)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Bulk operation completed", "summary": summary, "results": results})
+}
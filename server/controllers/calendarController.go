@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/authz"
+	"github.com/Inengs/realtime-task-app/ical"
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/Inengs/realtime-task-app/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const calendarProdID = "-//TaskFlow//Task Calendar//EN"
+
+// Calendar components a feed can list tasks as
+const (
+	calendarEvents = "vevent"
+	calendarTodos  = "vtodo"
+)
+
+// todoStatuses maps task statuses to VTODO STATUS values
+var todoStatuses = map[string]string{
+	"pending":     "NEEDS-ACTION",
+	"in-progress": "IN-PROCESS",
+	"done":        "COMPLETED",
+}
+
+// calendarURL returns the public feed URL for a calendar token
+func calendarURL(token string) string {
+	return utils.APIBaseURL() + "/calendar/" + token + ".ics"
+}
+
+// calendarUIDDomain is the right-hand side of task UIDs, so they stay unique across installations
+func calendarUIDDomain() string {
+	if parsed, err := url.Parse(utils.APIBaseURL()); err == nil && parsed.Hostname() != "" {
+		return parsed.Hostname()
+	}
+	return "taskflow"
+}
+
+// taskCalendarComponent renders a task with a due date as an all-day VEVENT or a VTODO
+func taskCalendarComponent(task models.Task, componentType string, due time.Time) ical.Component {
+	component := ical.Component{Name: "VEVENT"}
+	if componentType == calendarTodos {
+		component.Name = "VTODO"
+	}
+
+	component.Add("UID", fmt.Sprintf("task-%d@%s", task.ID, calendarUIDDomain()))
+	component.AddDateTime("DTSTAMP", task.UpdatedAt)
+	component.AddDateTime("CREATED", task.CreatedAt)
+	component.AddDateTime("LAST-MODIFIED", task.UpdatedAt)
+	component.Add("SEQUENCE", strconv.Itoa(task.Version))
+	component.AddText("SUMMARY", task.Title)
+
+	description := "Status: " + task.Status
+	if task.Description != "" {
+		description = task.Description + "\n\n" + description
+	}
+	component.AddText("DESCRIPTION", description)
+	component.Add("URL", utils.FrontendURL()+fmt.Sprintf("/tasks/%d", task.ID))
+
+	if len(task.Labels) > 0 {
+		categories := make([]string, len(task.Labels))
+		for i, label := range task.Labels {
+			categories[i] = ical.Escape(label)
+		}
+		component.Add("CATEGORIES", strings.Join(categories, ","))
+	}
+
+	if componentType == calendarTodos {
+		component.AddDate("DUE", due)
+		component.Add("STATUS", todoStatuses[task.Status])
+		if task.Status == "done" {
+			component.AddDateTime("COMPLETED", task.UpdatedAt)
+			component.Add("PERCENT-COMPLETE", "100")
+		}
+		return component
+	}
+
+	// All-day events end on the following day
+	component.AddDate("DTSTART", due)
+	component.AddDate("DTEND", due.AddDate(0, 0, 1))
+	component.Add("TRANSP", "TRANSPARENT")
+	component.Add("STATUS", "CONFIRMED")
+	return component
+}
+
+// GetCalendarFeedURL handles GET /calendar/feed
+func GetCalendarFeedURL(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	var token sql.NullString
+	if err := db.QueryRow("SELECT calendar_token FROM users WHERE id = $1", userIDInt).Scan(&token); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !token.Valid {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar feed is not enabled"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Calendar feed URL retrieved successfully", "url": calendarURL(token.String)})
+}
+
+// RotateCalendarToken handles POST /calendar/feed, enabling the feed or replacing its token
+// so the previous URL stops working
+func RotateCalendarToken(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	token, err := utils.GenerateVerificationToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate calendar token"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET calendar_token = $1 WHERE id = $2", token, userIDInt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := recordAudit(c, tx, auditEvent{Action: models.AuditCalendarTokenCreated, TargetType: models.AuditTargetUser, TargetID: userIDInt}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Calendar feed URL generated successfully", "url": calendarURL(token)})
+}
+
+// DisableCalendarFeed handles DELETE /calendar/feed
+func DisableCalendarFeed(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET calendar_token = NULL WHERE id = $1", userIDInt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := recordAudit(c, tx, auditEvent{Action: models.AuditCalendarTokenRevoked, TargetType: models.AuditTargetUser, TargetID: userIDInt}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Calendar feed disabled successfully"})
+}
+
+// CalendarFeed handles GET /calendar/:token.ics, the iCalendar feed of the tasks with a due date
+// that the token's user owns or is assigned, in projects they can still view. ?project_id= and ?assignee_id= filter the tasks, and
+// ?component=vtodo lists them as to-dos instead of all-day events.
+func CalendarFeed(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	token := strings.TrimSuffix(c.Param("token"), ".ics")
+	var userID int
+	var username string
+	err := db.QueryRow("SELECT id, username FROM users WHERE calendar_token = $1", token).Scan(&userID, &username)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar feed not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	componentType := c.DefaultQuery("component", calendarEvents)
+	if componentType != calendarEvents && componentType != calendarTodos {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "component must be vevent or vtodo"})
+		return
+	}
+
+	query := "SELECT " + taskColumns + " FROM tasks WHERE (user_id = $1 OR assignee_id = $1) AND " + authz.AnyWorkspaceTaskCondition(authz.TaskView, "tasks", 1) +
+		" AND deleted_at IS NULL AND due_date IS NOT NULL"
+	args := []interface{}{userID}
+	for _, filter := range []string{"project_id", "assignee_id"} {
+		value := c.Query(filter)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s", filter)})
+			return
+		}
+		args = append(args, id)
+		query += fmt.Sprintf(" AND %s = $%d", filter, len(args))
+	}
+
+	rows, err := db.Query(query+" ORDER BY due_date, id", args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	cal := ical.NewCalendar(calendarProdID)
+	cal.AddText("X-WR-CALNAME", "TaskFlow – "+username)
+	cal.Properties = append(cal.Properties, ical.Property{Name: "REFRESH-INTERVAL", Params: "VALUE=DURATION", Value: "PT1H"})
+	cal.Add("X-PUBLISHED-TTL", "PT1H")
+	for rows.Next() {
+		var task models.Task
+		if err := scanTask(rows, &task); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		due, err := time.Parse("2006-01-02", *task.DueDate)
+		if err != nil {
+			continue
+		}
+		cal.Components = append(cal.Components, taskCalendarComponent(task, componentType, due))
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var body bytes.Buffer
+	if err := cal.Write(&body); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not render calendar"})
+		return
+	}
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", body.Bytes())
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCalendarFeedLifecycle(t *testing.T) {
+	database := openTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	userID := createTestUser(t, database, fmt.Sprintf("calendar-%d@example.com", time.Now().UnixNano()), "password")
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("db", database)
+		c.Set("user_id", userID)
+	})
+	router.POST("/calendar/feed", RotateCalendarToken)
+	router.DELETE("/calendar/feed", DisableCalendarFeed)
+	router.GET("/calendar/:token", CalendarFeed)
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := request(http.MethodPost, "/calendar/feed")
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /calendar/feed = %d %s, want 200", w.Code, w.Body)
+	}
+	var body struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	feedPath := body.URL[strings.Index(body.URL, "/calendar/"):]
+
+	if w := request(http.MethodGet, feedPath); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "BEGIN:VCALENDAR") {
+		t.Fatalf("GET %s = %d %s, want a calendar", feedPath, w.Code, w.Body)
+	}
+
+	// Rotating replaces the token, so the old URL stops working
+	if w := request(http.MethodPost, "/calendar/feed"); w.Code != http.StatusOK {
+		t.Fatalf("second POST /calendar/feed = %d %s, want 200", w.Code, w.Body)
+	}
+	if w := request(http.MethodGet, feedPath); w.Code != http.StatusNotFound {
+		t.Errorf("GET rotated-out feed = %d, want 404", w.Code)
+	}
+
+	if w := request(http.MethodDelete, "/calendar/feed"); w.Code != http.StatusOK {
+		t.Fatalf("DELETE /calendar/feed = %d %s, want 200", w.Code, w.Body)
+	}
+	var token *string
+	if err := database.QueryRow("SELECT calendar_token FROM users WHERE id = $1", userID).Scan(&token); err != nil || token != nil {
+		t.Errorf("calendar_token after DELETE = %v (%v), want NULL", token, err)
+	}
+}
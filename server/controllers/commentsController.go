@@ -0,0 +1,345 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Inengs/realtime-task-app/authz"
+	"github.com/Inengs/realtime-task-app/mailin"
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/lib/pq"
+)
+
+const commentExcerptLength = 80
+
+// mentionPattern matches @username in comments, with the characters SanitizeUsername allows, unless it is
+// part of a word such as an email address
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9_-]{3,20})\b`)
+
+// commentColumns lists the comment columns in the order scanComment expects them
+const commentColumns = "id, task_id, user_id, body, source, created_at, updated_at"
+
+// attachmentColumns lists the attachment columns, without the content, in the order scanAttachment expects them
+const attachmentColumns = "id, task_id, comment_id, user_id, filename, content_type, size, created_at"
+
+// scanComment scans a row selected with commentColumns into comment
+func scanComment(row rowScanner, comment *models.TaskComment) error {
+	return row.Scan(&comment.ID, &comment.TaskID, &comment.UserID, &comment.Body, &comment.Source, &comment.CreatedAt, &comment.UpdatedAt)
+}
+
+// scanAttachment scans a row selected with attachmentColumns into attachment
+func scanAttachment(row rowScanner, attachment *models.TaskAttachment) error {
+	return row.Scan(&attachment.ID, &attachment.TaskID, &attachment.CommentID, &attachment.UserID, &attachment.Filename, &attachment.ContentType, &attachment.Size, &attachment.CreatedAt)
+}
+
+// insertComment adds a comment to a task
+func insertComment(db dbExecutor, taskID, userID int, body, source string) (models.TaskComment, error) {
+	var comment models.TaskComment
+	err := scanComment(db.QueryRow(
+		"INSERT INTO task_comments (task_id, user_id, body, source) VALUES ($1, $2, $3, $4) RETURNING "+commentColumns,
+		taskID, userID, body, source,
+	), &comment)
+	return comment, err
+}
+
+// insertAttachments stores files on a task, linked to a comment when commentID is not nil
+func insertAttachments(db dbExecutor, taskID, userID int, commentID *int, attachments []mailin.Attachment) error {
+	for _, attachment := range attachments {
+		_, err := db.Exec(
+			"INSERT INTO task_attachments (task_id, comment_id, user_id, filename, content_type, size, content) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+			taskID, commentID, userID, attachment.Filename, attachment.ContentType, len(attachment.Data), attachment.Data,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mentionedUsers returns the users @mentioned in body who can view the task, each once
+func mentionedUsers(db *sql.DB, task models.Task, body string) (map[int]bool, error) {
+	var usernames []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		usernames = append(usernames, match[1])
+	}
+	mentioned := map[int]bool{}
+	if len(usernames) == 0 {
+		return mentioned, nil
+	}
+
+	rows, err := db.Query("SELECT id FROM users WHERE username = ANY($1)", pq.Array(usernames))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	found := map[int]bool{}
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		found[userID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return mentioned, nil
+	}
+
+	// Mentioning someone doesn't show them a task they can't see
+	viewers, err := authz.ProjectUsers(db, authz.TaskView, task.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	for _, userID := range viewers {
+		if found[userID] {
+			mentioned[userID] = true
+		}
+	}
+	return mentioned, nil
+}
+
+// notifyComment tells the users @mentioned in a new comment, and the task's owner and assignee, about it,
+// except its author. Owners and assignees who are mentioned get only the mention.
+func notifyComment(db *sql.DB, task models.Task, comment models.TaskComment) error {
+	excerpt := []rune(strings.Join(strings.Fields(comment.Body), " "))
+	if len(excerpt) > commentExcerptLength {
+		excerpt = append(excerpt[:commentExcerptLength], '…')
+	}
+	data := map[string]interface{}{"comment_id": comment.ID, "excerpt": string(excerpt)}
+
+	// A new comment always has its author
+	authorID := *comment.UserID
+
+	mentioned, err := mentionedUsers(db, task, comment.Body)
+	if err != nil {
+		return err
+	}
+	for recipientID := range mentioned {
+		if recipientID == authorID {
+			continue
+		}
+		if err := notifyTask(db, recipientID, authorID, models.NotificationTaskMentioned, task, data); err != nil {
+			return err
+		}
+	}
+
+	recipients := []int{task.UserID}
+	if task.AssigneeID != nil && *task.AssigneeID != task.UserID {
+		recipients = append(recipients, *task.AssigneeID)
+	}
+	for _, recipientID := range recipients {
+		if recipientID == authorID || mentioned[recipientID] {
+			continue
+		}
+		if err := notifyTask(db, recipientID, authorID, models.NotificationTaskCommented, task, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scopedTask parses :id and loads a task the user can see in the active workspace, writing the error response if it can't
+func scopedTask(c *gin.Context, db *sql.DB, userID int) (models.Task, bool) {
+	var task models.Task
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+		return task, false
+	}
+
+	err = scanTask(db.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = $1 AND deleted_at IS NULL AND "+authz.TaskCondition(authz.TaskView, "tasks", 2, 3), id, userID, c.GetInt("workspace_id")), &task)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Task with ID %d not found", id)})
+		return task, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return task, false
+	}
+	return task, true
+}
+
+// ListTaskComments handles GET /tasks/:id/comments, oldest first
+func ListTaskComments(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	task, ok := scopedTask(c, db, userIDInt)
+	if !ok {
+		return
+	}
+
+	rows, err := db.Query("SELECT "+commentColumns+" FROM task_comments WHERE task_id = $1 ORDER BY id", task.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	comments := []models.TaskComment{}
+	for rows.Next() {
+		var comment models.TaskComment
+		if err := scanComment(rows, &comment); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		comments = append(comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Comments retrieved successfully", "comments": comments})
+}
+
+// CreateTaskComment handles POST /tasks/:id/comments
+func CreateTaskComment(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	task, ok := scopedTask(c, db, userIDInt)
+	if !ok || !authz.ProjectAllowed(c, db, task.ProjectID, authz.TaskComment) {
+		return
+	}
+
+	// Bind and validate request body
+	var input models.CommentInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	input.Body = strings.TrimSpace(input.Body)
+
+	validate := validator.New()
+	if err := validate.Struct(input); err != nil {
+		var errs []string
+		for _, err := range err.(validator.ValidationErrors) {
+			errs = append(errs, fmt.Sprintf("Field '%s' failed on '%s'", err.Field(), err.Tag()))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	comment, err := insertComment(db, task.ID, userIDInt, input.Body, models.CommentSourceAPI)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := notifyComment(db, task, comment); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send notification"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Comment added successfully", "comment": comment})
+}
+
+// ListTaskAttachments handles GET /tasks/:id/attachments
+func ListTaskAttachments(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	task, ok := scopedTask(c, db, userIDInt)
+	if !ok {
+		return
+	}
+
+	rows, err := db.Query("SELECT "+attachmentColumns+" FROM task_attachments WHERE task_id = $1 ORDER BY id", task.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	attachments := []models.TaskAttachment{}
+	for rows.Next() {
+		var attachment models.TaskAttachment
+		if err := scanAttachment(rows, &attachment); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		attachments = append(attachments, attachment)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Attachments retrieved successfully", "attachments": attachments})
+}
+
+// DownloadTaskAttachment handles GET /tasks/:id/attachments/:attachmentId, sending the file itself
+func DownloadTaskAttachment(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	task, ok := scopedTask(c, db, userIDInt)
+	if !ok {
+		return
+	}
+
+	attachmentID, err := strconv.Atoi(c.Param("attachmentId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attachment ID"})
+		return
+	}
+
+	var filename, contentType string
+	var content []byte
+	err = db.QueryRow("SELECT filename, content_type, content FROM task_attachments WHERE id = $1 AND task_id = $2", attachmentID, task.ID).Scan(&filename, &contentType, &content)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Attachment with ID %d not found", attachmentID)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Always download rather than render, since the content came from outside
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	c.Header("Content-Disposition", disposition)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(http.StatusOK, contentType, content)
+}
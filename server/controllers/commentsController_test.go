@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMentionPattern(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{"@alice can you look?", []string{"alice"}},
+		{"thanks @bob_2 and @carol-x.", []string{"bob_2", "carol-x"}},
+		{"(@dave)", []string{"dave"}},
+		{"mail me at erin@example.com", nil},
+		{"@@frank", nil},
+		{"@al is too short", nil},
+		{"@abcdefghijklmnopqrstuvwxyz is too long", nil},
+	}
+	for _, tc := range tests {
+		var got []string
+		for _, match := range mentionPattern.FindAllStringSubmatch(tc.body, -1) {
+			got = append(got, match[1])
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("mentions in %q = %v, want %v", tc.body, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resourceETag builds the strong ETag for a versioned row, e.g. "task-12-v3"
+func resourceETag(kind string, id, version int) string {
+	return fmt.Sprintf(`"%s-%d-v%d"`, kind, id, version)
+}
+
+// etagListMatches reports whether a comma-separated If-Match/If-None-Match header
+// contains etag. Weak validators only match when weak is true.
+func etagListMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// preconditionFailed reports whether the request carries an If-Match header
+// that does not match the current etag. Requests without If-Match always pass.
+func preconditionFailed(c *gin.Context, etag string) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return false
+	}
+	return !etagListMatches(header, etag, false)
+}
+
+// notModified sets the ETag header and reports whether If-None-Match matches it,
+// in which case a 304 has already been written
+func notModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+	header := c.GetHeader("If-None-Match")
+	if header == "" || !etagListMatches(header, etag, true) {
+		return false
+	}
+	c.Status(http.StatusNotModified)
+	return true
+}
@@ -0,0 +1,307 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/authz"
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Export formats
+const (
+	exportCSV    = "csv"
+	exportJSON   = "json"
+	exportNDJSON = "ndjson"
+)
+
+const exportFlushEvery = 100 // Rows written between flushes to the client
+
+// exportContentTypes is the Content-Type of each export format
+var exportContentTypes = map[string]string{
+	exportCSV:    "text/csv; charset=utf-8",
+	exportJSON:   "application/json; charset=utf-8",
+	exportNDJSON: "application/x-ndjson; charset=utf-8",
+}
+
+// exportExtraColumns are selected after taskColumns for every exported task
+const exportExtraColumns = `,
+	(SELECT p.name FROM projects p WHERE p.id = tasks.project_id),
+	(SELECT u.username FROM users u WHERE u.id = tasks.assignee_id),
+	(SELECT u.email FROM users u WHERE u.id = tasks.assignee_id)`
+
+// exportCommentsColumn aggregates a task's comments, and is only selected when they are exported
+const exportCommentsColumn = `,
+	(SELECT coalesce(json_agg(json_build_object(
+		'id', c.id, 'user_id', c.user_id, 'username', COALESCE(u.username, '` + models.DeletedUserName + `'), 'body', c.body, 'source', c.source, 'created_at', c.created_at
+	) ORDER BY c.id), '[]') FROM task_comments c LEFT JOIN users u ON u.id = c.user_id WHERE c.task_id = tasks.id)`
+
+// exportComment is a comment as it appears in exports
+type exportComment struct {
+	ID        int    `json:"id"`
+	UserID    *int   `json:"user_id"`
+	Username  string `json:"username"`
+	Body      string `json:"body"`
+	Source    string `json:"source"`
+	CreatedAt string `json:"created_at"`
+}
+
+// exportRow is one task with the related data an export can include
+type exportRow struct {
+	Task             models.Task
+	ProjectName      *string
+	AssigneeUsername *string
+	AssigneeEmail    *string
+	Comments         []exportComment
+}
+
+// exportColumn is one field of an export
+type exportColumn struct {
+	Name  string
+	Value func(row *exportRow) interface{}
+}
+
+// exportColumns lists every exportable column in default order
+var exportColumns = []exportColumn{
+	{"id", func(row *exportRow) interface{} { return row.Task.ID }},
+	{"project_id", func(row *exportRow) interface{} { return row.Task.ProjectID }},
+	{"project_name", func(row *exportRow) interface{} { return row.ProjectName }},
+	{"title", func(row *exportRow) interface{} { return row.Task.Title }},
+	{"description", func(row *exportRow) interface{} { return row.Task.Description }},
+	{"status", func(row *exportRow) interface{} { return row.Task.Status }},
+	{"labels", func(row *exportRow) interface{} { return row.Task.Labels }},
+	{"due_date", func(row *exportRow) interface{} { return row.Task.DueDate }},
+	{"assignee_id", func(row *exportRow) interface{} { return row.Task.AssigneeID }},
+	{"assignee_username", func(row *exportRow) interface{} { return row.AssigneeUsername }},
+	{"assignee_email", func(row *exportRow) interface{} { return row.AssigneeEmail }},
+	{"created_by", func(row *exportRow) interface{} { return row.Task.UserID }},
+	{"version", func(row *exportRow) interface{} { return row.Task.Version }},
+	{"created_at", func(row *exportRow) interface{} { return row.Task.CreatedAt }},
+	{"updated_at", func(row *exportRow) interface{} { return row.Task.UpdatedAt }},
+	{"comments", func(row *exportRow) interface{} { return row.Comments }},
+}
+
+// extraScanner appends destinations for columns selected after taskColumns, so scanTask can be reused
+type extraScanner struct {
+	row   rowScanner
+	extra []interface{}
+}
+
+func (s extraScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+// parseExportColumns resolves ?columns=a,b,c, defaulting to every column
+func parseExportColumns(value string) ([]exportColumn, error) {
+	if value == "" {
+		return exportColumns, nil
+	}
+	var columns []exportColumn
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, column := range exportColumns {
+			if column.Name == name {
+				columns = append(columns, column)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+	}
+	return columns, nil
+}
+
+// csvFormulaPrefixes start cells that spreadsheets would evaluate as formulas
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// csvText formats an export value as a CSV cell. Text that a spreadsheet would run as a formula
+// is prefixed with a quote.
+func csvText(value interface{}) string {
+	var text string
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case int:
+		return strconv.Itoa(v)
+	case *int:
+		if v == nil {
+			return ""
+		}
+		return strconv.Itoa(*v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case string:
+		text = v
+	case *string:
+		if v == nil {
+			return ""
+		}
+		text = *v
+	case []string:
+		text = strings.Join(v, ", ")
+	case []exportComment:
+		lines := make([]string, len(v))
+		for i, comment := range v {
+			lines[i] = fmt.Sprintf("%s (%s): %s", comment.Username, comment.CreatedAt, comment.Body)
+		}
+		text = strings.Join(lines, "\n")
+	default:
+		text = fmt.Sprint(v)
+	}
+	if text != "" && strings.ContainsRune(csvFormulaPrefixes, rune(text[0])) {
+		text = "'" + text
+	}
+	return text
+}
+
+// streamTaskExport writes the tasks matching where as CSV, a JSON array or NDJSON, one row at a time
+func streamTaskExport(c *gin.Context, db *sql.DB, filename, where string, args ...interface{}) {
+	format := c.DefaultQuery("format", exportCSV)
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv, json or ndjson"})
+		return
+	}
+	columns, err := parseExportColumns(c.Query("columns"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	withComments := false
+	for _, column := range columns {
+		if column.Name == "comments" {
+			withComments = true
+		}
+	}
+	query := "SELECT " + taskColumns + exportExtraColumns
+	if withComments {
+		query += exportCommentsColumn
+	}
+
+	rows, err := db.Query(query+" FROM tasks WHERE "+where+" ORDER BY id", args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, filename, format))
+	c.Status(http.StatusOK)
+
+	csvWriter := csv.NewWriter(c.Writer)
+	encoder := json.NewEncoder(c.Writer)
+	switch format {
+	case exportCSV:
+		header := make([]string, len(columns))
+		for i, column := range columns {
+			header[i] = column.Name
+		}
+		csvWriter.Write(header)
+	case exportJSON:
+		c.Writer.WriteString("[\n")
+	}
+
+	count := 0
+	for rows.Next() {
+		var row exportRow
+		var comments []byte
+		extra := []interface{}{&row.ProjectName, &row.AssigneeUsername, &row.AssigneeEmail}
+		if withComments {
+			extra = append(extra, &comments)
+		}
+		if err := scanTask(extraScanner{row: rows, extra: extra}, &row.Task); err != nil {
+			log.Printf("Export scan error: %v", err)
+			return
+		}
+		if row.Task.Labels == nil {
+			row.Task.Labels = []string{}
+		}
+		if withComments {
+			if err := json.Unmarshal(comments, &row.Comments); err != nil {
+				log.Printf("Export comments error for task %d: %v", row.Task.ID, err)
+				return
+			}
+		}
+
+		switch format {
+		case exportCSV:
+			record := make([]string, len(columns))
+			for i, column := range columns {
+				record[i] = csvText(column.Value(&row))
+			}
+			csvWriter.Write(record)
+		default:
+			object := make(map[string]interface{}, len(columns))
+			for _, column := range columns {
+				object[column.Name] = column.Value(&row)
+			}
+			if format == exportJSON && count > 0 {
+				c.Writer.WriteString(",\n")
+			}
+			// Encode ends each object with a newline, which is exactly what NDJSON needs
+			if err := encoder.Encode(object); err != nil {
+				log.Printf("Export encode error for task %d: %v", row.Task.ID, err)
+				return
+			}
+		}
+
+		count++
+		if count%exportFlushEvery == 0 {
+			csvWriter.Flush()
+			c.Writer.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Export rows error: %v", err)
+		return
+	}
+
+	if format == exportJSON {
+		c.Writer.WriteString("]\n")
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		log.Printf("Export write error: %v", err)
+	}
+}
+
+// ExportProjectTasks handles GET /projects/:id/export?format=csv|json|ndjson&columns=...
+func ExportProjectTasks(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	projectID, ok := authz.Project(c, db, authz.TaskView)
+	if !ok {
+		return
+	}
+
+	streamTaskExport(c, db, fmt.Sprintf("project-%d-tasks", projectID), "project_id = $1 AND deleted_at IS NULL", projectID)
+}
+
+// ExportAllTasks handles GET /tasks/export?format=csv|json|ndjson&columns=..., exporting the tasks of every project
+// the user can see in the active workspace
+func ExportAllTasks(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	streamTaskExport(c, db, "tasks", authz.TaskCondition(authz.TaskView, "tasks", 1, 2)+" AND deleted_at IS NULL", userIDInt, c.GetInt("workspace_id"))
+}
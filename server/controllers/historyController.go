@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/Inengs/realtime-task-app/authz"
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultActivityLimit = 50
+	maxActivityLimit     = 200
+)
+
+// revisionColumns lists the task_revisions columns in the order scanRevision expects them
+const revisionColumns = "r.id, r.task_id, r.project_id, r.actor_id, u.username, r.action, r.changes, r.created_at"
+
+// taskFields flattens the tracked fields of a task so two versions can be compared
+func taskFields(task *models.Task) map[string]interface{} {
+	if task == nil {
+		return map[string]interface{}{}
+	}
+	// Store values rather than pointers so they compare and serialize cleanly
+	var assignee interface{}
+	if task.AssigneeID != nil {
+		assignee = *task.AssigneeID
+	}
+	labels := task.Labels
+	if labels == nil {
+		labels = []string{}
+	}
+	var dueDate interface{}
+	if task.DueDate != nil {
+		dueDate = *task.DueDate
+	}
+	return map[string]interface{}{
+		"title":       task.Title,
+		"description": task.Description,
+		"status":      task.Status,
+		"project_id":  task.ProjectID,
+		"assignee_id": assignee,
+		"labels":      labels,
+		"due_date":    dueDate,
+	}
+}
+
+// diffTasks returns the fields whose values differ between before and after.
+// A nil before describes a creation and a nil after a deletion.
+func diffTasks(before, after *models.Task) map[string]models.FieldChange {
+	oldFields, newFields := taskFields(before), taskFields(after)
+	changes := make(map[string]models.FieldChange)
+	for _, field := range []string{"title", "description", "status", "project_id", "assignee_id", "labels", "due_date"} {
+		oldValue, hadOld := oldFields[field]
+		newValue, hasNew := newFields[field]
+		if hadOld && hasNew && reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		changes[field] = models.FieldChange{From: oldValue, To: newValue}
+	}
+	return changes
+}
+
+// recordTaskRevision writes a task_revisions row describing the change from before to after.
+// Updates and status changes that change nothing are not recorded.
+func recordTaskRevision(db dbExecutor, actorID int, action string, before, after *models.Task) error {
+	changes := diffTasks(before, after)
+	if len(changes) == 0 && (action == models.RevisionUpdated || action == models.RevisionStatusChanged) {
+		return nil
+	}
+
+	subject := after
+	if subject == nil {
+		subject = before
+	}
+
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO task_revisions (task_id, project_id, actor_id, action, changes) VALUES ($1, $2, $3, $4, $5)",
+		subject.ID, subject.ProjectID, actorID, action, changesJSON,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Every recorded revision is also a webhook event for the task's project
+	return queueTaskWebhooks(db, actorID, action, subject, changes)
+}
+
+// scanRevision scans a row selected with revisionColumns into revision
+func scanRevision(row rowScanner, revision *models.TaskRevision) error {
+	var actorID sql.NullInt64
+	var actorUsername sql.NullString
+	var changesJSON []byte
+	if err := row.Scan(&revision.ID, &revision.TaskID, &revision.ProjectID, &actorID, &actorUsername, &revision.Action, &changesJSON, &revision.CreatedAt); err != nil {
+		return err
+	}
+	if actorID.Valid {
+		id := int(actorID.Int64)
+		revision.ActorID = &id
+	}
+	if actorUsername.Valid {
+		revision.ActorUsername = &actorUsername.String
+	}
+	return json.Unmarshal(changesJSON, &revision.Changes)
+}
+
+// queryRevisions runs a revision query and collects the results
+func queryRevisions(db dbExecutor, query string, args ...interface{}) ([]models.TaskRevision, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []models.TaskRevision{}
+	for rows.Next() {
+		var revision models.TaskRevision
+		if err := scanRevision(rows, &revision); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+// TaskHistory handles GET /tasks/:id/history to return a task's change history, oldest first
+func TaskHistory(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+		return
+	}
+
+	// Check the user can see the task in the active workspace
+	var exists bool
+	err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM tasks WHERE id = $1 AND deleted_at IS NULL AND "+authz.TaskCondition(authz.TaskView, "tasks", 2, 3)+")", id, userIDInt, c.GetInt("workspace_id")).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Task with ID %d not found", id)})
+		return
+	}
+
+	revisions, err := queryRevisions(db,
+		"SELECT "+revisionColumns+" FROM task_revisions r LEFT JOIN users u ON u.id = r.actor_id WHERE r.task_id = $1 ORDER BY r.created_at, r.id",
+		id,
+	)
+	if err != nil {
+		log.Printf("Task history error for task %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Task history retrieved successfully", "history": revisions})
+}
+
+// ProjectActivity handles GET /projects/:id/activity to return the project's task changes, newest first.
+// Pass the last seen revision ID as ?before= to page backwards.
+func ProjectActivity(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	limit := defaultActivityLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		if limit > maxActivityLimit {
+			limit = maxActivityLimit
+		}
+	}
+
+	before := 0
+	if beforeStr := c.Query("before"); beforeStr != "" {
+		before, err = strconv.Atoi(beforeStr)
+		if err != nil || before <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+	}
+
+	// Check the user can see the project in the active workspace
+	exists, err := checkProject(db, authz.ProjectView, userIDInt, c.GetInt("workspace_id"), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Project with ID %d not found", id)})
+		return
+	}
+
+	revisions, err := queryRevisions(db,
+		"SELECT "+revisionColumns+" FROM task_revisions r LEFT JOIN users u ON u.id = r.actor_id WHERE r.project_id = $1 AND ($2 = 0 OR r.id < $2) ORDER BY r.id DESC LIMIT $3",
+		id, before, limit,
+	)
+	if err != nil {
+		log.Printf("Project activity error for project %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	response := gin.H{"message": "Project activity retrieved successfully", "activity": revisions}
+	if len(revisions) == limit {
+		response["next_cursor"] = revisions[len(revisions)-1].ID
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,469 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Inengs/realtime-task-app/authz"
+	"github.com/Inengs/realtime-task-app/importer"
+	"github.com/Inengs/realtime-task-app/jobs"
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/lib/pq"
+)
+
+const (
+	maxImportSize       = 10 << 20 // 10 MB
+	maxImportRows       = 5000
+	importProgressEvery = 25 // Rows between progress events
+	importListLimit     = 50
+)
+
+// importColumns lists the task import columns, without the file, in the order scanImport expects them
+const importColumns = "id, project_id, user_id, source, mapping, dry_run, status, total, processed, created, failed, error, created_at, updated_at, finished_at"
+
+// importSources are the formats an import can read
+var importSources = map[string]bool{importer.SourceCSV: true, importer.SourceTrello: true, importer.SourceJira: true}
+
+// scanImport scans a row selected with importColumns into taskImport
+func scanImport(row rowScanner, taskImport *models.TaskImport) error {
+	var mapping []byte
+	err := row.Scan(
+		&taskImport.ID, &taskImport.ProjectID, &taskImport.UserID, &taskImport.Source, &mapping, &taskImport.DryRun, &taskImport.Status,
+		&taskImport.Total, &taskImport.Processed, &taskImport.Created, &taskImport.Failed, &taskImport.Error,
+		&taskImport.CreatedAt, &taskImport.UpdatedAt, &taskImport.FinishedAt,
+	)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(mapping, &taskImport.Mapping)
+}
+
+// parseImportMapping reads the mapping form field, a JSON object from fields to CSV column headers
+func parseImportMapping(value string) (map[string]string, error) {
+	mapping := map[string]string{}
+	if value == "" {
+		return mapping, nil
+	}
+	if err := json.Unmarshal([]byte(value), &mapping); err != nil {
+		return nil, errors.New("Mapping must be a JSON object of field names to column headers")
+	}
+	for field := range mapping {
+		known := false
+		for _, name := range importer.Fields {
+			if field == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("Unknown field '%s' in mapping", field)
+		}
+	}
+	return mapping, nil
+}
+
+// CreateTaskImport handles POST /projects/:id/import, a multipart form with the file to import, its
+// source (csv, trello or jira), an optional CSV column mapping and dry_run. The import runs as a
+// background job and reports its progress over WebSocket.
+func CreateTaskImport(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	projectID, ok := authz.Project(c, db, authz.TaskCreate)
+	if !ok {
+		return
+	}
+
+	// Leave room for the other form fields and multipart headers
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize+64<<10)
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Import files can be at most %d MB", maxImportSize>>20)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A file is required"})
+		return
+	}
+	if fileHeader.Size > maxImportSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Import files can be at most %d MB", maxImportSize>>20)})
+		return
+	}
+
+	source := c.DefaultPostForm("source", importer.SourceCSV)
+	if !importSources[source] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "source must be csv, trello or jira"})
+		return
+	}
+	mapping, err := parseImportMapping(c.PostForm("mapping"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if source != importer.SourceCSV && len(mapping) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A mapping can only be used with CSV imports"})
+		return
+	}
+	dryRun, err := strconv.ParseBool(c.DefaultPostForm("dry_run", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dry_run must be true or false"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read the file"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read the file"})
+		return
+	}
+	mappingJSON, _ := json.Marshal(mapping)
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var taskImport models.TaskImport
+	err = scanImport(tx.QueryRow(
+		"INSERT INTO task_imports (project_id, user_id, source, mapping, dry_run, file) VALUES ($1, $2, $3, $4, $5, $6) RETURNING "+importColumns,
+		projectID, userIDInt, source, mappingJSON, dryRun, data,
+	), &taskImport)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := jobs.Enqueue(tx, jobs.TypeTaskImport, jobs.TaskImportPayload{ImportID: taskImport.ID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Import queued successfully", "import": taskImport})
+}
+
+// ListTaskImports handles GET /projects/:id/imports, newest first
+func ListTaskImports(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	projectID, ok := authz.Project(c, db, authz.TaskView)
+	if !ok {
+		return
+	}
+
+	rows, err := db.Query("SELECT "+importColumns+" FROM task_imports WHERE project_id = $1 ORDER BY id DESC LIMIT $2", projectID, importListLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	imports := []models.TaskImport{}
+	for rows.Next() {
+		var taskImport models.TaskImport
+		if err := scanImport(rows, &taskImport); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		imports = append(imports, taskImport)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Imports retrieved successfully", "imports": imports})
+}
+
+// GetTaskImport handles GET /projects/:id/imports/:importId, the import with the result of each processed row
+func GetTaskImport(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	projectID, ok := authz.Project(c, db, authz.TaskView)
+	if !ok {
+		return
+	}
+	importID, err := strconv.Atoi(c.Param("importId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import ID"})
+		return
+	}
+
+	var taskImport models.TaskImport
+	err = scanImport(db.QueryRow("SELECT "+importColumns+" FROM task_imports WHERE id = $1 AND project_id = $2", importID, projectID), &taskImport)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Import with ID %d not found", importID)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	rows, err := db.Query("SELECT result FROM task_import_rows WHERE import_id = $1 ORDER BY position", importID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	results := []models.ImportRowResult{}
+	for rows.Next() {
+		var data []byte
+		var result models.ImportRowResult
+		if err := rows.Scan(&data); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if err := json.Unmarshal(data, &result); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Import retrieved successfully", "import": taskImport, "rows": results})
+}
+
+// RunTaskImport handles jobs.TypeTaskImport jobs. Each row is created and recorded in its own
+// transaction, so a retried job resumes after the last recorded row. When the job runs out of
+// time it queues a follow-up job to carry on instead of failing.
+func RunTaskImport(ctx context.Context, db *sql.DB, payload json.RawMessage) error {
+	var p jobs.TaskImportPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	var taskImport models.TaskImport
+	var file []byte
+	err := scanImport(extraScanner{
+		row:   db.QueryRowContext(ctx, "SELECT "+importColumns+", file FROM task_imports WHERE id = $1", p.ImportID),
+		extra: []interface{}{&file},
+	}, &taskImport)
+	if err == sql.ErrNoRows {
+		return nil // The project was deleted
+	}
+	if err != nil {
+		return err
+	}
+	if taskImport.Status == models.ImportCompleted || taskImport.Status == models.ImportFailed {
+		return nil
+	}
+
+	rows, err := importer.Parse(taskImport.Source, file, taskImport.Mapping)
+	if err == nil && len(rows) > maxImportRows {
+		err = fmt.Errorf("the file has %d tasks; at most %d can be imported at once", len(rows), maxImportRows)
+	}
+	if err != nil {
+		return finishImport(db, &taskImport, models.ImportFailed, err.Error())
+	}
+
+	_, err = db.Exec("UPDATE task_imports SET status = $1, total = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3", models.ImportRunning, len(rows), taskImport.ID)
+	if err != nil {
+		return err
+	}
+	taskImport.Status = models.ImportRunning
+	taskImport.Total = len(rows)
+	manager.BroadcastImport(taskImport.UserID, taskImport, "import_progress")
+
+	assignees, err := importAssignees(db, taskImport.ProjectID, rows)
+	if err != nil {
+		return err
+	}
+
+	validate := validator.New()
+	for position := taskImport.Processed; position < len(rows); position++ {
+		if ctx.Err() != nil {
+			return jobs.Enqueue(db, jobs.TypeTaskImport, p)
+		}
+		if err := importRow(db, validate, &taskImport, position, rows[position], assignees); err != nil {
+			return err
+		}
+		if taskImport.Processed%importProgressEvery == 0 {
+			manager.BroadcastImport(taskImport.UserID, taskImport, "import_progress")
+		}
+	}
+
+	return finishImport(db, &taskImport, models.ImportCompleted, "")
+}
+
+// importAssignees looks up the members of the project's workspace whose emails the rows assign tasks to, by lower-cased email
+func importAssignees(db *sql.DB, projectID int, rows []importer.Row) (map[string]int, error) {
+	var emails []string
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		email := strings.ToLower(row.AssigneeEmail)
+		if email != "" && !seen[email] {
+			seen[email] = true
+			emails = append(emails, email)
+		}
+	}
+
+	assignees := make(map[string]int, len(emails))
+	if len(emails) == 0 {
+		return assignees, nil
+	}
+	result, err := db.Query(
+		"SELECT u.id, lower(u.email) FROM users u JOIN workspace_members m ON m.user_id = u.id JOIN projects p ON p.workspace_id = m.workspace_id WHERE p.id = $1 AND lower(u.email) = ANY($2)",
+		projectID, pq.Array(emails),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+	for result.Next() {
+		var id int
+		var email string
+		if err := result.Scan(&id, &email); err != nil {
+			return nil, err
+		}
+		assignees[email] = id
+	}
+	return assignees, result.Err()
+}
+
+// importRow validates one row and, unless it has errors or this is a dry run, creates its task
+// and comments. The row's result and the import's counters are saved in the same transaction.
+func importRow(db *sql.DB, validate *validator.Validate, taskImport *models.TaskImport, position int, row importer.Row, assignees map[string]int) error {
+	input := models.TaskInput{
+		Title:       SanitizeInput(row.Title),
+		Description: row.Description,
+		Status:      row.Status,
+		ProjectID:   taskImport.ProjectID,
+		Labels:      row.Labels,
+	}
+	if row.DueDate != "" {
+		input.DueDate = &row.DueDate
+	}
+
+	result := models.ImportRowResult{
+		Line:     row.Line,
+		Title:    input.Title,
+		Status:   input.Status,
+		Labels:   normalizeLabels(row.Labels),
+		DueDate:  input.DueDate,
+		Comments: len(row.Comments),
+		Errors:   row.Errors,
+		Warnings: row.Warnings,
+	}
+	if row.AssigneeEmail != "" {
+		if assigneeID, ok := assignees[strings.ToLower(row.AssigneeEmail)]; ok {
+			input.AssigneeID = &assigneeID
+			result.AssigneeID = &assigneeID
+		} else {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("no workspace member with email %q; the task was left unassigned", row.AssigneeEmail))
+		}
+	}
+	if err := validate.Struct(input); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			result.Errors = append(result.Errors, fmt.Sprintf("Field '%s' failed on '%s'", err.Field(), err.Tag()))
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	created, failed := 0, 0
+	if len(result.Errors) > 0 {
+		failed = 1
+	} else if !taskImport.DryRun {
+		task, err := insertTask(tx, taskImport.UserID, input)
+		if err != nil {
+			return err
+		}
+		for _, comment := range row.Comments {
+			if err := insertImportedComment(tx, task.ID, taskImport.UserID, comment); err != nil {
+				return err
+			}
+		}
+		result.TaskID = &task.ID
+		created = 1
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO task_import_rows (import_id, position, task_id, result) VALUES ($1, $2, $3, $4)", taskImport.ID, position, result.TaskID, data); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"UPDATE task_imports SET processed = $1, created = created + $2, failed = failed + $3, updated_at = CURRENT_TIMESTAMP WHERE id = $4",
+		position+1, created, failed, taskImport.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	taskImport.Processed = position + 1
+	taskImport.Created += created
+	taskImport.Failed += failed
+	return nil
+}
+
+// insertImportedComment adds an imported comment as the importing user, keeping its original
+// date and naming its original author in the body
+func insertImportedComment(db dbExecutor, taskID, userID int, comment importer.Comment) error {
+	body := comment.Body
+	if comment.Author != "" {
+		body = fmt.Sprintf("%s wrote:\n\n%s", comment.Author, body)
+	}
+	_, err := db.Exec(
+		"INSERT INTO task_comments (task_id, user_id, body, source, created_at) VALUES ($1, $2, $3, $4, COALESCE($5, CURRENT_TIMESTAMP))",
+		taskID, userID, body, models.CommentSourceImport, comment.CreatedAt,
+	)
+	return err
+}
+
+// finishImport records the final status of an import, drops its file and broadcasts the result
+func finishImport(db *sql.DB, taskImport *models.TaskImport, status, message string) error {
+	var errorText interface{}
+	if message != "" {
+		errorText = message
+	}
+	err := scanImport(db.QueryRow(
+		"UPDATE task_imports SET status = $1, error = $2, file = NULL, finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $3 RETURNING "+importColumns,
+		status, errorText, taskImport.ID,
+	), taskImport)
+	if err != nil {
+		return err
+	}
+	manager.BroadcastImport(taskImport.UserID, *taskImport, "import_"+status)
+	return nil
+}
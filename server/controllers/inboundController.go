@@ -0,0 +1,279 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/Inengs/realtime-task-app/authz"
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/Inengs/realtime-task-app/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+const maxInboundBody = 1 << 20 // 1 MB
+
+// taskReferencePattern matches closing keywords in commit messages, e.g. "fixes #123" or "Closes: #7"
+var taskReferencePattern = regexp.MustCompile(`(?i)\b(?:close[sd]?|fix(?:e[sd])?|resolve[sd]?):?\s+#(\d+)\b`)
+
+// inboundURL returns the public URL for an inbound token
+func inboundURL(token string) string {
+	return utils.APIBaseURL() + "/hooks/inbound/" + token
+}
+
+// taskReferences returns the task IDs closed by a commit message, in order and without duplicates
+func taskReferences(message string) []int {
+	var ids []int
+	seen := make(map[int]bool)
+	for _, match := range taskReferencePattern.FindAllStringSubmatch(message, -1) {
+		id, err := strconv.Atoi(match[1])
+		if err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// GetInboundURL handles GET /projects/:id/inbound
+func GetInboundURL(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	projectID, ok := authz.Project(c, db, authz.ProjectUpdate)
+	if !ok {
+		return
+	}
+
+	var token sql.NullString
+	if err := db.QueryRow("SELECT inbound_token FROM projects WHERE id = $1", projectID).Scan(&token); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !token.Valid {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inbound URL is not enabled for this project"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Inbound URL retrieved successfully", "url": inboundURL(token.String), "email": utils.ProjectMailAddress(projectID, token.String)})
+}
+
+// RotateInboundURL handles POST /projects/:id/inbound, enabling the inbound URL or replacing
+// its token so the previous URL stops working
+func RotateInboundURL(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	projectID, ok := authz.Project(c, db, authz.ProjectUpdate)
+	if !ok {
+		return
+	}
+
+	token, err := utils.GenerateVerificationToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate inbound token"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE projects SET inbound_token = $1 WHERE id = $2", token, projectID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := recordAudit(c, tx, auditEvent{Action: models.AuditInboundTokenCreated, TargetType: models.EntityProject, TargetID: projectID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Inbound URL generated successfully", "url": inboundURL(token), "email": utils.ProjectMailAddress(projectID, token)})
+}
+
+// DisableInboundURL handles DELETE /projects/:id/inbound
+func DisableInboundURL(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	projectID, ok := authz.Project(c, db, authz.ProjectUpdate)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE projects SET inbound_token = NULL WHERE id = $1", projectID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := recordAudit(c, tx, auditEvent{Action: models.AuditInboundTokenRevoked, TargetType: models.EntityProject, TargetID: projectID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Inbound URL disabled successfully"})
+}
+
+// ReceiveInbound handles POST /hooks/inbound/:token. The secret token authenticates the caller,
+// who acts as the project owner in the project's workspace. GitHub and Gitea push events close the tasks their commits
+// reference; any other JSON body files a new task.
+func ReceiveInbound(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	var projectID, ownerID, workspaceID int
+	err := db.QueryRow("SELECT id, user_id, workspace_id FROM projects WHERE inbound_token = $1 AND deleted_at IS NULL", c.Param("token")).Scan(&projectID, &ownerID, &workspaceID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inbound URL not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxInboundBody)
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+		return
+	}
+
+	// Gitea also sends X-GitHub-Event, so one header covers both
+	switch event := c.GetHeader("X-GitHub-Event"); event {
+	case "":
+		receiveInboundTask(c, db, projectID, ownerID, workspaceID, body)
+	case "push":
+		receiveInboundPush(c, db, projectID, ownerID, workspaceID, body)
+	case "ping":
+		c.JSON(http.StatusOK, gin.H{"message": "pong"})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"message": fmt.Sprintf("Event '%s' ignored", event)})
+	}
+}
+
+// receiveInboundTask files a task from a generic JSON payload, if the project owner may still create tasks in the project
+func receiveInboundTask(c *gin.Context, db *sql.DB, projectID, ownerID, workspaceID int, body []byte) {
+	var input models.InboundTaskInput
+	if err := json.Unmarshal(body, &input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(input); err != nil {
+		var errs []string
+		for _, err := range err.(validator.ValidationErrors) {
+			errs = append(errs, fmt.Sprintf("Field '%s' failed on '%s'", err.Field(), err.Tag()))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+	if input.Status == "" {
+		input.Status = "pending"
+	}
+
+	// The owner may have left the workspace or been made a guest since the URL was enabled
+	roles, err := authz.ProjectRolesOf(db, ownerID, workspaceID, projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !roles.Can(authz.TaskCreate) {
+		authz.Deny(c, authz.TaskCreate)
+		return
+	}
+
+	if ok, err := checkAssignee(db, workspaceID, input.AssigneeID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	} else if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Assignee not found"})
+		return
+	}
+
+	task, err := createTask(db, ownerID, models.TaskInput{
+		Title:       SanitizeInput(input.Title),
+		Description: input.Description,
+		Status:      input.Status,
+		ProjectID:   projectID,
+		AssigneeID:  input.AssigneeID,
+		Labels:      input.Labels,
+		DueDate:     input.DueDate,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := publishTaskCreated(db, ownerID, task); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send notification"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Task created successfully", "task": task})
+}
+
+// receiveInboundPush moves the project's tasks referenced by closing keywords in pushed commits to done.
+// References to unknown tasks, tasks of other projects and tasks already done are skipped.
+func receiveInboundPush(c *gin.Context, db *sql.DB, projectID, ownerID, workspaceID int, body []byte) {
+	var push models.PushPayload
+	if err := json.Unmarshal(body, &push); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	updated := []models.Task{}
+	skipped := []int{}
+	seen := make(map[int]bool)
+	for _, commit := range push.Commits {
+		for _, taskID := range taskReferences(commit.Message) {
+			if seen[taskID] {
+				continue
+			}
+			seen[taskID] = true
+
+			before, task, err := changeTaskStatus(db, ownerID, workspaceID, taskID, "done", func(before models.Task) bool {
+				return before.ProjectID == projectID && before.Status != "done"
+			})
+			if err == sql.ErrNoRows || err == errTaskCheckFailed || err == errTaskForbidden {
+				skipped = append(skipped, taskID)
+				continue
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+
+			if err := publishTaskStatus(db, ownerID, before, task); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send notification"})
+				return
+			}
+			updated = append(updated, task)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Push processed successfully", "updated": updated, "skipped": skipped})
+}
@@ -0,0 +1,530 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/authz"
+	"github.com/Inengs/realtime-task-app/jobs"
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/Inengs/realtime-task-app/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// InviteTTL is how long an invite link can be accepted, set from config at startup
+var InviteTTL = 7 * 24 * time.Hour
+
+// inviteColumns lists the invite columns in the order scanInvite expects them
+const inviteColumns = "id, workspace_id, project_id, email, role, invited_by, sent_count, expires_at, accepted_at, created_at, updated_at"
+
+// inviteScope matches the invites of workspace $1 that are for project $2, or for the workspace itself when $2 is 0
+const inviteScope = "workspace_id = $1 AND COALESCE(project_id, 0) = $2"
+
+// errInvalidInvite is returned for invites that don't exist, were accepted or revoked, or whose link has expired
+var errInvalidInvite = errors.New("invalid or expired invite")
+
+// errInviteEmail is returned when an invite is accepted by an account with a different email
+var errInviteEmail = errors.New("invite was sent to a different email")
+
+// scanInvite scans a row selected with inviteColumns into invite
+func scanInvite(row rowScanner, invite *models.Invite) error {
+	return row.Scan(&invite.ID, &invite.WorkspaceID, &invite.ProjectID, &invite.Email, &invite.Role, &invite.InvitedBy,
+		&invite.SentCount, &invite.ExpiresAt, &invite.AcceptedAt, &invite.CreatedAt, &invite.UpdatedAt)
+}
+
+// inviteExpiry returns when an invite sent now expires, to the second so it round-trips through the signed link
+func inviteExpiry() time.Time {
+	return time.Now().UTC().Add(InviteTTL).Truncate(time.Second)
+}
+
+// createInvite invites input.Email to the active workspace, and to projectID unless it is 0, and queues the email
+func createInvite(c *gin.Context, db *sql.DB, userID, projectID int) {
+	var input models.InviteInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(input); err != nil {
+		var errs []string
+		for _, err := range err.(validator.ValidationErrors) {
+			errs = append(errs, fmt.Sprintf("Field '%s' failed on '%s'", err.Field(), err.Tag()))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+	input.Email = strings.ToLower(strings.TrimSpace(input.Email))
+
+	workspaceID := c.GetInt("workspace_id")
+	// Inviting an admin changes who runs the workspace, not just who is in it
+	if input.Role == models.RoleAdmin && !authz.Check(c, authz.MemberManage) {
+		return
+	}
+	// Project owners without workspace-level member.invite can only bring people into their project,
+	// since any other role would let the invitee see every project in the workspace
+	if projectID != 0 && input.Role != models.RoleGuest && !authz.Check(c, authz.MemberInvite) {
+		return
+	}
+
+	// Invitees who already have access don't need an invite
+	var member bool
+	query := "SELECT EXISTS (SELECT 1 FROM workspace_members m JOIN users u ON u.id = m.user_id WHERE m.workspace_id = $1 AND LOWER(u.email) = $2)"
+	args := []interface{}{workspaceID, input.Email}
+	if projectID != 0 {
+		query = "SELECT EXISTS (SELECT 1 FROM project_members m JOIN users u ON u.id = m.user_id WHERE m.project_id = $1 AND LOWER(u.email) = $2)"
+		args = []interface{}{projectID, input.Email}
+	}
+	if err := db.QueryRow(query, args...).Scan(&member); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if member {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is already a member"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var invite models.Invite
+	err = scanInvite(tx.QueryRow(
+		"INSERT INTO invites (workspace_id, project_id, email, role, invited_by, expires_at) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING RETURNING "+inviteColumns,
+		workspaceID, nullableInt(projectID), input.Email, input.Role, userID, inviteExpiry(),
+	), &invite)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "An invite is already pending for this email; resend or revoke it instead"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Queue the email with the invite so neither exists without the other
+	if err := jobs.Enqueue(tx, jobs.TypeInviteEmail, jobs.InviteEmailPayload{InviteID: invite.ID}); err != nil {
+		log.Printf("Invite email queue error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send invite email"})
+		return
+	}
+
+	if err := auditInvite(c, tx, models.AuditInviteCreated, invite); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Invite sent successfully", "invite": invite})
+}
+
+// listInvites lists the pending invites to the active workspace, or to projectID unless it is 0
+func listInvites(c *gin.Context, db *sql.DB, projectID int) {
+	rows, err := db.Query("SELECT "+inviteColumns+" FROM invites WHERE "+inviteScope+" AND accepted_at IS NULL ORDER BY created_at DESC", c.GetInt("workspace_id"), projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	invites := []models.Invite{}
+	for rows.Next() {
+		var invite models.Invite
+		if err := scanInvite(rows, &invite); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		invites = append(invites, invite)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invites retrieved successfully", "invites": invites})
+}
+
+// revokeInvite deletes a pending invite, which stops its link from working
+func revokeInvite(c *gin.Context, db *sql.DB, projectID int) {
+	inviteID, err := strconv.Atoi(c.Param("inviteId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invite ID"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var invite models.Invite
+	err = scanInvite(tx.QueryRow(
+		"DELETE FROM invites WHERE id = $3 AND "+inviteScope+" AND accepted_at IS NULL RETURNING "+inviteColumns,
+		c.GetInt("workspace_id"), projectID, inviteID,
+	), &invite)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Invite with ID %d not found", inviteID)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := auditInvite(c, tx, models.AuditInviteRevoked, invite); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invite revoked successfully"})
+}
+
+// resendInvite extends a pending invite and emails a new link. Links in earlier emails stop working.
+func resendInvite(c *gin.Context, db *sql.DB, projectID int) {
+	inviteID, err := strconv.Atoi(c.Param("inviteId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invite ID"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var invite models.Invite
+	err = scanInvite(tx.QueryRow(
+		"UPDATE invites SET expires_at = $4, sent_count = sent_count + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND "+inviteScope+" AND accepted_at IS NULL RETURNING "+inviteColumns,
+		c.GetInt("workspace_id"), projectID, inviteID, inviteExpiry(),
+	), &invite)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Invite with ID %d not found", inviteID)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := jobs.Enqueue(tx, jobs.TypeInviteEmail, jobs.InviteEmailPayload{InviteID: invite.ID}); err != nil {
+		log.Printf("Invite email queue error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send invite email"})
+		return
+	}
+
+	if err := auditInvite(c, tx, models.AuditInviteResent, invite); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invite resent successfully", "invite": invite})
+}
+
+// acceptInvite adds userID to the invite's workspace with the invited role, and to its project, then marks it accepted.
+// Members keep their existing workspace role. expires must be the expiry signed into the link, so links from before
+// a resend are rejected. Call it inside a transaction.
+func acceptInvite(tx *sql.Tx, inviteID int, expires int64, userID int, email string) (models.Invite, error) {
+	var invite models.Invite
+	err := scanInvite(tx.QueryRow("SELECT "+inviteColumns+" FROM invites WHERE id = $1 AND accepted_at IS NULL FOR UPDATE", inviteID), &invite)
+	if err == sql.ErrNoRows {
+		return invite, errInvalidInvite
+	}
+	if err != nil {
+		return invite, err
+	}
+	if invite.ExpiresAt.Unix() != expires {
+		return invite, errInvalidInvite
+	}
+	if !strings.EqualFold(invite.Email, email) {
+		return invite, errInviteEmail
+	}
+
+	_, err = tx.Exec("INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", invite.WorkspaceID, userID, invite.Role)
+	if err != nil {
+		return invite, err
+	}
+	if invite.ProjectID != nil {
+		_, err = tx.Exec("INSERT INTO project_members (project_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", *invite.ProjectID, userID)
+		if err != nil {
+			return invite, err
+		}
+	}
+
+	err = tx.QueryRow(
+		"UPDATE invites SET accepted_at = CURRENT_TIMESTAMP, accepted_by = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1 RETURNING accepted_at, updated_at",
+		inviteID, userID,
+	).Scan(&invite.AcceptedAt, &invite.UpdatedAt)
+	return invite, err
+}
+
+// notifyInviteAccepted tells the inviter their invite was accepted
+func notifyInviteAccepted(db *sql.DB, invite models.Invite, userID int) error {
+	if invite.InvitedBy == nil || *invite.InvitedBy == userID {
+		return nil
+	}
+
+	var username, workspace string
+	var project *string
+	err := db.QueryRow(
+		"SELECT u.username, w.name, p.name FROM users u, workspaces w LEFT JOIN projects p ON p.id = $3 WHERE u.id = $1 AND w.id = $2",
+		userID, invite.WorkspaceID, intValue(invite.ProjectID),
+	).Scan(&username, &workspace, &project)
+	if err != nil {
+		return err
+	}
+
+	event := NotificationEvent{
+		UserID:  *invite.InvitedBy,
+		ActorID: userID,
+		Type:    models.NotificationInviteAccepted,
+		Data: map[string]interface{}{
+			"username":     username,
+			"workspace_id": invite.WorkspaceID,
+			"workspace":    workspace,
+			"target":       workspace,
+		},
+	}
+	if project != nil {
+		event.EntityType = models.EntityProject
+		event.EntityID = *invite.ProjectID
+		event.Data["project_id"] = *invite.ProjectID
+		event.Data["target"] = *project
+	}
+	return Notify(db, event)
+}
+
+// auditInviteAccepted records that userID joined a workspace, and maybe a project, through invite
+func auditInviteAccepted(c *gin.Context, tx *sql.Tx, userID int, invite models.Invite) error {
+	return recordAudit(c, tx, auditEvent{
+		Action: models.AuditInviteAccepted, ActorID: userID, TargetType: models.AuditTargetWorkspace, TargetID: invite.WorkspaceID,
+		Details: map[string]interface{}{"invite_id": invite.ID, "project_id": invite.ProjectID, "role": invite.Role},
+	})
+}
+
+// auditInvite records action on a pending invite, such as creating, resending or revoking it
+func auditInvite(c *gin.Context, tx *sql.Tx, action string, invite models.Invite) error {
+	return recordAudit(c, tx, auditEvent{
+		Action: action, TargetType: models.AuditTargetWorkspace, TargetID: invite.WorkspaceID,
+		Details: map[string]interface{}{"invite_id": invite.ID, "project_id": invite.ProjectID, "email": invite.Email, "role": invite.Role},
+	})
+}
+
+// intValue dereferences an optional ID, returning 0 for nil
+func intValue(value *int) int {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
+
+// CreateProjectInvite handles POST /projects/:id/invites, emailing an invite to the project and its workspace
+func CreateProjectInvite(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	projectID, ok := authz.Project(c, db, authz.MemberInvite)
+	if !ok {
+		return
+	}
+	createInvite(c, db, userIDInt, projectID)
+}
+
+// ListProjectInvites handles GET /projects/:id/invites, the project's pending invites
+func ListProjectInvites(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	projectID, ok := authz.Project(c, db, authz.MemberInvite)
+	if !ok {
+		return
+	}
+	listInvites(c, db, projectID)
+}
+
+// RevokeProjectInvite handles DELETE /projects/:id/invites/:inviteId
+func RevokeProjectInvite(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	projectID, ok := authz.Project(c, db, authz.MemberInvite)
+	if !ok {
+		return
+	}
+	revokeInvite(c, db, projectID)
+}
+
+// ResendProjectInvite handles POST /projects/:id/invites/:inviteId/resend
+func ResendProjectInvite(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	projectID, ok := authz.Project(c, db, authz.MemberInvite)
+	if !ok {
+		return
+	}
+	resendInvite(c, db, projectID)
+}
+
+// CreateWorkspaceInvite handles POST /workspaces/:workspaceId/invites
+func CreateWorkspaceInvite(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	createInvite(c, db, userIDInt, 0)
+}
+
+// ListWorkspaceInvites handles GET /workspaces/:workspaceId/invites, the pending invites to the workspace itself
+func ListWorkspaceInvites(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+	listInvites(c, db, 0)
+}
+
+// RevokeWorkspaceInvite handles DELETE /workspaces/:workspaceId/invites/:inviteId
+func RevokeWorkspaceInvite(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+	revokeInvite(c, db, 0)
+}
+
+// ResendWorkspaceInvite handles POST /workspaces/:workspaceId/invites/:inviteId/resend
+func ResendWorkspaceInvite(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+	resendInvite(c, db, 0)
+}
+
+// GetInvite handles GET /invites/:token. The signed token authenticates the request, so the frontend can
+// show who sent the invite and what it is for before the invitee logs in or registers.
+func GetInvite(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	inviteID, expires, err := utils.VerifyInviteToken(c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid or expired invite"})
+		return
+	}
+
+	var preview models.InvitePreview
+	err = db.QueryRow(`
+	SELECT i.email, i.role, w.name, p.name, u.username, i.expires_at
+	FROM invites i JOIN workspaces w ON w.id = i.workspace_id
+	LEFT JOIN projects p ON p.id = i.project_id LEFT JOIN users u ON u.id = i.invited_by
+	WHERE i.id = $1 AND i.accepted_at IS NULL`, inviteID,
+	).Scan(&preview.Email, &preview.Role, &preview.Workspace, &preview.Project, &preview.InvitedBy, &preview.ExpiresAt)
+	if err == sql.ErrNoRows || (err == nil && preview.ExpiresAt.Unix() != expires) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid or expired invite"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Tells the frontend whether to offer login or registration
+	var registered bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = $1)", strings.ToLower(preview.Email)).Scan(&registered); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invite retrieved successfully", "invite": preview, "registered": registered})
+}
+
+// AcceptInvite handles POST /invites/:token/accept, adding the logged-in user to the invite's workspace and project.
+// The account's email must be the one the invite was sent to.
+func AcceptInvite(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	inviteID, expires, err := utils.VerifyInviteToken(c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid or expired invite"})
+		return
+	}
+
+	var email string
+	if err := db.QueryRow("SELECT email FROM users WHERE id = $1", userIDInt).Scan(&email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	invite, err := acceptInvite(tx, inviteID, expires, userIDInt, email)
+	if err == errInvalidInvite {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid or expired invite"})
+		return
+	}
+	if err == errInviteEmail {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This invite was sent to a different email address"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := auditInviteAccepted(c, tx, userIDInt, invite); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := notifyInviteAccepted(db, invite, userIDInt); err != nil {
+		log.Printf("Error notifying inviter of invite %d: %v", invite.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invite accepted successfully", "invite": invite})
+}
@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/jobs"
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/Inengs/realtime-task-app/utils"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Failed login limits. Set from config in main.
+var (
+	LoginMaxFailures = 5
+	LoginLockout     = 15 * time.Minute
+	LoginBackoff     = time.Second // Delay after the first failure, doubling with each further one
+)
+
+// comparePassword checks a password against its bcrypt hash
+var comparePassword = bcrypt.CompareHashAndPassword
+
+// dummyPasswordHash is compared against when the email has no account, so unknown and
+// known emails take as long to reject
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+
+// loginRetryAfter returns how long email must wait before trying to log in again, during the
+// backoff after a failed attempt or while it is locked out. Zero means it can try now.
+func loginRetryAfter(db dbExecutor, email string) (time.Duration, error) {
+	var seconds int
+	err := db.QueryRow(`
+	SELECT CEIL(GREATEST(
+		EXTRACT(EPOCH FROM locked_until - CURRENT_TIMESTAMP),
+		EXTRACT(EPOCH FROM last_failed_at + make_interval(secs => LEAST($2 * power(2, failures - 1), $3)) - CURRENT_TIMESTAMP),
+		0
+	))::INTEGER
+	FROM login_attempts WHERE email = $1`,
+		email, LoginBackoff.Seconds(), LoginLockout.Seconds(),
+	).Scan(&seconds)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// reserveLoginAttempt counts a login attempt for email as failed before the password is checked, in a
+// single upsert that refuses while email is in backoff or locked out. Parallel guesses are serialized on
+// the row, so no more than LoginMaxFailures of them get to the password check; the attempt that reaches
+// the limit sets the lock. It returns the attempt's number, or how long to wait when it was refused.
+// Emails without an account are counted the same way, so responses don't reveal which exist.
+func reserveLoginAttempt(db *sql.DB, email string) (int, time.Duration, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	// Start counting again after an expired lock or once earlier failures are too old
+	var failures int
+	err = tx.QueryRow(`
+	INSERT INTO login_attempts (email, failures, last_failed_at) VALUES ($1, 1, CURRENT_TIMESTAMP)
+	ON CONFLICT (email) DO UPDATE SET
+		failures = CASE
+			WHEN login_attempts.locked_until <= CURRENT_TIMESTAMP OR login_attempts.last_failed_at < CURRENT_TIMESTAMP - make_interval(secs => $2) THEN 1
+			ELSE login_attempts.failures + 1
+		END,
+		locked_until = NULL,
+		last_failed_at = CURRENT_TIMESTAMP
+	WHERE (login_attempts.locked_until IS NULL OR login_attempts.locked_until <= CURRENT_TIMESTAMP)
+		AND login_attempts.last_failed_at + make_interval(secs => LEAST($3 * power(2, login_attempts.failures - 1), $4)) <= CURRENT_TIMESTAMP
+	RETURNING failures`,
+		email, jobs.LoginFailureWindow.Seconds(), LoginBackoff.Seconds(), LoginLockout.Seconds(),
+	).Scan(&failures)
+	if err == sql.ErrNoRows {
+		// Refused; the wait is only for Retry-After, so a lock that ended in between still waits a second
+		tx.Rollback()
+		wait, err := loginRetryAfter(db, email)
+		if err != nil {
+			return 0, 0, err
+		}
+		if wait <= 0 {
+			wait = time.Second
+		}
+		return 0, wait, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if failures >= LoginMaxFailures {
+		_, err = tx.Exec(
+			"UPDATE login_attempts SET locked_until = CURRENT_TIMESTAMP + make_interval(secs => $2) WHERE email = $1",
+			email, LoginLockout.Seconds(),
+		)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return failures, 0, tx.Commit()
+}
+
+// reportLoginLockout emails the unlock link and records the lockout once the attempt that reached
+// LoginMaxFailures turns out to be wrong. Only real accounts (userID > 0) are emailed.
+func reportLoginLockout(c *gin.Context, db *sql.DB, email string, userID, failures int) error {
+	if failures < LoginMaxFailures {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if userID > 0 {
+		if err := jobs.Enqueue(tx, jobs.TypeUnlockEmail, jobs.UnlockEmailPayload{UserID: userID}); err != nil {
+			return err
+		}
+	}
+	err = recordAudit(c, tx, auditEvent{
+		Action: models.AuditAccountLocked, ActorID: userID, TargetType: models.AuditTargetUser, TargetID: userID,
+		Details: map[string]interface{}{"email": email, "failures": failures, "minutes": int(math.Round(LoginLockout.Minutes()))},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// clearLoginFailures forgets the failed logins of email, including the reserved attempt, once the password is right
+func clearLoginFailures(db dbExecutor, email string) error {
+	_, err := db.Exec("DELETE FROM login_attempts WHERE email = $1", email)
+	return err
+}
+
+// tooManyLoginAttempts writes the 429 for a login during backoff or lockout. It reads the same
+// whether or not the email has an account.
+func tooManyLoginAttempts(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, try again later"})
+}
+
+// UnlockAccount handles POST /auth/unlock with the token from the lockout email, ending the lockout
+// and clearing the failed logins
+func UnlockAccount(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	var input struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token is required"})
+		return
+	}
+
+	userID, lockedUntil, err := utils.VerifyUnlockToken(input.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired unlock link"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// The token only matches the lockout it was sent for
+	result, err := tx.Exec(`
+	DELETE FROM login_attempts a USING users u
+	WHERE u.id = $1 AND a.email = LOWER(u.email) AND FLOOR(EXTRACT(EPOCH FROM a.locked_until))::BIGINT = $2`,
+		userID, lockedUntil,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired unlock link"})
+		return
+	}
+
+	if err := recordAudit(c, tx, auditEvent{Action: models.AuditAccountUnlocked, ActorID: userID, TargetType: models.AuditTargetUser, TargetID: userID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	log.Printf("Account %d unlocked from emailed link", userID)
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlocked successfully. You can log in now."})
+}
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/db"
+	"github.com/gin-gonic/gin"
+	_ "github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// openTestDB connects to the Postgres database in TEST_DATABASE_URL, skipping the test without one
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	database, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := db.InitDB(database); err != nil {
+		t.Fatalf("init database: %v", err)
+	}
+	return database
+}
+
+// createTestUser inserts a user with password, deleting it when the test ends
+func createTestUser(t *testing.T, database *sql.DB, email, password string) int {
+	t.Helper()
+	hash, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	var userID int
+	err := database.QueryRow(
+		"INSERT INTO users (username, email, password) VALUES ($1, $2, $3) RETURNING id",
+		fmt.Sprintf("u%d", time.Now().UnixNano()%1e12), email, string(hash),
+	).Scan(&userID)
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() { database.Exec("DELETE FROM users WHERE id = $1", userID) })
+	return userID
+}
+
+func TestConcurrentWrongPasswordsAreLimited(t *testing.T) {
+	database := openTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	for _, tc := range []struct {
+		name    string
+		backoff time.Duration
+		account bool
+	}{
+		{"no backoff", 0, true},
+		{"with backoff", time.Second, true},
+		{"unknown email", 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			LoginMaxFailures, LoginLockout, LoginBackoff = 3, time.Minute, tc.backoff
+
+			email := fmt.Sprintf("lockout-%d@example.com", time.Now().UnixNano())
+			if tc.account {
+				createTestUser(t, database, email, "right-password")
+			}
+			t.Cleanup(func() { database.Exec("DELETE FROM login_attempts WHERE email = $1", email) })
+
+			var compared int32
+			comparePassword = func(hash, password []byte) error {
+				atomic.AddInt32(&compared, 1)
+				return bcrypt.CompareHashAndPassword(hash, password)
+			}
+			t.Cleanup(func() { comparePassword = bcrypt.CompareHashAndPassword })
+
+			router := gin.New()
+			router.Use(func(c *gin.Context) { c.Set("db", database) })
+			router.POST("/auth/login", LoginFunc)
+
+			const attempts = 20
+			body := fmt.Sprintf(`{"email":%q,"password":"wrong-password"}`, email)
+			codes := make(chan int, attempts)
+			var wg sync.WaitGroup
+			for i := 0; i < attempts; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					w := httptest.NewRecorder()
+					router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewBufferString(body)))
+					codes <- w.Code
+				}()
+			}
+			wg.Wait()
+			close(codes)
+
+			counts := map[int]int{}
+			for code := range codes {
+				counts[code]++
+			}
+			if got := int(atomic.LoadInt32(&compared)); got > LoginMaxFailures {
+				t.Errorf("%d attempts reached the password check, want at most %d", got, LoginMaxFailures)
+			}
+			if counts[http.StatusUnauthorized]+counts[http.StatusTooManyRequests] != attempts {
+				t.Errorf("unexpected responses %v", counts)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/Inengs/realtime-task-app/authz"
+	"github.com/Inengs/realtime-task-app/mailin"
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/Inengs/realtime-task-app/utils"
+	"github.com/lib/pq"
+)
+
+const maxTitleLength = 255
+
+// MailBackend turns mail received by the mailin server into tasks and comments:
+//
+//	project-<id>+<inbound token>@<domain>  files the email as a task in the project
+//	reply+<reply token>@<domain>           adds the reply as a comment by the user the task email was sent to
+type MailBackend struct {
+	DB     *sql.DB
+	Domain string // Only addresses at this domain are accepted; any domain when empty
+}
+
+// mailRecipient is a checked recipient address
+type mailRecipient struct {
+	ProjectID int // Set for project addresses
+	OwnerID   int
+	TaskID    int // Set for reply addresses
+	UserID    int
+}
+
+var errNoMailbox = &mailin.Error{Code: 550, Message: "No such mailbox"}
+
+// resolve parses a recipient address and checks its token against the database
+func (b MailBackend) resolve(addr string) (mailRecipient, error) {
+	var rcpt mailRecipient
+	at := strings.LastIndex(addr, "@")
+	if at < 0 {
+		return rcpt, errNoMailbox
+	}
+	if b.Domain != "" && !strings.EqualFold(addr[at+1:], b.Domain) {
+		return rcpt, &mailin.Error{Code: 550, Message: "Relaying not permitted"}
+	}
+	mailbox, token, ok := strings.Cut(addr[:at], "+")
+	if !ok {
+		return rcpt, errNoMailbox
+	}
+
+	if strings.EqualFold(mailbox, "reply") {
+		userID, taskID, err := utils.VerifyReplyToken(token)
+		if err != nil {
+			return rcpt, errNoMailbox
+		}
+		// The user must still be the task's owner or assignee, and be allowed to comment on it like
+		// through the API
+		var projectID, workspaceID int
+		err = b.DB.QueryRow(
+			"SELECT t.project_id, p.workspace_id FROM tasks t JOIN projects p ON p.id = t.project_id WHERE t.id = $1 AND t.deleted_at IS NULL AND (t.user_id = $2 OR t.assignee_id = $2)",
+			taskID, userID,
+		).Scan(&projectID, &workspaceID)
+		if err == sql.ErrNoRows {
+			return rcpt, errNoMailbox
+		}
+		if err != nil {
+			return rcpt, err
+		}
+		if err := b.authorize(userID, workspaceID, projectID, authz.TaskView, authz.TaskComment); err != nil {
+			return rcpt, err
+		}
+		rcpt.TaskID, rcpt.UserID = taskID, userID
+		return rcpt, nil
+	}
+
+	idStr, ok := strings.CutPrefix(strings.ToLower(mailbox), "project-")
+	if !ok {
+		return rcpt, errNoMailbox
+	}
+	projectID, err := strconv.Atoi(idStr)
+	if err != nil {
+		return rcpt, errNoMailbox
+	}
+	var workspaceID int
+	err = b.DB.QueryRow("SELECT user_id, workspace_id FROM projects WHERE id = $1 AND inbound_token = $2 AND deleted_at IS NULL", projectID, token).Scan(&rcpt.OwnerID, &workspaceID)
+	if err == sql.ErrNoRows {
+		return rcpt, errNoMailbox
+	}
+	if err != nil {
+		return rcpt, err
+	}
+	// Tasks are filed as the owner, who may have lost access since the address was handed out
+	if err := b.authorize(rcpt.OwnerID, workspaceID, projectID, authz.TaskCreate); err != nil {
+		return rcpt, err
+	}
+	rcpt.ProjectID = projectID
+	return rcpt, nil
+}
+
+// authorize checks userID has every one of perms on the project, refusing the recipient when they don't
+func (b MailBackend) authorize(userID, workspaceID, projectID int, perms ...authz.Permission) error {
+	roles, err := authz.ProjectRolesOf(b.DB, userID, workspaceID, projectID)
+	if err != nil {
+		return err
+	}
+	for _, perm := range perms {
+		if !roles.Can(perm) {
+			return &mailin.Error{Code: 550, Message: "Not permitted"}
+		}
+	}
+	return nil
+}
+
+// Recipient implements mailin.Backend
+func (b MailBackend) Recipient(addr string) error {
+	_, err := b.resolve(addr)
+	return err
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// Deliver implements mailin.Backend. MTAs retry a message whose delivery failed, and in SMTP mode
+// the whole message is retried when any recipient fails, so a message already filed under the
+// recipient's project or task (matched by Message-ID) is accepted without filing it again.
+func (b MailBackend) Deliver(from, addr string, data []byte) error {
+	rcpt, err := b.resolve(addr)
+	if err != nil {
+		return err
+	}
+	msg, err := mailin.Parse(data)
+	if err != nil {
+		return &mailin.Error{Code: 554, Message: "Message could not be parsed"}
+	}
+
+	if rcpt.TaskID != 0 {
+		return b.deliverReply(rcpt, msg)
+	}
+	return b.deliverTask(rcpt, msg)
+}
+
+// deliverTask files an email as a task owned by the project's owner, with its attachments
+func (b MailBackend) deliverTask(rcpt mailRecipient, msg *mailin.Message) error {
+	title := []rune(SanitizeInput(msg.Subject))
+	if len(title) == 0 {
+		title = []rune("(no subject)")
+	}
+	if len(title) > maxTitleLength {
+		title = title[:maxTitleLength]
+	}
+	description := msg.Text
+	if msg.From != "" {
+		description = strings.TrimSpace(description + "\n\nReceived by email from " + msg.From)
+	}
+
+	if msg.MessageID != "" {
+		var seen bool
+		err := b.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM tasks WHERE project_id = $1 AND inbound_message_id = $2)", rcpt.ProjectID, msg.MessageID).Scan(&seen)
+		if err != nil {
+			return err
+		}
+		if seen {
+			return nil
+		}
+	}
+
+	tx, err := b.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	task, err := insertTask(tx, rcpt.OwnerID, models.TaskInput{
+		Title:       string(title),
+		Description: description,
+		Status:      "pending",
+		ProjectID:   rcpt.ProjectID,
+	})
+	if err != nil {
+		return err
+	}
+	if msg.MessageID != "" {
+		_, err := tx.Exec("UPDATE tasks SET inbound_message_id = $2 WHERE id = $1", task.ID, msg.MessageID)
+		if isUniqueViolation(err) {
+			// A concurrent delivery of the same message filed it first
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := insertAttachments(tx, task.ID, rcpt.OwnerID, nil, msg.Attachments); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// The task is filed, so failing now would only make the MTA deliver it again
+	if err := publishTaskCreated(b.DB, rcpt.OwnerID, task); err != nil {
+		log.Printf("Error publishing emailed task %d: %v", task.ID, err)
+	}
+	return nil
+}
+
+// deliverReply adds the new text of a reply to a task email as a comment, with its attachments
+func (b MailBackend) deliverReply(rcpt mailRecipient, msg *mailin.Message) error {
+	body := mailin.StripReply(msg.Text)
+	if body == "" && len(msg.Attachments) == 0 {
+		return &mailin.Error{Code: 550, Message: "Reply is empty"}
+	}
+	if body == "" {
+		body = "(attachment)"
+	}
+
+	tx, err := b.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var task models.Task
+	err = scanTask(tx.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = $1 AND deleted_at IS NULL", rcpt.TaskID), &task)
+	if err == sql.ErrNoRows {
+		return errNoMailbox
+	}
+	if err != nil {
+		return err
+	}
+
+	if msg.MessageID != "" {
+		var seen bool
+		err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM task_comments WHERE task_id = $1 AND inbound_message_id = $2)", task.ID, msg.MessageID).Scan(&seen)
+		if err != nil {
+			return err
+		}
+		if seen {
+			return nil
+		}
+	}
+
+	comment, err := insertComment(tx, task.ID, rcpt.UserID, body, models.CommentSourceEmail)
+	if err != nil {
+		return err
+	}
+	if msg.MessageID != "" {
+		_, err := tx.Exec("UPDATE task_comments SET inbound_message_id = $2 WHERE id = $1", comment.ID, msg.MessageID)
+		if isUniqueViolation(err) {
+			// A concurrent delivery of the same message added it first
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := insertAttachments(tx, task.ID, rcpt.UserID, &comment.ID, msg.Attachments); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// The comment is saved, so failing now would only make the MTA deliver it again
+	if err := notifyComment(b.DB, task, comment); err != nil {
+		log.Printf("Error notifying about emailed comment %d: %v", comment.ID, err)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,29 +12,122 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/lib/pq"
-	// _ "github.com/lib/pq"
 )
 
-func GetUserNotifications(c *gin.Context) {
-	db, ok := c.MustGet("db").(*sql.DB)
+const (
+	defaultNotificationLimit = 20
+	maxNotificationLimit     = 100
+)
 
-	if !ok {
-		log.Printf("Failed to get database from context")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection error"})
+// notificationColumns lists the notification columns in the order scanNotification expects them
+const notificationColumns = "id, user_id, type, actor_id, entity_type, entity_id, data, message, is_read, created_at, updated_at"
+
+// scanNotification scans a row selected with notificationColumns into notification
+func scanNotification(row rowScanner, notification *models.Notifications) error {
+	var data []byte
+	if err := row.Scan(&notification.ID, &notification.UserID, &notification.Type, &notification.ActorID, &notification.EntityType, &notification.EntityID, &data, &notification.Message, &notification.IsRead, &notification.CreatedAt, &notification.UpdatedAt); err != nil {
+		return err
+	}
+	notification.Link = models.NotificationLink(notification.EntityType, notification.EntityID)
+	return json.Unmarshal(data, &notification.Data)
+}
+
+// countUnread returns how many unread in-app notifications a user has
+func countUnread(db dbExecutor, userID int) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND deliver_in_app AND is_read = false", userID).Scan(&count)
+	return count, err
+}
+
+// pushUnreadCount sends the user's current unread count to all of their notification sockets
+func pushUnreadCount(db dbExecutor, userID int) {
+	count, err := countUnread(db, userID)
+	if err != nil {
+		log.Printf("Unread count error for user_id %d: %v", userID, err)
 		return
 	}
+	manager.BroadcastUnreadCount(userID, count)
+}
 
-	// Extract  and validate user ID from URL parameter
-	userIDStr := c.Param("id")
-	userID, err := strconv.Atoi(userIDStr)
+// ownsPathUser checks that the :id in the URL is the session user. The legacy
+// /notifications/:id routes used to trust it, letting anyone read anyone's notifications.
+func ownsPathUser(c *gin.Context, userID int) bool {
+	pathUserID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		log.Printf("Invalid user ID: %s, error: %v", userIDStr, err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return false
+	}
+	if pathUserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only access your own notifications"})
+		return false
+	}
+	return true
+}
+
+// GetMyNotifications handles GET /notifications/me to list the caller's notifications, newest first.
+// Supports ?limit=, ?unread_only=true and ?cursor= (the next_cursor of the previous page).
+func GetMyNotifications(c *gin.Context) {
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
+	userIDInt, _ := userID.(int)
+
+	listNotifications(c, userIDInt)
+}
 
-	// Query notifications for the user
-	rows, err := db.Query("SELECT ID, UserID, Message, IsRead, CreatedAt, UpdatedAt FROM notifications WHERE UserID = $1 ORDER BY CreatedAt DESC", userID)
+// GetUserNotifications handles the legacy GET /notifications/:id, which only serves the caller's own ID
+func GetUserNotifications(c *gin.Context) {
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	if !ownsPathUser(c, userIDInt) {
+		return
+	}
+	listNotifications(c, userIDInt)
+}
+
+// listNotifications writes one page of a user's notifications
+func listNotifications(c *gin.Context, userID int) {
+	db := c.MustGet("db").(*sql.DB)
+
+	limit := defaultNotificationLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		if limit > maxNotificationLimit {
+			limit = maxNotificationLimit
+		}
+	}
+
+	cursor := 0
+	if cursorStr := c.Query("cursor"); cursorStr != "" {
+		var err error
+		cursor, err = strconv.Atoi(cursorStr)
+		if err != nil || cursor <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+	}
+
+	unreadOnly := c.Query("unread_only") == "true"
+
+	// Query notifications for the user, keyed on id so pages stay stable as new ones arrive
+	rows, err := db.Query(
+		"SELECT "+notificationColumns+" FROM notifications WHERE user_id = $1 AND deliver_in_app AND ($2 = 0 OR id < $2) AND (NOT $3 OR is_read = false) ORDER BY id DESC LIMIT $4",
+		userID, cursor, unreadOnly, limit,
+	)
 	if err != nil {
 		log.Printf("Database query error for user_id %d: %v", userID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
@@ -42,10 +136,10 @@ func GetUserNotifications(c *gin.Context) {
 	defer rows.Close()
 
 	// collect notifications into a slice
-	var notifications []models.Notifications
+	notifications := []models.Notifications{}
 	for rows.Next() {
 		var notification models.Notifications
-		if err := rows.Scan(&notification.ID, &notification.UserID, &notification.Message, &notification.IsRead, &notification.CreatedAt, &notification.UpdatedAt); err != nil {
+		if err := scanNotification(rows, &notification); err != nil {
 			log.Printf("Error scanning notification for user_id %d: %v", userID, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 			return
@@ -61,20 +155,67 @@ func GetUserNotifications(c *gin.Context) {
 	}
 
 	// Return notifications
-	log.Printf("Retrieved %d notifications for user_id %d", len(notifications), userID)
-	c.JSON(http.StatusOK, gin.H{"message": "Notifications retrieved successfully", "notifications": notifications})
+	response := gin.H{"message": "Notifications retrieved successfully", "notifications": notifications}
+	if len(notifications) == limit {
+		response["next_cursor"] = notifications[len(notifications)-1].ID
+	}
+	c.JSON(http.StatusOK, response)
 }
 
-func MarkNotificationsRead(c *gin.Context) {
+// GetUnreadCount handles GET /notifications/unread-count
+func GetUnreadCount(c *gin.Context) {
 	db := c.MustGet("db").(*sql.DB)
 
-	// Extract and validate user ID from URL parameter
-	userIDStr := c.Param("id")
-	userID, err := strconv.Atoi(userIDStr)
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	count, err := countUnread(db, userIDInt)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unread count retrieved successfully", "unread_count": count})
+}
+
+// MarkMyNotificationsRead handles PATCH /notifications/me/read
+func MarkMyNotificationsRead(c *gin.Context) {
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	markNotificationsRead(c, userIDInt)
+}
+
+// MarkNotificationsRead handles the legacy PATCH /notifications/read/:id, which only serves the caller's own ID
+func MarkNotificationsRead(c *gin.Context) {
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	if !ownsPathUser(c, userIDInt) {
 		return
 	}
+	markNotificationsRead(c, userIDInt)
+}
+
+// markNotificationsRead marks the given (or all) notifications of a user as read
+// and pushes the new unread count to every open tab
+func markNotificationsRead(c *gin.Context, userID int) {
+	db := c.MustGet("db").(*sql.DB)
 
 	// Bind and validate request body
 	var input models.NotificationInput
@@ -96,41 +237,40 @@ func MarkNotificationsRead(c *gin.Context) {
 
 	// Update notifications in database
 	var result sql.Result
+	var err error
 	if len(input.NotificationIDs) > 0 {
-		result, err = db.Exec("UPDATE notifications SET isRead = true WHERE UserID = $1 AND ID = ANY($2)", userID, pq.Array(input.NotificationIDs))
+		result, err = db.Exec("UPDATE notifications SET is_read = true, updated_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND deliver_in_app AND is_read = false AND id = ANY($2)", userID, pq.Array(input.NotificationIDs))
 	} else {
-		result, err = db.Exec("UPDATE notifications SET isRead = true WHERE UserID = $1", userID)
+		result, err = db.Exec("UPDATE notifications SET is_read = true, updated_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND deliver_in_app AND is_read = false", userID)
 	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 
-	// Check if any notifications were updated
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
-	if rowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No notifications found to mark as read"})
+
+	count, err := countUnread(db, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+	manager.BroadcastUnreadCount(userID, count)
 
 	// Return success response
-	c.JSON(http.StatusOK, gin.H{"message": "Notifications marked as read"})
+	c.JSON(http.StatusOK, gin.H{"message": "Notifications marked as read", "updated": rowsAffected, "unread_count": count})
 }
 
-// SendNotification inserts a notification and broadcasts it
+// SendNotification inserts a plain system notification and broadcasts it.
+// Events about tasks and projects should use Notify or its typed helpers instead.
 func SendNotification(db *sql.DB, userID int, message string) error {
-	var notification models.Notifications
-	err := db.QueryRow(
-		"INSERT INTO notifications (user_id, message, is_read) VALUES ($1, $2, false) RETURNING id, user_id, message, is_read, created_at, updated_at",
-		userID, message,
-	).Scan(&notification.ID, &notification.UserID, &notification.Message, &notification.IsRead, &notification.CreatedAt, &notification.UpdatedAt)
-	if err != nil {
-		return err
-	}
-	manager.BroadcastNotification(userID, notification)
-	return nil
+	return Notify(db, NotificationEvent{
+		UserID: userID,
+		Type:   models.NotificationSystem,
+		Data:   map[string]interface{}{"message": message},
+	})
 }
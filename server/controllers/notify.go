@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/authz"
+	"github.com/Inengs/realtime-task-app/jobs"
+	"github.com/Inengs/realtime-task-app/models"
+)
+
+// notificationTemplates renders the human-readable message stored with each notification type
+var notificationTemplates = map[models.NotificationType]*template.Template{
+	models.NotificationSystem:             mustNotificationTemplate("{{.message}}"),
+	models.NotificationTaskCreated:        mustNotificationTemplate("New task created: {{.title}}"),
+	models.NotificationTaskUpdated:        mustNotificationTemplate("Task updated: {{.title}}"),
+	models.NotificationTaskStatusChanged:  mustNotificationTemplate("Task status updated to {{.status}}: {{.title}}"),
+	models.NotificationTaskDeleted:        mustNotificationTemplate("Task deleted: {{.title}}"),
+	models.NotificationTaskRestored:       mustNotificationTemplate("Task restored: {{.title}}"),
+	models.NotificationTaskAssigned:       mustNotificationTemplate("You were assigned a task: {{.title}}"),
+	models.NotificationTaskCommented:      mustNotificationTemplate("New comment on {{.title}}: {{.excerpt}}"),
+	models.NotificationTaskMentioned:      mustNotificationTemplate("You were mentioned on {{.title}}: {{.excerpt}}"),
+	models.NotificationTaskDueSoon:        mustNotificationTemplate("Task due {{.due_date}}: {{.title}}"),
+	models.NotificationTasksBulkUpdated:   mustNotificationTemplate("Bulk {{.operation}} applied to {{.count}} tasks"),
+	models.NotificationTasksBulkAssigned:  mustNotificationTemplate("You were assigned {{.count}} tasks"),
+	models.NotificationProjectCreated:     mustNotificationTemplate("New project created: {{.name}}"),
+	models.NotificationProjectUpdated:     mustNotificationTemplate("Project updated: {{.name}}"),
+	models.NotificationProjectDeleted:     mustNotificationTemplate("Project deleted: {{.name}}"),
+	models.NotificationProjectRestored:    mustNotificationTemplate("Project restored: {{.name}}"),
+	models.NotificationProjectTransferred: mustNotificationTemplate("{{.previous_owner}} deleted their account and transferred a project to you: {{.name}}"),
+	models.NotificationAccountExportReady: mustNotificationTemplate("Your data export is ready to download until {{.expires_at}}"),
+	models.NotificationInviteAccepted:     mustNotificationTemplate("{{.username}} accepted your invite to {{.target}}"),
+}
+
+func mustNotificationTemplate(text string) *template.Template {
+	return template.Must(template.New("notification").Option("missingkey=zero").Parse(text))
+}
+
+// NotificationEvent describes a typed notification before it is rendered and stored
+type NotificationEvent struct {
+	UserID     int // Recipient
+	ActorID    int // User who caused the event, 0 for the system
+	Type       models.NotificationType
+	EntityType string
+	EntityID   int
+	Data       map[string]interface{}
+}
+
+// renderNotification renders the message template for an event
+func renderNotification(event NotificationEvent) (string, error) {
+	tmpl, ok := notificationTemplates[event.Type]
+	if !ok {
+		return "", fmt.Errorf("unknown notification type %q", event.Type)
+	}
+	var message strings.Builder
+	if err := tmpl.Execute(&message, event.Data); err != nil {
+		return "", err
+	}
+	return message.String(), nil
+}
+
+// nullableInt stores 0 as NULL
+func nullableInt(value int) interface{} {
+	if value == 0 {
+		return nil
+	}
+	return value
+}
+
+// nullableString stores "" as NULL
+func nullableString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// Notify renders a typed notification and delivers it on the channels the recipient
+// chose for its event: stored and broadcast in-app, emailed (held until quiet hours end), or dropped
+func Notify(db *sql.DB, event NotificationEvent) error {
+	if event.Data == nil {
+		event.Data = map[string]interface{}{}
+	}
+
+	channel := models.ChannelInApp
+	var prefs models.NotificationPreferences
+	if prefEvent, ok := event.Type.Event(); ok {
+		var err error
+		prefs, err = loadPreferences(db, event.UserID)
+		if err != nil {
+			return err
+		}
+		channel = prefs.Events[prefEvent]
+	}
+	if channel == models.ChannelNone {
+		return nil
+	}
+
+	message, err := renderNotification(event)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Email-only notifications are stored too, so digests can pick them up
+	var notification models.Notifications
+	err = scanNotification(tx.QueryRow(
+		"INSERT INTO notifications (user_id, type, actor_id, entity_type, entity_id, data, message, is_read, deliver_in_app, deliver_email) VALUES ($1, $2, $3, $4, $5, $6, $7, false, $8, $9) RETURNING "+notificationColumns,
+		event.UserID, event.Type, nullableInt(event.ActorID), nullableString(event.EntityType), nullableInt(event.EntityID), data, message, channel.InApp(), channel.Email(),
+	), &notification)
+	if err != nil {
+		return err
+	}
+
+	// Users on a digest get this in their next digest email instead. The email is queued with the
+	// notification so neither exists without the other, and held until quiet hours end.
+	if channel.Email() && prefs.Digest == models.DigestOff {
+		payload := jobs.NotificationEmailPayload{NotificationID: notification.ID}
+		now := time.Now()
+		if prefs.QuietHours.Contains(now, prefs.Timezone) {
+			err = jobs.EnqueueAt(tx, jobs.TypeNotificationEmail, payload, prefs.QuietHours.EndAfter(now, prefs.Timezone))
+		} else {
+			err = jobs.Enqueue(tx, jobs.TypeNotificationEmail, payload)
+		}
+		if err != nil {
+			log.Printf("Error queueing email for notification %d: %v", notification.ID, err)
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if channel.InApp() {
+		manager.BroadcastNotification(event.UserID, notification)
+		pushUnreadCount(db, event.UserID)
+	}
+	return nil
+}
+
+// taskNotificationData is the payload shared by every task notification
+func taskNotificationData(task models.Task) map[string]interface{} {
+	return map[string]interface{}{
+		"task_id":    task.ID,
+		"title":      task.Title,
+		"status":     task.Status,
+		"project_id": task.ProjectID,
+	}
+}
+
+// notifyTask sends a task notification to recipientID; extra is merged into the payload
+func notifyTask(db *sql.DB, recipientID, actorID int, notificationType models.NotificationType, task models.Task, extra map[string]interface{}) error {
+	data := taskNotificationData(task)
+	for key, value := range extra {
+		data[key] = value
+	}
+	return Notify(db, NotificationEvent{
+		UserID:     recipientID,
+		ActorID:    actorID,
+		Type:       notificationType,
+		EntityType: models.EntityTask,
+		EntityID:   task.ID,
+		Data:       data,
+	})
+}
+
+// SendDueSoonNotification handles jobs.TypeTaskDueSoon jobs, telling the task's owner and assignee that it is due
+// soon if they can still view it. Tasks completed, deleted or rescheduled since the job was queued are skipped.
+func SendDueSoonNotification(ctx context.Context, db *sql.DB, payload json.RawMessage) error {
+	var p jobs.TaskDueSoonPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	var task models.Task
+	err := scanTask(db.QueryRowContext(ctx,
+		"SELECT "+taskColumns+" FROM tasks WHERE id = $1 AND deleted_at IS NULL AND status <> 'done' AND due_date = $2",
+		p.TaskID, p.DueDate,
+	), &task)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	viewers, err := authz.ProjectUsers(db, authz.TaskView, task.ProjectID)
+	if err != nil {
+		return err
+	}
+	canView := map[int]bool{}
+	for _, userID := range viewers {
+		canView[userID] = true
+	}
+
+	recipients := []int{task.UserID}
+	if task.AssigneeID != nil && *task.AssigneeID != task.UserID {
+		recipients = append(recipients, *task.AssigneeID)
+	}
+	for _, recipientID := range recipients {
+		if !canView[recipientID] {
+			continue
+		}
+		if err := notifyTask(db, recipientID, 0, models.NotificationTaskDueSoon, task, map[string]interface{}{"due_date": p.DueDate}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyAssignee tells a task's new assignee about it, unless they assigned it to themselves
+func notifyAssignee(db *sql.DB, actorID int, previous *int, task models.Task) error {
+	if task.AssigneeID == nil || *task.AssigneeID == actorID {
+		return nil
+	}
+	if previous != nil && *previous == *task.AssigneeID {
+		return nil
+	}
+	return notifyTask(db, *task.AssigneeID, actorID, models.NotificationTaskAssigned, task, nil)
+}
+
+// notifyProject sends a project notification to recipientID
+func notifyProject(db *sql.DB, recipientID, actorID int, notificationType models.NotificationType, project models.Project) error {
+	return Notify(db, NotificationEvent{
+		UserID:     recipientID,
+		ActorID:    actorID,
+		Type:       notificationType,
+		EntityType: models.EntityProject,
+		EntityID:   project.ID,
+		Data: map[string]interface{}{
+			"project_id": project.ID,
+			"name":       project.Name,
+		},
+	})
+}
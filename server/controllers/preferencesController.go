@@ -0,0 +1,286 @@
+package controllers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/Inengs/realtime-task-app/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// loadPreferences returns a user's notification preferences, filling in defaults for unset events
+func loadPreferences(db dbExecutor, userID int) (models.NotificationPreferences, error) {
+	prefs := models.NotificationPreferences{Events: make(map[models.PreferenceEvent]models.NotificationChannel)}
+	for event, channel := range models.PreferenceEvents {
+		prefs.Events[event] = channel
+	}
+
+	var start, end sql.NullString
+	err := db.QueryRow("SELECT timezone, quiet_hours_start, quiet_hours_end, digest_frequency FROM users WHERE id = $1", userID).Scan(&prefs.Timezone, &start, &end, &prefs.Digest)
+	if err != nil {
+		return prefs, err
+	}
+	if start.Valid && end.Valid {
+		prefs.QuietHours = &models.QuietHours{Start: start.String, End: end.String}
+	}
+
+	rows, err := db.Query("SELECT event, channel FROM notification_preferences WHERE user_id = $1", userID)
+	if err != nil {
+		return prefs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event models.PreferenceEvent
+		var channel models.NotificationChannel
+		if err := rows.Scan(&event, &channel); err != nil {
+			return prefs, err
+		}
+		if _, ok := models.PreferenceEvents[event]; ok {
+			prefs.Events[event] = channel
+		}
+	}
+	return prefs, rows.Err()
+}
+
+// savePreference stores the channel for one of a user's events
+func savePreference(db dbExecutor, userID int, event models.PreferenceEvent, channel models.NotificationChannel) error {
+	_, err := db.Exec(`
+	INSERT INTO notification_preferences (user_id, event, channel) VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, event) DO UPDATE SET channel = EXCLUDED.channel, updated_at = CURRENT_TIMESTAMP`,
+		userID, event, channel,
+	)
+	return err
+}
+
+// GetNotificationPreferences handles GET /notifications/preferences
+func GetNotificationPreferences(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	prefs, err := loadPreferences(db, userIDInt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification preferences retrieved successfully", "preferences": prefs})
+}
+
+// UpdateNotificationPreferences handles PUT /notifications/preferences.
+// Events are merged into the existing preferences; omitted fields are left unchanged.
+func UpdateNotificationPreferences(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	// Bind and validate request body
+	var input models.NotificationPreferencesInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	validate := validator.New()
+	var errs []string
+	if err := validate.Struct(input); err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			errs = append(errs, fmt.Sprintf("Field '%s' failed on '%s'", err.Field(), err.Tag()))
+		}
+	}
+
+	// quiet_hours: null turns quiet hours off, an object replaces them
+	clearQuietHours := bytes.Equal(bytes.TrimSpace(input.QuietHours), []byte("null"))
+	var quietHours *models.QuietHours
+	if len(input.QuietHours) > 0 && !clearQuietHours {
+		quietHours = &models.QuietHours{}
+		if err := json.Unmarshal(input.QuietHours, quietHours); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if err := validate.Struct(quietHours); err != nil {
+			for _, err := range err.(validator.ValidationErrors) {
+				errs = append(errs, fmt.Sprintf("Field 'QuietHours.%s' failed on '%s'", err.Field(), err.Tag()))
+			}
+		}
+	}
+
+	for event := range input.Events {
+		if _, ok := models.PreferenceEvents[event]; !ok {
+			errs = append(errs, fmt.Sprintf("Unknown event '%s'", event))
+		}
+	}
+	if input.Timezone != nil {
+		if _, err := time.LoadLocation(*input.Timezone); err != nil {
+			errs = append(errs, fmt.Sprintf("Unknown timezone '%s'", *input.Timezone))
+		}
+	}
+	if len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if input.Timezone != nil {
+		if _, err := tx.Exec("UPDATE users SET timezone = $1 WHERE id = $2", *input.Timezone, userIDInt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if input.Digest != nil {
+		if _, err := tx.Exec("UPDATE users SET digest_frequency = $1 WHERE id = $2", *input.Digest, userIDInt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if quietHours != nil || clearQuietHours {
+		var start, end interface{}
+		if quietHours != nil {
+			start, end = quietHours.Start, quietHours.End
+		}
+		if _, err := tx.Exec("UPDATE users SET quiet_hours_start = $1, quiet_hours_end = $2 WHERE id = $3", start, end, userIDInt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	for event, channel := range input.Events {
+		if err := savePreference(tx, userIDInt, event, channel); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+
+	prefs, err := loadPreferences(tx, userIDInt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification preferences updated successfully", "preferences": prefs})
+}
+
+// unsubscribePage asks the user to confirm the unsubscribe, posting back to the link they opened
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body style="font-family: sans-serif; max-width: 480px; margin: 40px auto;">
+{{if .Valid}}
+  <h1>Unsubscribe from emails?</h1>
+  <p>You will stop getting notification and digest emails. Notifications still show up in the app.</p>
+  <form method="post" action="{{.Action}}">
+    <input type="hidden" name="List-Unsubscribe" value="One-Click">
+    <button type="submit">Unsubscribe</button>
+  </form>
+{{else}}
+  <h1>Invalid unsubscribe link</h1>
+  <p>Change which emails you get in your notification preferences instead.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// UnsubscribeConfirmation handles GET /notifications/unsubscribe?token= from the link in notification
+// emails. It only checks the token and shows a page confirming the unsubscribe, because mail scanners
+// and link previews fetch links without the user asking; the page's button sends the POST.
+func UnsubscribeConfirmation(c *gin.Context) {
+	_, err := utils.VerifyUnsubscribeToken(c.Query("token"))
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusBadRequest
+	}
+
+	var page bytes.Buffer
+	if err := unsubscribePage.Execute(&page, gin.H{"Valid": err == nil, "Action": c.Request.URL.RequestURI()}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not render page"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Data(status, "text/html; charset=utf-8", page.Bytes())
+}
+
+// UnsubscribeFromEmails handles POST /notifications/unsubscribe?token=, sent by mail clients' one-click
+// unsubscribe (RFC 8058) and by the confirmation page. It needs no session: the signed token identifies
+// the user. Digests are turned off and every event is switched to in-app only, so no further email is sent.
+func UnsubscribeFromEmails(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	userID, err := utils.VerifyUnsubscribeToken(c.Query("token"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid unsubscribe link"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	prefs, err := loadPreferences(tx, userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE users SET digest_frequency = $1 WHERE id = $2", models.DigestOff, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for event, channel := range prefs.Events {
+		if !channel.Email() {
+			continue
+		}
+		if channel.InApp() {
+			channel = models.ChannelInApp
+		} else {
+			channel = models.ChannelNone
+		}
+		if err := savePreference(tx, userID, event, channel); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unsubscribed from notification emails successfully"})
+}
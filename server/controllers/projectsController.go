@@ -6,11 +6,36 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/Inengs/realtime-task-app/authz"
 	"github.com/Inengs/realtime-task-app/models"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 )
 
+// projectColumns lists the project columns in the order scanProject expects them
+const projectColumns = "id, user_id, COALESCE(workspace_id, 0), name, description, version, created_at, updated_at, deleted_at"
+
+// scanProject scans a row selected with projectColumns into project
+func scanProject(row rowScanner, project *models.Project) error {
+	return row.Scan(&project.ID, &project.UserID, &project.WorkspaceID, &project.Name, &project.Description, &project.Version, &project.CreatedAt, &project.UpdatedAt, &project.DeletedAt)
+}
+
+// projectETag returns the ETag for the current version of project
+func projectETag(project models.Project) string {
+	return resourceETag("project", project.ID, project.Version)
+}
+
+// projectPreconditionFailed writes a 412 with the current project when the request's
+// If-Match header doesn't match it
+func projectPreconditionFailed(c *gin.Context, current models.Project) bool {
+	if !preconditionFailed(c, projectETag(current)) {
+		return false
+	}
+	c.Header("ETag", projectETag(current))
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Project has been modified since it was retrieved", "project": current})
+	return true
+}
+
 func ListProjects(c *gin.Context) {
 	db := c.MustGet("db").(*sql.DB)
 
@@ -21,7 +46,7 @@ func ListProjects(c *gin.Context) {
 	}
 	userIDInt, _ := userID.(int)
 
-	rows, err := db.Query("SELECT id, user_id, name, description, created_at, updated_at FROM projects WHERE user_id = $1", userIDInt)
+	rows, err := db.Query("SELECT "+projectColumns+" FROM projects WHERE "+authz.ProjectCondition(authz.ProjectView, "projects", 1, 2)+" AND deleted_at IS NULL", userIDInt, c.GetInt("workspace_id"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
@@ -31,7 +56,7 @@ func ListProjects(c *gin.Context) {
 	var projects []models.Project
 	for rows.Next() {
 		var project models.Project
-		if err := rows.Scan(&project.ID, &project.UserID, &project.Name, &project.Description, &project.CreatedAt, &project.UpdatedAt); err != nil {
+		if err := scanProject(rows, &project); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 			return
 		}
@@ -66,7 +91,7 @@ func ProjectDetails(c *gin.Context) {
 	userIDInt, _ := userID.(int)
 
 	var project models.Project
-	err = db.QueryRow("SELECT id, user_id, name, description, created_at, updated_at FROM projects WHERE id = $1 AND user_id = $2", projectID, userIDInt).Scan(&project.ID, &project.UserID, &project.Name, &project.Description, &project.CreatedAt, &project.UpdatedAt)
+	err = scanProject(db.QueryRow("SELECT "+projectColumns+" FROM projects WHERE id = $1 AND deleted_at IS NULL AND "+authz.ProjectCondition(authz.ProjectView, "projects", 2, 3), projectID, userIDInt, c.GetInt("workspace_id")), &project)
 
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Project with ID %d not found", projectID)})
@@ -77,6 +102,10 @@ func ProjectDetails(c *gin.Context) {
 		return
 	}
 
+	if notModified(c, projectETag(project)) {
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Project retrieved successfully", "project": project})
 }
 
@@ -108,22 +137,23 @@ func CreateProject(c *gin.Context) {
 	}
 
 	var project models.Project
-	err := db.QueryRow(
-		"INSERT INTO projects (name, description, user_id) VALUES ($1, $2, $3) RETURNING id, user_id, name, description, created_at, updated_at",
-		input.Name, input.Description, userIDInt,
-	).Scan(&project.ID, &project.UserID, &project.Name, &project.Description, &project.CreatedAt, &project.UpdatedAt)
+	err := scanProject(db.QueryRow(
+		"INSERT INTO projects (name, description, user_id, workspace_id) VALUES ($1, $2, $3, $4) RETURNING "+projectColumns,
+		input.Name, input.Description, userIDInt, c.GetInt("workspace_id"),
+	), &project)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 
 	// Send notification and broadcast project
-	if err := SendNotification(db, userIDInt, fmt.Sprintf("New project created: %s", project.Name)); err != nil {
+	if err := notifyProject(db, userIDInt, userIDInt, models.NotificationProjectCreated, project); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send notification"})
 		return
 	}
-	manager.BroadcastProject(userIDInt, project, "project_created")
+	broadcastProject(db, project, "project_created")
 
+	c.Header("ETag", projectETag(project))
 	c.JSON(http.StatusCreated, gin.H{"message": "Project created successfully", "project": project})
 }
 
@@ -138,10 +168,9 @@ func UpdateProject(c *gin.Context) {
 	}
 	userIDInt, _ := userID.(int)
 
-	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+	// Members can see every project in the workspace, but only change their own
+	id, ok := authz.Project(c, db, authz.ProjectUpdate)
+	if !ok {
 		return
 	}
 
@@ -161,11 +190,16 @@ func UpdateProject(c *gin.Context) {
 		return
 	}
 
-	var project models.Project
-	err = db.QueryRow(
-		"UPDATE projects SET name = $1, description = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND user_id = $4 RETURNING id, user_id, name, description, created_at, updated_at",
-		input.Name, input.Description, id, userIDInt,
-	).Scan(&project.ID, &project.UserID, &project.Name, &project.Description, &project.CreatedAt, &project.UpdatedAt)
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the current row and reject the write if the client edited a stale version
+	var current models.Project
+	err = scanProject(tx.QueryRow("SELECT "+projectColumns+" FROM projects WHERE id = $1 AND deleted_at IS NULL AND "+authz.ProjectCondition(authz.ProjectUpdate, "projects", 2, 3)+" FOR UPDATE", id, userIDInt, c.GetInt("workspace_id")), &current)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Project with ID %d not found", id)})
 		return
@@ -174,14 +208,38 @@ func UpdateProject(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+	if projectPreconditionFailed(c, current) {
+		return
+	}
+
+	var project models.Project
+	err = scanProject(tx.QueryRow(
+		"UPDATE projects SET name = $1, description = $2, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND deleted_at IS NULL RETURNING "+projectColumns,
+		input.Name, input.Description, id,
+	), &project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := queueWebhooks(tx, project.ID, models.WebhookProjectUpdated, gin.H{"project": project, "actor_id": userIDInt}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 
 	// Send notification and broadcast project
-	if err := SendNotification(db, userIDInt, fmt.Sprintf("Project updated: %s", project.Name)); err != nil {
+	if err := notifyProject(db, userIDInt, userIDInt, models.NotificationProjectUpdated, project); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send notification"})
 		return
 	}
-	manager.BroadcastProject(userIDInt, project, "project_updated")
+	broadcastProject(db, project, "project_updated")
 
+	c.Header("ETag", projectETag(project))
 	c.JSON(http.StatusOK, gin.H{"message": "Project updated successfully", "project": project})
 }
 
@@ -196,19 +254,25 @@ func DeleteProject(c *gin.Context) {
 	}
 	userIDInt, _ := userID.(int)
 
-	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
+	// Members can see every project in the workspace, but only change their own
+	id, ok := authz.Project(c, db, authz.ProjectDelete)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+	defer tx.Rollback()
 
 	// Fetch project for broadcasting
 	var project models.Project
-	err = db.QueryRow(
-		"SELECT id, user_id, name, description, created_at, updated_at FROM projects WHERE id = $1 AND user_id = $2",
-		id, userIDInt,
-	).Scan(&project.ID, &project.UserID, &project.Name, &project.Description, &project.CreatedAt, &project.UpdatedAt)
+	err = scanProject(tx.QueryRow(
+		"SELECT "+projectColumns+" FROM projects WHERE id = $1 AND deleted_at IS NULL AND "+authz.ProjectCondition(authz.ProjectDelete, "projects", 2, 3)+" FOR UPDATE",
+		id, userIDInt, c.GetInt("workspace_id"),
+	), &project)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Project with ID %d not found", id)})
 		return
@@ -218,15 +282,47 @@ func DeleteProject(c *gin.Context) {
 		return
 	}
 
-	// Delete associated tasks
-	_, err = db.Exec("DELETE FROM tasks WHERE project_id = $1 AND user_id = $2", id, userIDInt)
+	// Reject the delete if the client saw a stale version
+	if projectPreconditionFailed(c, project) {
+		return
+	}
+
+	// Move the project and its tasks to the trash. CURRENT_TIMESTAMP is fixed for the
+	// whole transaction, so both share a deleted_at and can be restored together.
+	rows, err := tx.Query(
+		"UPDATE tasks SET deleted_at = CURRENT_TIMESTAMP, version = version + 1 WHERE project_id = $1 AND deleted_at IS NULL RETURNING "+taskColumns,
+		id,
+	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+	var deletedTasks []models.Task
+	for rows.Next() {
+		var task models.Task
+		if err := scanTask(rows, &task); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		deletedTasks = append(deletedTasks, task)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for i := range deletedTasks {
+		if err := recordTaskRevision(tx, userIDInt, models.RevisionDeleted, &deletedTasks[i], nil); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
 
-	// Delete project
-	result, err := db.Exec("DELETE FROM projects WHERE id = $1 AND user_id = $2", id, userIDInt)
+	result, err := tx.Exec(
+		"UPDATE projects SET deleted_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = $1 AND deleted_at IS NULL",
+		id,
+	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
@@ -242,12 +338,26 @@ func DeleteProject(c *gin.Context) {
 		return
 	}
 
+	err = recordAudit(c, tx, auditEvent{
+		Action: models.AuditProjectDeleted, TargetType: models.EntityProject, TargetID: id,
+		Details: map[string]interface{}{"name": project.Name, "tasks": len(deletedTasks)},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
 	// Send notification and broadcast project deletion
-	if err := SendNotification(db, userIDInt, fmt.Sprintf("Project deleted: %s", project.Name)); err != nil {
+	if err := notifyProject(db, userIDInt, userIDInt, models.NotificationProjectDeleted, project); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send notification"})
 		return
 	}
-	manager.BroadcastProject(userIDInt, project, "project_deleted")
+	broadcastProject(db, project, "project_deleted")
 
 	c.JSON(http.StatusOK, gin.H{"message": "Project deleted successfully"})
 }
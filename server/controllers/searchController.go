@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Inengs/realtime-task-app/authz"
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	headlineOptions    = "StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=10, MaxFragments=2"
+)
+
+// buildPrefixQuery turns free text into a to_tsquery expression where every
+// term is prefix-matched, e.g. "desi log" becomes "desi:* & log:*"
+func buildPrefixQuery(q string) string {
+	var terms []string
+	for _, word := range strings.Fields(q) {
+		// Strip tsquery operators and punctuation so user input can't break the query
+		term := strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToLower(r)
+			}
+			return -1
+		}, word)
+		if term != "" {
+			terms = append(terms, term+":*")
+		}
+	}
+	return strings.Join(terms, " & ")
+}
+
+// SearchFunc handles GET /search to run a ranked full-text search over the caller's tasks and projects
+func SearchFunc(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	tsQuery := buildPrefixQuery(SanitizeInput(c.Query("q")))
+	if tsQuery == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is required"})
+		return
+	}
+
+	// Validate filters
+	searchType := c.Query("type")
+	if searchType != "" && searchType != "task" && searchType != "project" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be 'task' or 'project'"})
+		return
+	}
+
+	status := c.Query("status")
+	if status != "" && status != "pending" && status != "in-progress" && status != "done" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status filter"})
+		return
+	}
+
+	var projectID int
+	if projectIDStr := c.Query("project_id"); projectIDStr != "" {
+		var err error
+		projectID, err = strconv.Atoi(projectIDStr)
+		if err != nil || projectID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+			return
+		}
+	}
+
+	limit := defaultSearchLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		if limit > maxSearchLimit {
+			limit = maxSearchLimit
+		}
+	}
+
+	offset := 0
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		var err error
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+	}
+
+	// $1 is the tsquery, $2 the caller and $3 the active workspace; filters append further placeholders
+	args := []interface{}{tsQuery, userIDInt, c.GetInt("workspace_id")}
+	var parts []string
+
+	// Status only applies to tasks, so a status filter excludes projects
+	if searchType != "project" {
+		taskQuery := `
+		SELECT 'task', t.id, t.project_id, t.title, t.status,
+			ts_headline('english', coalesce(nullif(t.description, ''), t.title), query, '` + headlineOptions + `'),
+			ts_rank(t.search_vector, query)
+		FROM tasks t, to_tsquery('english', $1) query
+		WHERE ` + authz.TaskCondition(authz.TaskView, "t", 2, 3) + ` AND t.deleted_at IS NULL AND t.search_vector @@ query`
+		if projectID > 0 {
+			args = append(args, projectID)
+			taskQuery += fmt.Sprintf(" AND t.project_id = $%d", len(args))
+		}
+		if status != "" {
+			args = append(args, status)
+			taskQuery += fmt.Sprintf(" AND t.status = $%d", len(args))
+		}
+		parts = append(parts, taskQuery)
+	}
+
+	if searchType != "task" && status == "" {
+		projectQuery := `
+		SELECT 'project', p.id, p.id, p.name, '',
+			ts_headline('english', coalesce(nullif(p.description, ''), p.name), query, '` + headlineOptions + `'),
+			ts_rank(p.search_vector, query)
+		FROM projects p, to_tsquery('english', $1) query
+		WHERE ` + authz.ProjectCondition(authz.ProjectView, "p", 2, 3) + ` AND p.deleted_at IS NULL AND p.search_vector @@ query`
+		if projectID > 0 {
+			args = append(args, projectID)
+			projectQuery += fmt.Sprintf(" AND p.id = $%d", len(args))
+		}
+		parts = append(parts, projectQuery)
+	}
+
+	args = append(args, limit, offset)
+	query := strings.Join(parts, " UNION ALL ") +
+		fmt.Sprintf(" ORDER BY 7 DESC, 2 DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("Search query error for user_id %d: %v", userIDInt, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	results := []models.SearchResult{}
+	for rows.Next() {
+		var result models.SearchResult
+		if err := rows.Scan(&result.Type, &result.ID, &result.ProjectID, &result.Title, &result.Status, &result.Snippet, &result.Rank); err != nil {
+			log.Printf("Error scanning search result for user_id %d: %v", userIDInt, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Search completed successfully", "results": results})
+}
@@ -2,15 +2,197 @@ package controllers
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 
+	"github.com/Inengs/realtime-task-app/authz"
 	"github.com/Inengs/realtime-task-app/models"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"github.com/lib/pq"
 )
 
+// taskColumns lists the task columns in the order scanTask expects them
+const taskColumns = "id, user_id, project_id, assignee_id, labels, to_char(due_date, 'YYYY-MM-DD'), title, description, status, version, created_at, updated_at, deleted_at"
+
+// taskETag returns the ETag for the current version of task
+func taskETag(task models.Task) string {
+	return resourceETag("task", task.ID, task.Version)
+}
+
+// taskPreconditionFailed writes a 412 with the current task when the request's
+// If-Match header doesn't match it
+func taskPreconditionFailed(c *gin.Context, current models.Task) bool {
+	if !preconditionFailed(c, taskETag(current)) {
+		return false
+	}
+	c.Header("ETag", taskETag(current))
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Task has been modified since it was retrieved", "task": current})
+	return true
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// dbExecutor is satisfied by both *sql.DB and *sql.Tx so helpers can run inside or outside a transaction
+type dbExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// scanTask scans a row selected with taskColumns into task
+func scanTask(row rowScanner, task *models.Task) error {
+	return row.Scan(&task.ID, &task.UserID, &task.ProjectID, &task.AssigneeID, pq.Array(&task.Labels), &task.DueDate, &task.Title, &task.Description, &task.Status, &task.Version, &task.CreatedAt, &task.UpdatedAt, &task.DeletedAt)
+}
+
+// normalizeLabels sanitizes labels and drops blanks and duplicates, keeping their order
+func normalizeLabels(labels []string) []string {
+	normalized := []string{}
+	seen := make(map[string]bool)
+	for _, label := range labels {
+		label = SanitizeInput(label)
+		if label == "" || seen[label] {
+			continue
+		}
+		seen[label] = true
+		normalized = append(normalized, label)
+	}
+	return normalized
+}
+
+// checkAssignee reports whether a requested assignee is a member of the workspace. Nil and 0 (unassign) are always valid.
+func checkAssignee(db dbExecutor, workspaceID int, assigneeID *int) (bool, error) {
+	if assigneeID == nil || *assigneeID == 0 {
+		return true, nil
+	}
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM workspace_members WHERE workspace_id = $1 AND user_id = $2)", workspaceID, *assigneeID).Scan(&exists)
+	return exists, err
+}
+
+// assigneeValue converts an input assignee into a nullable column value, where 0 means unassigned
+func assigneeValue(assigneeID *int) interface{} {
+	if assigneeID == nil || *assigneeID == 0 {
+		return nil
+	}
+	return *assigneeID
+}
+
+// dueDateValue converts an input due date into a nullable column value, where "" means no due date
+func dueDateValue(dueDate *string) interface{} {
+	if dueDate == nil || *dueDate == "" {
+		return nil
+	}
+	return *dueDate
+}
+
+// insertTask inserts a task owned by userID and records its revision. Run it in a transaction.
+func insertTask(db dbExecutor, userID int, input models.TaskInput) (models.Task, error) {
+	var task models.Task
+	err := scanTask(db.QueryRow(
+		"INSERT INTO tasks (title, description, status, user_id, project_id, assignee_id, labels, due_date) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING "+taskColumns,
+		input.Title, input.Description, input.Status, userID, input.ProjectID, assigneeValue(input.AssigneeID), pq.Array(normalizeLabels(input.Labels)), dueDateValue(input.DueDate),
+	), &task)
+	if err != nil {
+		return task, err
+	}
+
+	if err := recordTaskRevision(db, userID, models.RevisionCreated, nil, &task); err != nil {
+		log.Printf("Task revision error for task %d: %v", task.ID, err)
+		return task, err
+	}
+	return task, nil
+}
+
+// createTask inserts a task and its revision in one transaction
+func createTask(db *sql.DB, userID int, input models.TaskInput) (models.Task, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return models.Task{}, err
+	}
+	defer tx.Rollback()
+
+	task, err := insertTask(tx, userID, input)
+	if err != nil {
+		return task, err
+	}
+	return task, tx.Commit()
+}
+
+// publishTaskCreated sends the notifications and broadcast for a committed new task
+func publishTaskCreated(db *sql.DB, userID int, task models.Task) error {
+	if err := notifyTask(db, userID, userID, models.NotificationTaskCreated, task, nil); err != nil {
+		return err
+	}
+	if err := notifyAssignee(db, userID, nil, task); err != nil {
+		return err
+	}
+	broadcastTask(db, task, "task_update")
+	return nil
+}
+
+// errTaskCheckFailed is returned by changeTaskStatus when its check rejects the change
+var errTaskCheckFailed = errors.New("task check failed")
+
+// errTaskForbidden is returned by changeTaskStatus when the user can see the task but not change it
+var errTaskForbidden = errors.New("task update not permitted")
+
+// changeTaskStatus sets the status of a task userID can see in the workspace and records the revision in one
+// transaction. A non-nil check sees the locked task before the write and rejects it by returning false.
+func changeTaskStatus(db *sql.DB, userID, workspaceID, taskID int, status string, check func(before models.Task) bool) (models.Task, models.Task, error) {
+	var before, task models.Task
+	tx, err := db.Begin()
+	if err != nil {
+		return before, task, err
+	}
+	defer tx.Rollback()
+
+	err = scanTask(tx.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = $1 AND deleted_at IS NULL AND "+authz.TaskCondition(authz.TaskView, "tasks", 2, 3)+" FOR UPDATE", taskID, userID, workspaceID), &before)
+	if err != nil {
+		return before, task, err
+	}
+	roles, err := authz.ProjectRolesOf(tx, userID, workspaceID, before.ProjectID)
+	if err != nil {
+		return before, task, err
+	}
+	if !roles.Can(authz.TaskUpdate) {
+		return before, task, errTaskForbidden
+	}
+	if check != nil && !check(before) {
+		return before, task, errTaskCheckFailed
+	}
+
+	err = scanTask(tx.QueryRow(
+		"UPDATE tasks SET status = $1, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND deleted_at IS NULL RETURNING "+taskColumns,
+		status, taskID,
+	), &task)
+	if err != nil {
+		return before, task, err
+	}
+
+	if err := recordTaskRevision(tx, userID, models.RevisionStatusChanged, &before, &task); err != nil {
+		log.Printf("Task revision error for task %d: %v", task.ID, err)
+		return before, task, err
+	}
+
+	return before, task, tx.Commit()
+}
+
+// publishTaskStatus sends the notification and broadcast for a committed status change
+func publishTaskStatus(db *sql.DB, userID int, before, task models.Task) error {
+	if err := notifyTask(db, userID, userID, models.NotificationTaskStatusChanged, task, map[string]interface{}{"previous_status": before.Status}); err != nil {
+		return err
+	}
+	broadcastTask(db, task, "task_update")
+	return nil
+}
+
 func TaskListFunc(c *gin.Context) {
 	db := c.MustGet("db").(*sql.DB) // Setup database connection
 
@@ -22,7 +204,7 @@ func TaskListFunc(c *gin.Context) {
 	}
 	userIDInt, _ := userID.(int)
 
-	rows, err := db.Query(`SELECT id, user_id, project_id, title, description, status, created_at, updated_at FROM tasks WHERE user_id = $1`, userIDInt) // query all tasks from database
+	rows, err := db.Query(`SELECT `+taskColumns+` FROM tasks WHERE `+authz.TaskCondition(authz.TaskView, "tasks", 1, 2)+` AND deleted_at IS NULL`, userIDInt, c.GetInt("workspace_id")) // query the workspace's tasks from database
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
@@ -34,7 +216,7 @@ func TaskListFunc(c *gin.Context) {
 	var tasks []models.Task
 	for rows.Next() {
 		var task models.Task
-		if err := rows.Scan(&task.ID, &task.UserID, &task.ProjectID, &task.Title, &task.Description, &task.Status, &task.CreatedAt, &task.UpdatedAt); err != nil {
+		if err := scanTask(rows, &task); err != nil {
 			// Return 500 if scanning fails
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 			return
@@ -71,8 +253,7 @@ func TaskDetailsFunc(c *gin.Context) {
 
 	// Query task by ID
 	var task models.Task
-	err = db.QueryRow("SELECT id, user_id, project_id, title, description, status, created_at, updated_at FROM tasks WHERE id = $1 AND user_id = $2", id, userIDInt).
-		Scan(&task.ID, &task.UserID, &task.ProjectID, &task.Title, &task.Description, &task.Status, &task.CreatedAt, &task.UpdatedAt)
+	err = scanTask(db.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = $1 AND deleted_at IS NULL AND "+authz.TaskCondition(authz.TaskView, "tasks", 2, 3), id, userIDInt, c.GetInt("workspace_id")), &task)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Task with ID %d not found", id)})
 		return
@@ -82,6 +263,10 @@ func TaskDetailsFunc(c *gin.Context) {
 		return
 	}
 
+	if notModified(c, taskETag(task)) {
+		return
+	}
+
 	// Return task details
 	c.JSON(http.StatusOK, gin.H{"message": "Task retrieved successfully", "task": task})
 }
@@ -96,6 +281,7 @@ func CreateNewTask(c *gin.Context) {
 		return
 	}
 	userIDInt, _ := userID.(int)
+	workspaceID := c.GetInt("workspace_id")
 
 	// Bind and validate request body
 	var input models.TaskInput
@@ -115,25 +301,36 @@ func CreateNewTask(c *gin.Context) {
 		return
 	}
 
+	if ok, err := checkProject(db, authz.TaskCreate, userIDInt, workspaceID, input.ProjectID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	} else if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Project not found"})
+		return
+	}
+	if ok, err := checkAssignee(db, workspaceID, input.AssigneeID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	} else if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Assignee not found"})
+		return
+	}
+
 	// Insert task into database
-	var task models.Task
-	err := db.QueryRow(
-		"INSERT INTO tasks (title, description, status, user_id, project_id) VALUES ($1, $2, $3, $4, $5) RETURNING id, user_id, project_id, title, description, status, created_at, updated_at",
-		input.Title, input.Description, input.Status, userIDInt, input.ProjectID,
-	).Scan(&task.ID, &task.UserID, &task.ProjectID, &task.Title, &task.Description, &task.Status, &task.CreatedAt, &task.UpdatedAt)
+	task, err := createTask(db, userIDInt, input)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 
-	// Send notification and broadcast task
-	if err := SendNotification(db, userIDInt, fmt.Sprintf("New task created: %s", task.Title)); err != nil {
+	// Send notifications and broadcast task
+	if err := publishTaskCreated(db, userIDInt, task); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send notification"})
 		return
 	}
-	manager.BroadcastTask(userIDInt, task, "task_update")
 
 	// Return created task
+	c.Header("ETag", taskETag(task))
 	c.JSON(http.StatusCreated, gin.H{"message": "Task created successfully", "task": task})
 }
 
@@ -155,6 +352,7 @@ func UpdateTask(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
 		return
 	}
+	workspaceID := c.GetInt("workspace_id")
 
 	// Bind and validate request body
 	var input models.TaskInput
@@ -173,12 +371,31 @@ func UpdateTask(c *gin.Context) {
 		return
 	}
 
-	// Update task in database
-	var task models.Task
-	err = db.QueryRow(
-		"UPDATE tasks SET title = $1, description = $2, status = $3, project_id = $4, updated_at = CURRENT_TIMESTAMP WHERE id = $5 AND user_id = $6 RETURNING id, user_id, project_id, title, description, status, created_at, updated_at",
-		input.Title, input.Description, input.Status, input.ProjectID, id, userIDInt,
-	).Scan(&task.ID, &task.UserID, &task.ProjectID, &task.Title, &task.Description, &task.Status, &task.CreatedAt, &task.UpdatedAt)
+	if ok, err := checkProject(db, authz.TaskCreate, userIDInt, workspaceID, input.ProjectID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	} else if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Project not found"})
+		return
+	}
+	if ok, err := checkAssignee(db, workspaceID, input.AssigneeID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	} else if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Assignee not found"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the current row so the recorded "before" values match what we overwrite
+	var before models.Task
+	err = scanTask(tx.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = $1 AND deleted_at IS NULL AND "+authz.TaskCondition(authz.TaskView, "tasks", 2, 3)+" FOR UPDATE", id, userIDInt, workspaceID), &before)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Task with ID %d not found", id)})
 		return
@@ -188,14 +405,71 @@ func UpdateTask(c *gin.Context) {
 		return
 	}
 
-	// Send notification and broadcast task
-	if err := SendNotification(db, userIDInt, fmt.Sprintf("Task updated: %s", task.Title)); err != nil {
+	if !authz.ProjectAllowed(c, tx, before.ProjectID, authz.TaskUpdate) {
+		return
+	}
+
+	// Reject the write if the client edited a stale version
+	if taskPreconditionFailed(c, before) {
+		return
+	}
+
+	// Keep the current assignee, labels and due date when they are omitted
+	var assignee interface{}
+	if before.AssigneeID != nil {
+		assignee = *before.AssigneeID
+	}
+	if input.AssigneeID != nil {
+		assignee = assigneeValue(input.AssigneeID)
+	}
+	labels := before.Labels
+	if input.Labels != nil {
+		labels = normalizeLabels(input.Labels)
+	}
+	dueDate := dueDateValue(before.DueDate)
+	if input.DueDate != nil {
+		dueDate = dueDateValue(input.DueDate)
+	}
+
+	// Update task in database
+	var task models.Task
+	err = scanTask(tx.QueryRow(
+		"UPDATE tasks SET title = $1, description = $2, status = $3, project_id = $4, assignee_id = $5, labels = $6, due_date = $7, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $8 AND deleted_at IS NULL RETURNING "+taskColumns,
+		input.Title, input.Description, input.Status, input.ProjectID, assignee, pq.Array(labels), dueDate, id,
+	), &task)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := recordTaskRevision(tx, userIDInt, models.RevisionUpdated, &before, &task); err != nil {
+		log.Printf("Task revision error for task %d: %v", task.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Send notifications and broadcast task
+	if err := notifyTask(db, userIDInt, userIDInt, models.NotificationTaskUpdated, task, nil); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send notification"})
 		return
 	}
-	manager.BroadcastTask(userIDInt, task, "task_update")
+	if err := notifyAssignee(db, userIDInt, before.AssigneeID, task); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send notification"})
+		return
+	}
+	if before.ProjectID != task.ProjectID {
+		broadcastTask(db, task, "task_update", before.ProjectID)
+	} else {
+		broadcastTask(db, task, "task_update")
+	}
 
 	// Return Updated task
+	c.Header("ETag", taskETag(task))
 	c.JSON(http.StatusOK, gin.H{"message": "Task updated successfully", "task": task})
 }
 
@@ -218,11 +492,18 @@ func DeleteTask(c *gin.Context) {
 		return
 	}
 
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
 	var task models.Task
-	err = db.QueryRow(
-		"SELECT id, user_id, project_id, title, description, status, created_at, updated_at FROM tasks WHERE id = $1 AND user_id = $2",
-		id, userIDInt,
-	).Scan(&task.ID, &task.UserID, &task.ProjectID, &task.Title, &task.Description, &task.Status, &task.CreatedAt, &task.UpdatedAt)
+	err = scanTask(tx.QueryRow(
+		"SELECT "+taskColumns+" FROM tasks WHERE id = $1 AND deleted_at IS NULL AND "+authz.TaskCondition(authz.TaskView, "tasks", 2, 3)+" FOR UPDATE",
+		id, userIDInt, c.GetInt("workspace_id"),
+	), &task)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Task with ID %d not found", id)})
 		return
@@ -232,7 +513,17 @@ func DeleteTask(c *gin.Context) {
 		return
 	}
 
-	result, err := db.Exec("DELETE FROM tasks WHERE id = $1 and user_id = $2", id, userIDInt)
+	if !authz.ProjectAllowed(c, tx, task.ProjectID, authz.TaskDelete) {
+		return
+	}
+
+	// Reject the delete if the client saw a stale version
+	if taskPreconditionFailed(c, task) {
+		return
+	}
+
+	// Move the task to the trash; it is purged after the retention period
+	result, err := tx.Exec("UPDATE tasks SET deleted_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = $1 AND deleted_at IS NULL", id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
@@ -249,12 +540,31 @@ func DeleteTask(c *gin.Context) {
 		return
 	}
 
+	if err := recordTaskRevision(tx, userIDInt, models.RevisionDeleted, &task, nil); err != nil {
+		log.Printf("Task revision error for task %d: %v", task.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	err = recordAudit(c, tx, auditEvent{
+		Action: models.AuditTaskDeleted, TargetType: models.EntityTask, TargetID: task.ID,
+		Details: map[string]interface{}{"title": task.Title, "project_id": task.ProjectID},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
 	// Send notification and broadcast task deletion
-	if err := SendNotification(db, userIDInt, fmt.Sprintf("Task deleted: %s", task.Title)); err != nil {
+	if err := notifyTask(db, userIDInt, userIDInt, models.NotificationTaskDeleted, task, nil); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send notification"})
 		return
 	}
-	manager.BroadcastTask(userIDInt, task, "task_deleted")
+	broadcastTask(db, task, "task_deleted")
 
 	// Return success response
 	c.JSON(http.StatusOK, gin.H{"message": "Task deleted successfully"})
@@ -295,12 +605,17 @@ func UpdateTaskStatus(c *gin.Context) {
 		return
 	}
 
-	// Update task status in database
-	var task models.Task
-	err = db.QueryRow(
-		"UPDATE tasks SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND user_id = $3 RETURNING id, user_id, project_id, title, description, status, created_at, updated_at",
-		status.Status, id, userIDInt,
-	).Scan(&task.ID, &task.UserID, &task.ProjectID, &task.Title, &task.Description, &task.Status, &task.CreatedAt, &task.UpdatedAt)
+	// Update task status in database, rejecting the write if the client edited a stale version
+	before, task, err := changeTaskStatus(db, userIDInt, c.GetInt("workspace_id"), id, status.Status, func(before models.Task) bool {
+		return !taskPreconditionFailed(c, before)
+	})
+	if err == errTaskCheckFailed {
+		return
+	}
+	if err == errTaskForbidden {
+		authz.Deny(c, authz.TaskUpdate)
+		return
+	}
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Task with ID %d not found", id)})
 		return
@@ -311,12 +626,12 @@ func UpdateTaskStatus(c *gin.Context) {
 	}
 
 	// Send notification and broadcast task
-	if err := SendNotification(db, userIDInt, fmt.Sprintf("Task status updated to %s: %s", task.Status, task.Title)); err != nil {
+	if err := publishTaskStatus(db, userIDInt, before, task); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send notification"})
 		return
 	}
-	manager.BroadcastTask(userIDInt, task, "task_update")
 
 	// Return updated task
+	c.Header("ETag", taskETag(task))
 	c.JSON(http.StatusOK, gin.H{"message": "Task status updated successfully", "task": task})
 }
@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Inengs/realtime-task-app/authz"
+	"github.com/Inengs/realtime-task-app/config"
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/gin-gonic/gin"
+)
+
+// ListTrash handles GET /trash to return the soft-deleted projects the caller manages and tasks they can see in the active workspace
+func ListTrash(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+	workspaceID := c.GetInt("workspace_id")
+
+	projects := []models.Project{}
+	projectRows, err := db.Query("SELECT "+projectColumns+" FROM projects WHERE "+authz.ProjectCondition(authz.ProjectDelete, "projects", 1, 2)+" AND deleted_at IS NOT NULL ORDER BY deleted_at DESC", userIDInt, workspaceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer projectRows.Close()
+	for projectRows.Next() {
+		var project models.Project
+		if err := scanProject(projectRows, &project); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		projects = append(projects, project)
+	}
+	if err := projectRows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	tasks := []models.Task{}
+	taskRows, err := db.Query("SELECT "+taskColumns+" FROM tasks WHERE "+authz.TaskCondition(authz.TaskDelete, "tasks", 1, 2)+" AND deleted_at IS NOT NULL ORDER BY deleted_at DESC", userIDInt, workspaceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer taskRows.Close()
+	for taskRows.Next() {
+		var task models.Task
+		if err := scanTask(taskRows, &task); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		tasks = append(tasks, task)
+	}
+	if err := taskRows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Trash retrieved successfully",
+		"retention_days": int(config.TrashRetention().Hours() / 24),
+		"projects":       projects,
+		"tasks":          tasks,
+	})
+}
+
+// RestoreFromTrash handles POST /trash/:type/:id/restore where type is "tasks" or "projects"
+func RestoreFromTrash(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	switch c.Param("type") {
+	case "tasks", "task":
+		restoreTask(c, db, userIDInt, c.GetInt("workspace_id"), id)
+	case "projects", "project":
+		restoreProject(c, db, userIDInt, c.GetInt("workspace_id"), id)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be 'tasks' or 'projects'"})
+	}
+}
+
+// restoreTask brings a single trashed task back, provided its project isn't trashed too
+func restoreTask(c *gin.Context, db *sql.DB, userID, workspaceID, id int) {
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var trashed models.Task
+	err = scanTask(tx.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = $1 AND deleted_at IS NOT NULL AND "+authz.TaskCondition(authz.TaskDelete, "tasks", 2, 3)+" FOR UPDATE", id, userID, workspaceID), &trashed)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Task with ID %d not found in trash", id)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var projectTrashed bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM projects WHERE id = $1 AND deleted_at IS NOT NULL)", trashed.ProjectID).Scan(&projectTrashed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if projectTrashed {
+		c.JSON(http.StatusConflict, gin.H{"error": "The task's project is in the trash; restore the project first"})
+		return
+	}
+
+	var task models.Task
+	err = scanTask(tx.QueryRow(
+		"UPDATE tasks SET deleted_at = NULL, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $1 RETURNING "+taskColumns,
+		id,
+	), &task)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := recordTaskRevision(tx, userID, models.RevisionRestored, &task, &task); err != nil {
+		log.Printf("Task revision error for task %d: %v", task.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Send notification and broadcast task
+	if err := notifyTask(db, userID, userID, models.NotificationTaskRestored, task, nil); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send notification"})
+		return
+	}
+	broadcastTask(db, task, "task_restored")
+
+	c.Header("ETag", taskETag(task))
+	c.JSON(http.StatusOK, gin.H{"message": "Task restored successfully", "task": task})
+}
+
+// restoreProject brings a trashed project back along with the tasks that were trashed with it
+func restoreProject(c *gin.Context, db *sql.DB, userID, workspaceID, id int) {
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var trashed models.Project
+	err = scanProject(tx.QueryRow("SELECT "+projectColumns+" FROM projects WHERE id = $1 AND deleted_at IS NOT NULL AND "+authz.ProjectCondition(authz.ProjectDelete, "projects", 2, 3)+" FOR UPDATE", id, userID, workspaceID), &trashed)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Project with ID %d not found in trash", id)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Tasks trashed individually before the project keep their own deleted_at and stay in the trash
+	rows, err := tx.Query(
+		"UPDATE tasks SET deleted_at = NULL, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE project_id = $1 AND deleted_at = (SELECT deleted_at FROM projects WHERE id = $1) RETURNING "+taskColumns,
+		id,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	var restoredTasks []models.Task
+	for rows.Next() {
+		var task models.Task
+		if err := scanTask(rows, &task); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		restoredTasks = append(restoredTasks, task)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for i := range restoredTasks {
+		if err := recordTaskRevision(tx, userID, models.RevisionRestored, &restoredTasks[i], &restoredTasks[i]); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+
+	var project models.Project
+	err = scanProject(tx.QueryRow(
+		"UPDATE projects SET deleted_at = NULL, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $1 RETURNING "+projectColumns,
+		id,
+	), &project)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Send notification and broadcast project and its tasks
+	if err := notifyProject(db, userID, userID, models.NotificationProjectRestored, project); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send notification"})
+		return
+	}
+	for _, viewerID := range projectViewers(db, project.ID) {
+		manager.BroadcastProject(viewerID, project, "project_restored")
+		for _, task := range restoredTasks {
+			manager.BroadcastTask(viewerID, task, "task_restored")
+		}
+	}
+
+	c.Header("ETag", projectETag(project))
+	c.JSON(http.StatusOK, gin.H{"message": "Project restored successfully", "project": project, "tasks_restored": len(restoredTasks)})
+}
@@ -9,13 +9,24 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// userListFunc handles GET /users to return a list of all users
+// sharedWorkspace is a condition on users aliased as u that share a workspace with user $1
+const sharedWorkspace = `EXISTS (SELECT 1 FROM workspace_members mine JOIN workspace_members theirs ON theirs.workspace_id = mine.workspace_id
+	WHERE mine.user_id = $1 AND theirs.user_id = u.id)`
+
+// userListFunc handles GET /users to return the users who share a workspace with the caller
 func UserListFunc(c *gin.Context) {
 	// Get database connection from context
 	db := c.MustGet("db").(*sql.DB)
 
-	// Query all users from the database
-	rows, err := db.Query(`SELECT id, username, email FROM users`)
+	// Get user ID from session
+	currentUserID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Query the users the caller works with
+	rows, err := db.Query(`SELECT u.id, u.username, u.email FROM users u WHERE `+sharedWorkspace+` ORDER BY u.id`, currentUserID)
 	if err != nil {
 		// Return 500 for database errors
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
@@ -46,11 +57,18 @@ func UserListFunc(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Users retrieved successfully", "users": users})
 }
 
-// userDetailsFunc handles GET /users/:id to return details of a specific user
+// userDetailsFunc handles GET /users/:id to return details of a user who shares a workspace with the caller
 func UserDetailsFunc(c *gin.Context) {
 	// Get database connection from context
 	db := c.MustGet("db").(*sql.DB)
 
+	// Get user ID from session
+	currentUserID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
 	// Get user ID from URL parameter
 	idStr := c.Param("id")
 	userID, err := strconv.Atoi(idStr)
@@ -62,8 +80,8 @@ func UserDetailsFunc(c *gin.Context) {
 
 	// Query user details by ID
 	var user models.UserResponse
-	query := `SELECT id, username, email FROM users WHERE id=$1`
-	err = db.QueryRow(query, userID).Scan(&user.UserID, &user.Username, &user.Email)
+	query := `SELECT u.id, u.username, u.email FROM users u WHERE u.id = $2 AND ` + sharedWorkspace
+	err = db.QueryRow(query, currentUserID, userID).Scan(&user.UserID, &user.Username, &user.Email)
 	if err == sql.ErrNoRows {
 		// Return 404 if user not found
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
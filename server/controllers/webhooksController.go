@@ -0,0 +1,374 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/authz"
+	"github.com/Inengs/realtime-task-app/jobs"
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/Inengs/realtime-task-app/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultDeliveryLimit = 20
+	maxDeliveryLimit     = 100
+)
+
+// webhookColumns lists the webhook columns in the order scanWebhook expects them
+const webhookColumns = "id, project_id, user_id, url, events, active, created_at, updated_at"
+
+// deliveryColumns lists the delivery columns in the order scanDelivery expects them
+const deliveryColumns = "id, webhook_id, event, payload, status, attempts, response_status, response_body, error, duration_ms, redelivery_of, created_at, delivered_at"
+
+// taskWebhookEvents maps revision actions to the webhook event they trigger
+var taskWebhookEvents = map[string]string{
+	models.RevisionCreated:       models.WebhookTaskCreated,
+	models.RevisionUpdated:       models.WebhookTaskUpdated,
+	models.RevisionStatusChanged: models.WebhookTaskStatusChanged,
+	models.RevisionDeleted:       models.WebhookTaskDeleted,
+}
+
+// scanWebhook scans a row selected with webhookColumns into webhook
+func scanWebhook(row rowScanner, webhook *models.Webhook) error {
+	return row.Scan(&webhook.ID, &webhook.ProjectID, &webhook.UserID, &webhook.URL, pq.Array(&webhook.Events), &webhook.Active, &webhook.CreatedAt, &webhook.UpdatedAt)
+}
+
+// scanDelivery scans a row selected with deliveryColumns into delivery
+func scanDelivery(row rowScanner, delivery *models.WebhookDelivery) error {
+	var payload []byte
+	err := row.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &payload, &delivery.Status, &delivery.Attempts, &delivery.ResponseStatus,
+		&delivery.ResponseBody, &delivery.Error, &delivery.DurationMS, &delivery.RedeliveryOf, &delivery.CreatedAt, &delivery.DeliveredAt)
+	delivery.Payload = payload
+	return err
+}
+
+// queueWebhooks records a delivery for every active webhook of the project subscribed to event
+// and queues it. Call it inside the transaction of the change, so deliveries exist only if it commits.
+func queueWebhooks(db dbExecutor, projectID int, event string, data interface{}) error {
+	rows, err := db.Query("SELECT id FROM webhooks WHERE project_id = $1 AND active AND $2 = ANY(events)", projectID, event)
+	if err != nil {
+		return err
+	}
+	var webhookIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		webhookIDs = append(webhookIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(webhookIDs) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(gin.H{"event": event, "created_at": time.Now().UTC(), "data": data})
+	if err != nil {
+		return err
+	}
+	for _, webhookID := range webhookIDs {
+		if _, err := queueDelivery(db, webhookID, event, body, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queueDelivery inserts a pending delivery and its job, returning the delivery ID
+func queueDelivery(db dbExecutor, webhookID int, event string, body []byte, redeliveryOf *int64) (int64, error) {
+	var deliveryID int64
+	err := db.QueryRow(
+		"INSERT INTO webhook_deliveries (webhook_id, event, payload, redelivery_of) VALUES ($1, $2, $3, $4) RETURNING id",
+		webhookID, event, body, redeliveryOf,
+	).Scan(&deliveryID)
+	if err != nil {
+		return 0, err
+	}
+	return deliveryID, jobs.Enqueue(db, jobs.TypeWebhookDelivery, jobs.WebhookDeliveryPayload{DeliveryID: deliveryID})
+}
+
+// queueTaskWebhooks sends the webhook event for a task revision, if the action has one
+func queueTaskWebhooks(db dbExecutor, actorID int, action string, task *models.Task, changes map[string]models.FieldChange) error {
+	event, ok := taskWebhookEvents[action]
+	if !ok {
+		return nil
+	}
+	return queueWebhooks(db, task.ProjectID, event, gin.H{"task": task, "changes": changes, "actor_id": actorID})
+}
+
+// projectWebhook loads :webhookId of project :id, which the user must manage, writing the error response if it can't
+func projectWebhook(c *gin.Context, db *sql.DB) (models.Webhook, bool) {
+	var webhook models.Webhook
+	projectID, ok := authz.Project(c, db, authz.ProjectUpdate)
+	if !ok {
+		return webhook, false
+	}
+
+	webhookID, err := strconv.Atoi(c.Param("webhookId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return webhook, false
+	}
+
+	err = scanWebhook(db.QueryRow("SELECT "+webhookColumns+" FROM webhooks WHERE id = $1 AND project_id = $2", webhookID, projectID), &webhook)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Webhook with ID %d not found", webhookID)})
+		return webhook, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return webhook, false
+	}
+	return webhook, true
+}
+
+// CreateWebhook handles POST /projects/:id/webhooks. The signing secret is only returned here.
+func CreateWebhook(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	projectID, ok := authz.Project(c, db, authz.ProjectUpdate)
+	if !ok {
+		return
+	}
+
+	// Bind and validate request body
+	var input models.WebhookInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(input); err != nil {
+		var errs []string
+		for _, err := range err.(validator.ValidationErrors) {
+			errs = append(errs, fmt.Sprintf("Field '%s' failed on '%s'", err.Field(), err.Tag()))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+	if err := jobs.CheckWebhookURL(c.Request.Context(), input.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret, err := utils.GenerateWebhookSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate webhook secret"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var webhook models.Webhook
+	err = scanWebhook(tx.QueryRow(
+		"INSERT INTO webhooks (project_id, user_id, url, secret, events) VALUES ($1, $2, $3, $4, $5) RETURNING "+webhookColumns,
+		projectID, userIDInt, input.URL, secret, pq.Array(input.Events),
+	), &webhook)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	webhook.Secret = secret
+
+	if err := recordAudit(c, tx, auditEvent{
+		Action: models.AuditWebhookCreated, TargetType: models.AuditTargetWebhook, TargetID: webhook.ID,
+		Details: map[string]interface{}{"project_id": projectID, "url": webhook.URL},
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Webhook created successfully", "webhook": webhook})
+}
+
+// ListWebhooks handles GET /projects/:id/webhooks
+func ListWebhooks(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	projectID, ok := authz.Project(c, db, authz.ProjectUpdate)
+	if !ok {
+		return
+	}
+
+	rows, err := db.Query("SELECT "+webhookColumns+" FROM webhooks WHERE project_id = $1 ORDER BY id", projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		var webhook models.Webhook
+		if err := scanWebhook(rows, &webhook); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhooks retrieved successfully", "webhooks": webhooks})
+}
+
+// DeleteWebhook handles DELETE /projects/:id/webhooks/:webhookId; its delivery log goes with it
+func DeleteWebhook(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	webhook, ok := projectWebhook(c, db)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM webhooks WHERE id = $1", webhook.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := recordAudit(c, tx, auditEvent{
+		Action: models.AuditWebhookDeleted, TargetType: models.AuditTargetWebhook, TargetID: webhook.ID,
+		Details: map[string]interface{}{"project_id": webhook.ProjectID, "url": webhook.URL},
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// ListWebhookDeliveries handles GET /projects/:id/webhooks/:webhookId/deliveries?limit= with the most recent first
+func ListWebhookDeliveries(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	webhook, ok := projectWebhook(c, db)
+	if !ok {
+		return
+	}
+
+	limit := defaultDeliveryLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		if limit > maxDeliveryLimit {
+			limit = maxDeliveryLimit
+		}
+	}
+
+	rows, err := db.Query("SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY id DESC LIMIT $2", webhook.ID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		if err := scanDelivery(rows, &delivery); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Deliveries retrieved successfully", "deliveries": deliveries})
+}
+
+// RedeliverWebhook handles POST /projects/:id/webhooks/:webhookId/deliveries/:deliveryId/redeliver.
+// It queues a new delivery with the same body; the original stays in the log.
+func RedeliverWebhook(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	webhook, ok := projectWebhook(c, db)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := strconv.ParseInt(c.Param("deliveryId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+		return
+	}
+
+	var original models.WebhookDelivery
+	err = scanDelivery(db.QueryRow("SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE id = $1 AND webhook_id = $2", deliveryID, webhook.ID), &original)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Delivery with ID %d not found", deliveryID)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	redeliveryID, err := queueDelivery(tx, webhook.ID, original.Event, original.Payload, &original.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Redelivery queued successfully", "delivery_id": redeliveryID})
+}
@@ -0,0 +1,665 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Inengs/realtime-task-app/authz"
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// workspaceColumns lists the workspace columns in the order scanWorkspace expects them
+const workspaceColumns = "id, name, created_by, created_at, updated_at"
+
+// scanWorkspace scans a row selected with workspaceColumns into workspace
+func scanWorkspace(row rowScanner, workspace *models.Workspace) error {
+	return row.Scan(&workspace.ID, &workspace.Name, &workspace.CreatedBy, &workspace.CreatedAt, &workspace.UpdatedAt)
+}
+
+// checkProject reports whether the user has perm on projectID in the workspace, such as filing tasks in it
+func checkProject(db dbExecutor, perm authz.Permission, userID, workspaceID, projectID int) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM projects WHERE id = $1 AND deleted_at IS NULL AND "+authz.ProjectCondition(perm, "projects", 2, 3)+")", projectID, userID, workspaceID).Scan(&exists)
+	return exists, err
+}
+
+// keepsAnAdmin reports whether the workspace still has an admin other than userID. Call it inside the
+// transaction that demotes or removes userID, after lockWorkspace.
+func keepsAnAdmin(tx *sql.Tx, workspaceID, userID int) (bool, error) {
+	var exists bool
+	err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM workspace_members WHERE workspace_id = $1 AND user_id <> $2 AND role = 'admin')", workspaceID, userID).Scan(&exists)
+	return exists, err
+}
+
+// lockWorkspace locks the workspace row so concurrent membership changes can't remove every admin
+func lockWorkspace(tx *sql.Tx, workspaceID int) error {
+	_, err := tx.Exec("SELECT id FROM workspaces WHERE id = $1 FOR UPDATE", workspaceID)
+	return err
+}
+
+// ListWorkspaces handles GET /workspaces, the workspaces the user belongs to with their role in each
+func ListWorkspaces(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	rows, err := db.Query(
+		"SELECT w.id, w.name, w.created_by, w.created_at, w.updated_at, m.role FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id WHERE m.user_id = $1 ORDER BY m.created_at, w.id",
+		userIDInt,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	workspaces := []models.Workspace{}
+	for rows.Next() {
+		var workspace models.Workspace
+		if err := scanWorkspace(extraScanner{rows, []interface{}{&workspace.Role}}, &workspace); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		workspaces = append(workspaces, workspace)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Workspaces retrieved successfully", "workspaces": workspaces})
+}
+
+// CreateWorkspace handles POST /workspaces; the creator becomes its admin
+func CreateWorkspace(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+
+	var input models.WorkspaceInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(input); err != nil {
+		var errs []string
+		for _, err := range err.(validator.ValidationErrors) {
+			errs = append(errs, fmt.Sprintf("Field '%s' failed on '%s'", err.Field(), err.Tag()))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var workspace models.Workspace
+	err = scanWorkspace(tx.QueryRow("INSERT INTO workspaces (name, created_by) VALUES ($1, $2) RETURNING "+workspaceColumns, SanitizeInput(input.Name), userIDInt), &workspace)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if _, err := tx.Exec("INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, $3)", workspace.ID, userIDInt, models.RoleAdmin); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	workspace.Role = models.RoleAdmin
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Workspace created successfully", "workspace": workspace})
+}
+
+// UpdateWorkspace handles PUT /workspaces/:workspaceId for workspace admins
+func UpdateWorkspace(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	var input models.WorkspaceInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(input); err != nil {
+		var errs []string
+		for _, err := range err.(validator.ValidationErrors) {
+			errs = append(errs, fmt.Sprintf("Field '%s' failed on '%s'", err.Field(), err.Tag()))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	var workspace models.Workspace
+	err := scanWorkspace(db.QueryRow(
+		"UPDATE workspaces SET name = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 RETURNING "+workspaceColumns,
+		SanitizeInput(input.Name), c.GetInt("workspace_id"),
+	), &workspace)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	workspace.Role = models.RoleAdmin
+
+	c.JSON(http.StatusOK, gin.H{"message": "Workspace updated successfully", "workspace": workspace})
+}
+
+// ListWorkspaceMembers handles GET /workspaces/:workspaceId/members
+func ListWorkspaceMembers(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	rows, err := db.Query(
+		"SELECT u.id, u.username, u.email, m.role, m.created_at FROM workspace_members m JOIN users u ON u.id = m.user_id WHERE m.workspace_id = $1 ORDER BY m.created_at, u.id",
+		c.GetInt("workspace_id"),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	members := []models.WorkspaceMember{}
+	for rows.Next() {
+		var member models.WorkspaceMember
+		if err := rows.Scan(&member.UserID, &member.Username, &member.Email, &member.Role, &member.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Members retrieved successfully", "members": members})
+}
+
+// AddWorkspaceMember handles POST /workspaces/:workspaceId/members, adding an existing user by email
+func AddWorkspaceMember(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	var input models.WorkspaceMemberInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(input); err != nil {
+		var errs []string
+		for _, err := range err.(validator.ValidationErrors) {
+			errs = append(errs, fmt.Sprintf("Field '%s' failed on '%s'", err.Field(), err.Tag()))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	var member models.WorkspaceMember
+	err := db.QueryRow("SELECT id, username, email FROM users WHERE LOWER(email) = LOWER($1)", input.Email).Scan(&member.UserID, &member.Username, &member.Email)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		"INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING RETURNING role, created_at",
+		c.GetInt("workspace_id"), member.UserID, input.Role,
+	).Scan(&member.Role, &member.CreatedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is already a member of this workspace"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	err = recordAudit(c, tx, auditEvent{
+		Action: models.AuditWorkspaceMemberAdded, TargetType: models.AuditTargetUser, TargetID: member.UserID,
+		Details: map[string]interface{}{"workspace_id": c.GetInt("workspace_id"), "role": member.Role},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Member added successfully", "member": member})
+}
+
+// UpdateWorkspaceMember handles PATCH /workspaces/:workspaceId/members/:userId. The last admin can't be demoted.
+func UpdateWorkspaceMember(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+	workspaceID := c.GetInt("workspace_id")
+
+	memberID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var input models.WorkspaceRoleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(input); err != nil {
+		var errs []string
+		for _, err := range err.(validator.ValidationErrors) {
+			errs = append(errs, fmt.Sprintf("Field '%s' failed on '%s'", err.Field(), err.Tag()))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockWorkspace(tx, workspaceID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if input.Role != models.RoleAdmin {
+		if ok, err := keepsAnAdmin(tx, workspaceID, memberID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		} else if !ok {
+			c.JSON(http.StatusConflict, gin.H{"error": "A workspace needs at least one admin"})
+			return
+		}
+	}
+
+	// Joining the row to itself returns the role from before the update
+	var member models.WorkspaceMember
+	var previousRole string
+	err = tx.QueryRow(
+		`UPDATE workspace_members m SET role = $1 FROM users u, workspace_members old
+		WHERE u.id = m.user_id AND m.workspace_id = $2 AND m.user_id = $3 AND old.workspace_id = m.workspace_id AND old.user_id = m.user_id
+		RETURNING u.id, u.username, u.email, m.role, m.created_at, old.role`,
+		input.Role, workspaceID, memberID,
+	).Scan(&member.UserID, &member.Username, &member.Email, &member.Role, &member.CreatedAt, &previousRole)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	err = recordAudit(c, tx, auditEvent{
+		Action: models.AuditWorkspaceRoleChanged, TargetType: models.AuditTargetUser, TargetID: memberID,
+		Details: map[string]interface{}{"workspace_id": workspaceID, "from": previousRole, "to": member.Role},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member updated successfully", "member": member})
+}
+
+// RemoveWorkspaceMember handles DELETE /workspaces/:workspaceId/members/:userId. Admins remove anyone,
+// and every member can remove themselves, except the last admin. Their project memberships in the
+// workspace go with it; projects they own stay and are managed by the workspace admins.
+func RemoveWorkspaceMember(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	// Get user ID from session
+	userID, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	userIDInt, _ := userID.(int)
+	workspaceID := c.GetInt("workspace_id")
+
+	memberID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if memberID != userIDInt && !authz.Check(c, authz.MemberManage) {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockWorkspace(tx, workspaceID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if ok, err := keepsAnAdmin(tx, workspaceID, memberID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	} else if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "A workspace needs at least one admin"})
+		return
+	}
+
+	result, err := tx.Exec("DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2", workspaceID, memberID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	} else if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM project_members WHERE user_id = $1 AND project_id IN (SELECT id FROM projects WHERE workspace_id = $2)", memberID, workspaceID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	err = recordAudit(c, tx, auditEvent{
+		Action: models.AuditWorkspaceMemberRemoved, TargetType: models.AuditTargetUser, TargetID: memberID,
+		Details: map[string]interface{}{"workspace_id": workspaceID, "left": memberID == userIDInt},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// ListProjectMembers handles GET /projects/:id/members
+func ListProjectMembers(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	projectID, ok := authz.Project(c, db, authz.MemberView)
+	if !ok {
+		return
+	}
+
+	rows, err := db.Query(
+		"SELECT u.id, u.username, u.email, pm.role, pm.created_at FROM project_members pm JOIN users u ON u.id = pm.user_id WHERE pm.project_id = $1 ORDER BY pm.created_at, u.id",
+		projectID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	members := []models.ProjectMember{}
+	for rows.Next() {
+		var member models.ProjectMember
+		if err := rows.Scan(&member.UserID, &member.Username, &member.Email, &member.Role, &member.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Members retrieved successfully", "members": members})
+}
+
+// AddProjectMember handles POST /projects/:id/members with a project role, editor by default.
+// Only members of the project's workspace can be added.
+func AddProjectMember(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	projectID, ok := authz.Project(c, db, authz.MemberInvite)
+	if !ok {
+		return
+	}
+
+	var input models.ProjectMemberInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(input); err != nil {
+		var errs []string
+		for _, err := range err.(validator.ValidationErrors) {
+			errs = append(errs, fmt.Sprintf("Field '%s' failed on '%s'", err.Field(), err.Tag()))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+	if input.Role == "" {
+		input.Role = models.ProjectRoleEditor
+	}
+
+	var member models.ProjectMember
+	err := db.QueryRow(
+		"SELECT u.id, u.username, u.email FROM users u JOIN workspace_members m ON m.user_id = u.id WHERE u.id = $1 AND m.workspace_id = $2",
+		input.UserID, c.GetInt("workspace_id"),
+	).Scan(&member.UserID, &member.Username, &member.Email)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User is not a member of this workspace"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		"INSERT INTO project_members (project_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING RETURNING role, created_at",
+		projectID, member.UserID, input.Role,
+	).Scan(&member.Role, &member.CreatedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is already a member of this project"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	err = recordAudit(c, tx, auditEvent{
+		Action: models.AuditProjectMemberAdded, TargetType: models.AuditTargetUser, TargetID: member.UserID,
+		Details: map[string]interface{}{"project_id": projectID, "role": member.Role},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Member added successfully", "member": member})
+}
+
+// UpdateProjectMember handles PATCH /projects/:id/members/:userId, changing a member's project role
+func UpdateProjectMember(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	projectID, ok := authz.Project(c, db, authz.MemberManage)
+	if !ok {
+		return
+	}
+
+	memberID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var input models.ProjectRoleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(input); err != nil {
+		var errs []string
+		for _, err := range err.(validator.ValidationErrors) {
+			errs = append(errs, fmt.Sprintf("Field '%s' failed on '%s'", err.Field(), err.Tag()))
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	// Joining the row to itself returns the role from before the update
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var member models.ProjectMember
+	var previousRole string
+	err = tx.QueryRow(`
+	UPDATE project_members pm SET role = $3 FROM users u, project_members old
+	WHERE pm.project_id = $1 AND pm.user_id = $2 AND u.id = pm.user_id AND old.project_id = pm.project_id AND old.user_id = pm.user_id
+	RETURNING u.id, u.username, u.email, pm.role, pm.created_at, old.role`,
+		projectID, memberID, input.Role,
+	).Scan(&member.UserID, &member.Username, &member.Email, &member.Role, &member.CreatedAt, &previousRole)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	err = recordAudit(c, tx, auditEvent{
+		Action: models.AuditProjectRoleChanged, TargetType: models.AuditTargetUser, TargetID: memberID,
+		Details: map[string]interface{}{"project_id": projectID, "from": previousRole, "to": member.Role},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member updated successfully", "member": member})
+}
+
+// RemoveProjectMember handles DELETE /projects/:id/members/:userId
+func RemoveProjectMember(c *gin.Context) {
+	db := c.MustGet("db").(*sql.DB)
+
+	projectID, ok := authz.Project(c, db, authz.MemberManage)
+	if !ok {
+		return
+	}
+
+	memberID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM project_members WHERE project_id = $1 AND user_id = $2", projectID, memberID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	} else if rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	err = recordAudit(c, tx, auditEvent{
+		Action: models.AuditProjectMemberRemoved, TargetType: models.AuditTargetUser, TargetID: memberID,
+		Details: map[string]interface{}{"project_id": projectID},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
@@ -1,10 +1,12 @@
 package controllers
 
 import (
+	"database/sql"
 	"log"
 	"net/http"
 	"sync"
 
+	"github.com/Inengs/realtime-task-app/authz"
 	"github.com/Inengs/realtime-task-app/models"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -93,6 +95,31 @@ func (m *ClientManager) RemoveProjectClient(userID int, conn *websocket.Conn) {
 	}
 }
 
+// ConnectionCount returns the number of open WebSocket connections
+func (m *ClientManager) ConnectionCount() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	count := 0
+	for _, clients := range []map[int][]*websocket.Conn{m.clients, m.taskClients, m.projectClients} {
+		for _, conns := range clients {
+			count += len(conns)
+		}
+	}
+	return count
+}
+
+// CloseUser closes every WebSocket connection of a user, such as when their account is disabled.
+// Each connection's read loop then removes it.
+func (m *ClientManager) CloseUser(userID int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, clients := range []map[int][]*websocket.Conn{m.clients, m.taskClients, m.projectClients} {
+		for _, conn := range clients[userID] {
+			conn.Close()
+		}
+	}
+}
+
 // BroadcastTask sends a task update to all task clients of a user
 func (m *ClientManager) BroadcastTask(userID int, task models.Task, messageType string) {
 	m.mutex.Lock()
@@ -113,6 +140,44 @@ func (m *ClientManager) BroadcastTask(userID int, task models.Task, messageType
 	}
 }
 
+// BroadcastTaskBatch sends the result of a bulk operation as a single event to all task clients of a user
+func (m *ClientManager) BroadcastTaskBatch(userID int, operation string, tasks []models.Task) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	message := gin.H{"type": "task_bulk_update", "data": gin.H{"operation": operation, "tasks": tasks}}
+	for _, conn := range m.taskClients[userID] {
+		if err := conn.WriteJSON(message); err != nil {
+			log.Printf("Error broadcasting task batch to user %d: %v", userID, err)
+			conn.Close()
+		}
+	}
+	for _, conn := range m.clients[userID] {
+		if err := conn.WriteJSON(message); err != nil {
+			log.Printf("Error broadcasting task batch to notification client user %d: %v", userID, err)
+			conn.Close()
+		}
+	}
+}
+
+// BroadcastImport sends the progress or result of a task import to all task clients of a user
+func (m *ClientManager) BroadcastImport(userID int, taskImport models.TaskImport, messageType string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	message := gin.H{"type": messageType, "data": taskImport}
+	for _, conn := range m.taskClients[userID] {
+		if err := conn.WriteJSON(message); err != nil {
+			log.Printf("Error broadcasting import to user %d: %v", userID, err)
+			conn.Close()
+		}
+	}
+	for _, conn := range m.clients[userID] {
+		if err := conn.WriteJSON(message); err != nil {
+			log.Printf("Error broadcasting import to notification client user %d: %v", userID, err)
+			conn.Close()
+		}
+	}
+}
+
 // BroadcastNotification sends a notification to all notification clients of a user
 func (m *ClientManager) BroadcastNotification(userID int, notification models.Notifications) {
 	m.mutex.Lock()
@@ -126,6 +191,19 @@ func (m *ClientManager) BroadcastNotification(userID int, notification models.No
 	}
 }
 
+// BroadcastUnreadCount sends the current unread notification count to all notification clients of a user
+func (m *ClientManager) BroadcastUnreadCount(userID int, count int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	message := gin.H{"type": "unread_count", "data": gin.H{"unread_count": count}}
+	for _, conn := range m.clients[userID] {
+		if err := conn.WriteJSON(message); err != nil {
+			log.Printf("Error broadcasting unread count to user %d: %v", userID, err)
+			conn.Close()
+		}
+	}
+}
+
 // BroadcastProjectEvent sends project events to project WebSocket clients
 func (m *ClientManager) BroadcastProjectEvent(userID int, eventType string, project interface{}) {
 	m.mutex.Lock()
@@ -163,6 +241,64 @@ func (m *ClientManager) BroadcastProject(userID int, project models.Project, mes
 	}
 }
 
+// projectViewers returns the users who can view any of the projects, each once. Lookup errors are logged and
+// the project skipped, since the change has already been committed.
+func projectViewers(db *sql.DB, projectIDs ...int) []int {
+	seen := map[int]bool{}
+	var userIDs []int
+	for _, projectID := range projectIDs {
+		viewers, err := authz.ProjectUsers(db, authz.ProjectView, projectID)
+		if err != nil {
+			log.Printf("Error loading viewers of project %d: %v", projectID, err)
+			continue
+		}
+		for _, userID := range viewers {
+			if !seen[userID] {
+				seen[userID] = true
+				userIDs = append(userIDs, userID)
+			}
+		}
+	}
+	return userIDs
+}
+
+// broadcastTask sends a task event to everyone who can view the task's project, and the projects in also,
+// such as the one the task moved out of
+func broadcastTask(db *sql.DB, task models.Task, messageType string, also ...int) {
+	for _, userID := range projectViewers(db, append([]int{task.ProjectID}, also...)...) {
+		manager.BroadcastTask(userID, task, messageType)
+	}
+}
+
+// broadcastTaskBatch sends the result of a bulk operation to everyone who can view the tasks' projects, each
+// getting only the tasks they can view
+func broadcastTaskBatch(db *sql.DB, operation string, tasks []models.Task) {
+	visible := map[int][]models.Task{}
+	var userIDs []int
+	viewers := map[int][]int{}
+	for _, task := range tasks {
+		if _, ok := viewers[task.ProjectID]; !ok {
+			viewers[task.ProjectID] = projectViewers(db, task.ProjectID)
+		}
+		for _, userID := range viewers[task.ProjectID] {
+			if _, ok := visible[userID]; !ok {
+				userIDs = append(userIDs, userID)
+			}
+			visible[userID] = append(visible[userID], task)
+		}
+	}
+	for _, userID := range userIDs {
+		manager.BroadcastTaskBatch(userID, operation, visible[userID])
+	}
+}
+
+// broadcastProject sends a project event to everyone who can view the project
+func broadcastProject(db *sql.DB, project models.Project, messageType string) {
+	for _, userID := range projectViewers(db, project.ID) {
+		manager.BroadcastProject(userID, project, messageType)
+	}
+}
+
 // WebSocketHandler handles WebSocket connections for notifications
 func WebSocketHandler(c *gin.Context) {
 	// Get user ID from session
@@ -75,6 +75,524 @@ func InitDB(db *sql.DB) error {
 		return err
 	}
 
+	// Add full-text search vectors and GIN indexes for tasks and projects
+	_, err = db.Exec(`
+	ALTER TABLE tasks ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+			setweight(to_tsvector('english', coalesce(description, '')), 'B')
+		) STORED;
+	CREATE INDEX IF NOT EXISTS idx_tasks_search_vector ON tasks USING GIN (search_vector);
+
+	ALTER TABLE projects ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+			setweight(to_tsvector('english', coalesce(description, '')), 'B')
+		) STORED;
+	CREATE INDEX IF NOT EXISTS idx_projects_search_vector ON projects USING GIN (search_vector);
+	`)
+	if err != nil {
+		log.Printf("Error creating search indexes: %v", err)
+		return err
+	}
+
+	// Create task revisions table (kept after the task itself is deleted)
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS task_revisions (
+		id SERIAL PRIMARY KEY,
+		task_id INTEGER NOT NULL,
+		project_id INTEGER,
+		actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		action TEXT NOT NULL CHECK (action IN ('created', 'updated', 'status_changed', 'deleted')),
+		changes JSONB NOT NULL DEFAULT '{}',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_task_revisions_task ON task_revisions (task_id, created_at);
+	CREATE INDEX IF NOT EXISTS idx_task_revisions_project ON task_revisions (project_id, id);
+	`)
+	if err != nil {
+		log.Printf("Error creating task_revisions table: %v", err)
+		return err
+	}
+
+	// Add version columns used for optimistic concurrency control (ETags)
+	_, err = db.Exec(`
+	ALTER TABLE tasks ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE projects ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+	`)
+	if err != nil {
+		log.Printf("Error adding version columns: %v", err)
+		return err
+	}
+
+	// Add soft delete columns; trashed rows are purged after the retention period
+	_, err = db.Exec(`
+	ALTER TABLE tasks ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+	ALTER TABLE projects ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+	CREATE INDEX IF NOT EXISTS idx_tasks_deleted_at ON tasks (deleted_at) WHERE deleted_at IS NOT NULL;
+	CREATE INDEX IF NOT EXISTS idx_projects_deleted_at ON projects (deleted_at) WHERE deleted_at IS NOT NULL;
+
+	ALTER TABLE task_revisions DROP CONSTRAINT IF EXISTS task_revisions_action_check;
+	ALTER TABLE task_revisions ADD CONSTRAINT task_revisions_action_check
+		CHECK (action IN ('created', 'updated', 'status_changed', 'deleted', 'restored'));
+	`)
+	if err != nil {
+		log.Printf("Error adding soft delete columns: %v", err)
+		return err
+	}
+
+	// Add task assignees and labels
+	_, err = db.Exec(`
+	ALTER TABLE tasks ADD COLUMN IF NOT EXISTS assignee_id INTEGER REFERENCES users(id) ON DELETE SET NULL;
+	ALTER TABLE tasks ADD COLUMN IF NOT EXISTS labels TEXT[] NOT NULL DEFAULT '{}';
+	CREATE INDEX IF NOT EXISTS idx_tasks_assignee ON tasks (assignee_id);
+	CREATE INDEX IF NOT EXISTS idx_tasks_labels ON tasks USING GIN (labels);
+	`)
+	if err != nil {
+		log.Printf("Error adding assignee and label columns: %v", err)
+		return err
+	}
+
+	// Index notifications for per-user cursor pagination and unread counts
+	_, err = db.Exec(`
+	CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications (user_id, id DESC);
+	CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications (user_id) WHERE is_read = false;
+	`)
+	if err != nil {
+		log.Printf("Error creating notification indexes: %v", err)
+		return err
+	}
+
+	// Add structured notification fields; existing rows become "system" notifications
+	_, err = db.Exec(`
+	ALTER TABLE notifications ADD COLUMN IF NOT EXISTS type TEXT NOT NULL DEFAULT 'system';
+	ALTER TABLE notifications ADD COLUMN IF NOT EXISTS actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL;
+	ALTER TABLE notifications ADD COLUMN IF NOT EXISTS entity_type TEXT;
+	ALTER TABLE notifications ADD COLUMN IF NOT EXISTS entity_id INTEGER;
+	ALTER TABLE notifications ADD COLUMN IF NOT EXISTS data JSONB NOT NULL DEFAULT '{}';
+	`)
+	if err != nil {
+		log.Printf("Error adding notification fields: %v", err)
+		return err
+	}
+
+	// Create notification preferences; events without a row use the defaults in models.PreferenceEvents
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS notification_preferences (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		event TEXT NOT NULL,
+		channel TEXT NOT NULL CHECK (channel IN ('in_app', 'email', 'both', 'none')),
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, event)
+	);
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'UTC';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS quiet_hours_start TEXT;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS quiet_hours_end TEXT;
+	`)
+	if err != nil {
+		log.Printf("Error creating notification_preferences table: %v", err)
+		return err
+	}
+
+	// Create notification digests and track which notifications have been emailed
+	_, err = db.Exec(`
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS digest_frequency TEXT NOT NULL DEFAULT 'off'
+		CHECK (digest_frequency IN ('off', 'hourly', 'daily'));
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS last_digest_at TIMESTAMP;
+
+	CREATE TABLE IF NOT EXISTS notification_digests (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		frequency TEXT NOT NULL,
+		notification_count INTEGER NOT NULL,
+		sent_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	ALTER TABLE notifications ADD COLUMN IF NOT EXISTS deliver_in_app BOOLEAN NOT NULL DEFAULT true;
+	ALTER TABLE notifications ADD COLUMN IF NOT EXISTS deliver_email BOOLEAN NOT NULL DEFAULT false;
+	ALTER TABLE notifications ADD COLUMN IF NOT EXISTS emailed_at TIMESTAMP;
+	ALTER TABLE notifications ADD COLUMN IF NOT EXISTS digest_id INTEGER REFERENCES notification_digests(id) ON DELETE SET NULL;
+	CREATE INDEX IF NOT EXISTS idx_notifications_pending_email ON notifications (user_id) WHERE deliver_email AND emailed_at IS NULL;
+	`)
+	if err != nil {
+		log.Printf("Error creating notification_digests table: %v", err)
+		return err
+	}
+
+	// Create the background job queue, used as a transactional outbox for emails and webhooks
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS jobs (
+		id BIGSERIAL PRIMARY KEY,
+		type TEXT NOT NULL,
+		payload JSONB NOT NULL DEFAULT '{}',
+		status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'done', 'dead')),
+		attempts INTEGER NOT NULL DEFAULT 0,
+		max_attempts INTEGER NOT NULL DEFAULT 8,
+		run_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		locked_at TIMESTAMP,
+		last_error TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_jobs_runnable ON jobs (run_at, id) WHERE status IN ('pending', 'running');
+	CREATE INDEX IF NOT EXISTS idx_jobs_done ON jobs (updated_at) WHERE status = 'done';
+	`)
+	if err != nil {
+		log.Printf("Error creating jobs table: %v", err)
+		return err
+	}
+
+	// Create outgoing webhooks and their delivery log
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id SERIAL PRIMARY KEY,
+		project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT[] NOT NULL,
+		active BOOLEAN NOT NULL DEFAULT true,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhooks_project ON webhooks (project_id);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id BIGSERIAL PRIMARY KEY,
+		webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+		event TEXT NOT NULL,
+		payload JSONB NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+		attempts INTEGER NOT NULL DEFAULT 0,
+		response_status INTEGER,
+		response_body TEXT,
+		error TEXT,
+		duration_ms INTEGER,
+		redelivery_of BIGINT REFERENCES webhook_deliveries(id) ON DELETE SET NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		delivered_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries (webhook_id, id DESC);
+	`)
+	if err != nil {
+		log.Printf("Error creating webhooks tables: %v", err)
+		return err
+	}
+
+	// Add the secret token of each project's inbound URL
+	_, err = db.Exec(`
+	ALTER TABLE projects ADD COLUMN IF NOT EXISTS inbound_token TEXT;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_projects_inbound_token ON projects (inbound_token) WHERE inbound_token IS NOT NULL;
+	`)
+	if err != nil {
+		log.Printf("Error adding inbound token column: %v", err)
+		return err
+	}
+
+	// Create task comments and attachments
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS task_comments (
+		id SERIAL PRIMARY KEY,
+		task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+		user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		body TEXT NOT NULL,
+		source TEXT NOT NULL DEFAULT 'api' CHECK (source IN ('api', 'email')),
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_task_comments_task ON task_comments (task_id, id);
+
+	CREATE TABLE IF NOT EXISTS task_attachments (
+		id SERIAL PRIMARY KEY,
+		task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+		comment_id INTEGER REFERENCES task_comments(id) ON DELETE CASCADE,
+		user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		filename TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		content BYTEA NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_task_attachments_task ON task_attachments (task_id, id);
+	`)
+	if err != nil {
+		log.Printf("Error creating comments and attachments tables: %v", err)
+		return err
+	}
+
+	// Add the Message-ID of emails filed as tasks and comments, so redelivered emails are filed once
+	_, err = db.Exec(`
+	ALTER TABLE tasks ADD COLUMN IF NOT EXISTS inbound_message_id TEXT;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_tasks_inbound_message ON tasks (project_id, inbound_message_id) WHERE inbound_message_id IS NOT NULL;
+	ALTER TABLE task_comments ADD COLUMN IF NOT EXISTS inbound_message_id TEXT;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_task_comments_inbound_message ON task_comments (task_id, inbound_message_id) WHERE inbound_message_id IS NOT NULL;
+	`)
+	if err != nil {
+		log.Printf("Error adding inbound message ID columns: %v", err)
+		return err
+	}
+
+	// Add task due dates and the secret token of each user's calendar feed
+	_, err = db.Exec(`
+	ALTER TABLE tasks ADD COLUMN IF NOT EXISTS due_date DATE;
+	CREATE INDEX IF NOT EXISTS idx_tasks_due_date ON tasks (user_id, due_date) WHERE due_date IS NOT NULL AND deleted_at IS NULL;
+
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS calendar_token TEXT;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_users_calendar_token ON users (calendar_token) WHERE calendar_token IS NOT NULL;
+	`)
+	if err != nil {
+		log.Printf("Error adding due dates and calendar tokens: %v", err)
+		return err
+	}
+
+	// Create the task import tables. The uploaded file is kept until the import finishes, and
+	// each processed row is recorded so a retried job resumes where it stopped.
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS task_imports (
+		id SERIAL PRIMARY KEY,
+		project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		source TEXT NOT NULL CHECK (source IN ('csv', 'trello', 'jira')),
+		mapping JSONB NOT NULL DEFAULT '{}',
+		dry_run BOOLEAN NOT NULL DEFAULT false,
+		status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+		total INTEGER NOT NULL DEFAULT 0,
+		processed INTEGER NOT NULL DEFAULT 0,
+		created INTEGER NOT NULL DEFAULT 0,
+		failed INTEGER NOT NULL DEFAULT 0,
+		error TEXT,
+		file BYTEA,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		finished_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_task_imports_project ON task_imports (project_id, id);
+
+	CREATE TABLE IF NOT EXISTS task_import_rows (
+		import_id INTEGER NOT NULL REFERENCES task_imports(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		task_id INTEGER REFERENCES tasks(id) ON DELETE SET NULL,
+		result JSONB NOT NULL,
+		PRIMARY KEY (import_id, position)
+	);
+
+	ALTER TABLE task_comments DROP CONSTRAINT IF EXISTS task_comments_source_check;
+	ALTER TABLE task_comments ADD CONSTRAINT task_comments_source_check CHECK (source IN ('api', 'email', 'import'));
+	`)
+	if err != nil {
+		log.Printf("Error creating task import tables: %v", err)
+		return err
+	}
+
+	// Add scheduled account deletions and the account data export table
+	_, err = db.Exec(`
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_scheduled_for TIMESTAMP;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_queued_at TIMESTAMP;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_policy TEXT CHECK (deletion_policy IN ('transfer', 'delete'));
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_transfer_to INTEGER REFERENCES users(id) ON DELETE SET NULL;
+	CREATE INDEX IF NOT EXISTS idx_users_deletion ON users (deletion_scheduled_for) WHERE deletion_scheduled_for IS NOT NULL;
+
+	-- Comments and files on shared tasks outlive their author's account
+	ALTER TABLE task_comments ALTER COLUMN user_id DROP NOT NULL;
+	ALTER TABLE task_comments DROP CONSTRAINT IF EXISTS task_comments_user_id_fkey;
+	ALTER TABLE task_comments ADD CONSTRAINT task_comments_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+	ALTER TABLE task_attachments ALTER COLUMN user_id DROP NOT NULL;
+	ALTER TABLE task_attachments DROP CONSTRAINT IF EXISTS task_attachments_user_id_fkey;
+	ALTER TABLE task_attachments ADD CONSTRAINT task_attachments_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+
+	CREATE TABLE IF NOT EXISTS account_exports (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'ready')),
+		size INTEGER,
+		file BYTEA,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_account_exports_user ON account_exports (user_id, id);
+	`)
+	if err != nil {
+		log.Printf("Error adding account deletion and export tables: %v", err)
+		return err
+	}
+
+	// Create workspaces and their members; projects belong to a workspace, and guests only see the projects they are members of
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS workspaces (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS workspace_members (
+		workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		role TEXT NOT NULL CHECK (role IN ('admin', 'member', 'guest')),
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (workspace_id, user_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_workspace_members_user ON workspace_members (user_id);
+
+	ALTER TABLE projects ADD COLUMN IF NOT EXISTS workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE;
+	CREATE INDEX IF NOT EXISTS idx_projects_workspace ON projects (workspace_id);
+
+	CREATE TABLE IF NOT EXISTS project_members (
+		project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (project_id, user_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_project_members_user ON project_members (user_id);
+	`)
+	if err != nil {
+		log.Printf("Error creating workspace tables: %v", err)
+		return err
+	}
+
+	// Give every existing user a personal workspace they administer and move their projects into it
+	_, err = db.Exec(`
+	DO $$
+	DECLARE
+		u RECORD;
+		ws INTEGER;
+	BEGIN
+		FOR u IN SELECT id, username FROM users WHERE NOT EXISTS (SELECT 1 FROM workspace_members WHERE user_id = users.id) LOOP
+			INSERT INTO workspaces (name, created_by) VALUES (u.username || '''s workspace', u.id) RETURNING id INTO ws;
+			INSERT INTO workspace_members (workspace_id, user_id, role) VALUES (ws, u.id, 'admin');
+		END LOOP;
+	END $$;
+
+	UPDATE projects SET workspace_id = (
+		SELECT workspace_id FROM workspace_members WHERE user_id = projects.user_id AND role = 'admin' ORDER BY created_at, workspace_id LIMIT 1
+	) WHERE workspace_id IS NULL;
+	`)
+	if err != nil {
+		log.Printf("Error migrating projects into workspaces: %v", err)
+		return err
+	}
+
+	// Create the emailed invites to workspaces and projects; a pending invite is one not yet accepted
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS invites (
+		id SERIAL PRIMARY KEY,
+		workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+		project_id INTEGER REFERENCES projects(id) ON DELETE CASCADE,
+		email VARCHAR(255) NOT NULL,
+		role TEXT NOT NULL CHECK (role IN ('admin', 'member', 'guest')),
+		invited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		sent_count INTEGER NOT NULL DEFAULT 1,
+		expires_at TIMESTAMP NOT NULL,
+		accepted_at TIMESTAMP,
+		accepted_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_invites_pending ON invites (workspace_id, COALESCE(project_id, 0), LOWER(email)) WHERE accepted_at IS NULL;
+	`)
+	if err != nil {
+		log.Printf("Error creating invites table: %v", err)
+		return err
+	}
+
+	// Give project members a project role; members added before roles existed become editors
+	_, err = db.Exec(`
+	ALTER TABLE project_members ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'editor' CHECK (role IN ('editor', 'viewer'));
+	`)
+	if err != nil {
+		log.Printf("Error adding project member roles: %v", err)
+		return err
+	}
+
+	// Add system administrators and disabled accounts, and the audit trail of admins impersonating users
+	_, err = db.Exec(`
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_reason TEXT;
+
+	CREATE TABLE IF NOT EXISTS impersonations (
+		id SERIAL PRIMARY KEY,
+		admin_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		reason TEXT NOT NULL,
+		ip_address TEXT,
+		started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		ended_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_impersonations_started ON impersonations (started_at DESC);
+	`)
+	if err != nil {
+		log.Printf("Error adding admin columns: %v", err)
+		return err
+	}
+
+	// Create the security audit log. It has no foreign keys so entries outlive the users they mention,
+	// and a trigger rejects updates and deletes so it can only be appended to.
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id BIGSERIAL PRIMARY KEY,
+		action TEXT NOT NULL,
+		actor_id INTEGER,
+		impersonator_id INTEGER,
+		target_type TEXT,
+		target_id INTEGER,
+		details JSONB NOT NULL DEFAULT '{}',
+		ip_address TEXT,
+		user_agent TEXT,
+		request_id TEXT,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log (created_at);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log (actor_id, id);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log (action, id);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log (target_type, target_id);
+
+	CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'audit_log is append-only';
+	END;
+	$$ LANGUAGE plpgsql;
+
+	DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+	CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+		FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only();
+	DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+	CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+		FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_append_only();
+	`)
+	if err != nil {
+		log.Printf("Error creating audit_log table: %v", err)
+		return err
+	}
+
+	// Track failed logins per email, whether or not an account has it, for backoff and lockout
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS login_attempts (
+		email TEXT PRIMARY KEY,
+		failures INTEGER NOT NULL DEFAULT 0,
+		last_failed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		locked_until TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_login_attempts_last_failed ON login_attempts (last_failed_at);
+	`)
+	if err != nil {
+		log.Printf("Error creating login_attempts table: %v", err)
+		return err
+	}
+
+	// Remember the due date each task's due-soon notification was sent for, so it is sent once per due date
+	_, err = db.Exec(`
+	ALTER TABLE tasks ADD COLUMN IF NOT EXISTS due_soon_notified_on DATE;
+	`)
+	if err != nil {
+		log.Printf("Error adding due soon column: %v", err)
+		return err
+	}
+
 	log.Println("Database tables initialized successfully")
 	return nil
 }
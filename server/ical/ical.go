@@ -0,0 +1,116 @@
+// Package ical writes RFC 5545 iCalendar data
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const maxLineOctets = 75 // Longest content line before it must be folded, excluding CRLF
+
+// Formats of DATE and UTC DATE-TIME values
+const (
+	DateFormat     = "20060102"
+	DateTimeFormat = "20060102T150405Z"
+)
+
+// Property is one content line, e.g. DTSTART;VALUE=DATE:20240501. Value is written as is,
+// so TEXT values must go through Escape first.
+type Property struct {
+	Name   string
+	Params string // Optional, e.g. "VALUE=DATE"
+	Value  string
+}
+
+// Component is a VEVENT, VTODO or other component
+type Component struct {
+	Name       string
+	Properties []Property
+}
+
+// Add appends a property
+func (c *Component) Add(name, value string) {
+	c.Properties = append(c.Properties, Property{Name: name, Value: value})
+}
+
+// AddText appends a property with an escaped TEXT value
+func (c *Component) AddText(name, value string) {
+	c.Add(name, Escape(value))
+}
+
+// AddDate appends a DATE property
+func (c *Component) AddDate(name string, date time.Time) {
+	c.Properties = append(c.Properties, Property{Name: name, Params: "VALUE=DATE", Value: date.Format(DateFormat)})
+}
+
+// AddDateTime appends a DATE-TIME property in UTC
+func (c *Component) AddDateTime(name string, t time.Time) {
+	c.Add(name, t.UTC().Format(DateTimeFormat))
+}
+
+// Calendar is a VCALENDAR object
+type Calendar struct {
+	Component
+	Components []Component
+}
+
+// NewCalendar returns a calendar with the required VERSION and PRODID
+func NewCalendar(prodID string) *Calendar {
+	cal := &Calendar{Component: Component{Name: "VCALENDAR"}}
+	cal.Add("VERSION", "2.0")
+	cal.Add("PRODID", prodID)
+	cal.Add("CALSCALE", "GREGORIAN")
+	return cal
+}
+
+// Write writes the calendar with CRLF line endings, folding long lines
+func (cal *Calendar) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	writeLine(bw, "BEGIN:"+cal.Name)
+	writeProperties(bw, cal.Properties)
+	for _, component := range cal.Components {
+		writeLine(bw, "BEGIN:"+component.Name)
+		writeProperties(bw, component.Properties)
+		writeLine(bw, "END:"+component.Name)
+	}
+	writeLine(bw, "END:"+cal.Name)
+	return bw.Flush()
+}
+
+func writeProperties(w *bufio.Writer, properties []Property) {
+	for _, property := range properties {
+		line := property.Name
+		if property.Params != "" {
+			line += ";" + property.Params
+		}
+		writeLine(w, line+":"+property.Value)
+	}
+}
+
+// writeLine writes a content line folded into lines of at most 75 octets, never splitting
+// a UTF-8 sequence. Continuation lines start with a space, which counts towards their length.
+func writeLine(w *bufio.Writer, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		limit = maxLineOctets - 1
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// Escape escapes a TEXT value: backslashes, semicolons, commas and line breaks
+func Escape(text string) string {
+	return textEscaper.Replace(text)
+}
@@ -0,0 +1,173 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Column headers of a Jira CSV export. Labels and comments repeat the header once per value.
+const (
+	jiraSummary       = "summary"
+	jiraDescription   = "description"
+	jiraStatus        = "status"
+	jiraLabels        = "labels"
+	jiraDueDate       = "due date"
+	jiraAssignee      = "assignee"
+	jiraAssigneeEmail = "assignee email"
+	jiraComment       = "comment"
+)
+
+// csvTable is a CSV file with a header row
+type csvTable struct {
+	header map[string][]int // Lower-cased header to column indexes, in order
+	rows   [][]string
+	lines  []int
+}
+
+// readCSV reads a CSV file with a header row, removing a UTF-8 byte order mark
+func readCSV(data []byte) (*csvTable, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	reader.FieldsPerRecord = -1
+
+	headers, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+
+	table := &csvTable{header: make(map[string][]int)}
+	for i, name := range headers {
+		name = strings.ToLower(strings.TrimSpace(name))
+		table.header[name] = append(table.header[name], i)
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		line, _ := reader.FieldPos(0)
+		table.rows = append(table.rows, record)
+		table.lines = append(table.lines, line)
+	}
+	return table, nil
+}
+
+// values returns the non-empty cells of every column with the given header
+func (t *csvTable) values(record []string, header string) []string {
+	var values []string
+	for _, i := range t.header[strings.ToLower(header)] {
+		if i < len(record) {
+			if value := strings.TrimSpace(record[i]); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// value returns the first non-empty cell with the given header
+func (t *csvTable) value(record []string, header string) string {
+	if values := t.values(record, header); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// ParseCSV reads a CSV file with a header row. mapping maps fields to column headers; a field
+// without a mapping reads the column named after it. Headers are matched case-insensitively,
+// repeated columns are all read, and labels are separated by commas or semicolons.
+func ParseCSV(data []byte, mapping map[string]string) ([]Row, error) {
+	columns := make(map[string]string, len(Fields))
+	for _, field := range Fields {
+		columns[field] = field
+	}
+	for field, header := range mapping {
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("unknown field %q in mapping", field)
+		}
+		columns[field] = header
+	}
+
+	table, err := readCSV(data)
+	if err != nil {
+		return nil, err
+	}
+	for field, header := range columns {
+		if _, ok := table.header[strings.ToLower(header)]; !ok && (field == FieldTitle || mapping[field] != "") {
+			return nil, fmt.Errorf("column %q not found", header)
+		}
+	}
+
+	rows := make([]Row, 0, len(table.rows))
+	for i, record := range table.rows {
+		row := Row{Line: table.lines[i]}
+		row.Title = table.value(record, columns[FieldTitle])
+		row.Description = strings.Join(table.values(record, columns[FieldDescription]), "\n\n")
+		row.setStatus(table.value(record, columns[FieldStatus]))
+		for _, value := range table.values(record, columns[FieldLabels]) {
+			row.Labels = append(row.Labels, splitLabels(value)...)
+		}
+		row.setDueDate(table.value(record, columns[FieldDueDate]))
+		row.AssigneeEmail = table.value(record, columns[FieldAssigneeEmail])
+		for _, body := range table.values(record, columns[FieldComments]) {
+			row.Comments = append(row.Comments, Comment{Body: body})
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// ParseJira reads a Jira issue CSV export. Assignees are read from an "Assignee Email" column
+// when the export has one, and otherwise from "Assignee", which only matches when it holds an email.
+func ParseJira(data []byte) ([]Row, error) {
+	table, err := readCSV(data)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := table.header[jiraSummary]; !ok {
+		return nil, errors.New(`column "Summary" not found; is this a Jira CSV export?`)
+	}
+
+	assigneeHeader := jiraAssignee
+	if _, ok := table.header[jiraAssigneeEmail]; ok {
+		assigneeHeader = jiraAssigneeEmail
+	}
+
+	rows := make([]Row, 0, len(table.rows))
+	for i, record := range table.rows {
+		row := Row{Line: table.lines[i]}
+		row.Title = table.value(record, jiraSummary)
+		row.Description = table.value(record, jiraDescription)
+		row.setStatus(table.value(record, jiraStatus))
+		row.Labels = table.values(record, jiraLabels)
+		row.setDueDate(table.value(record, jiraDueDate))
+		row.AssigneeEmail = table.value(record, assigneeHeader)
+		for _, value := range table.values(record, jiraComment) {
+			row.Comments = append(row.Comments, parseJiraComment(value))
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseJiraComment reads a Jira comment cell, "<date>;<author account ID>;<body>", falling back
+// to the whole cell as the body
+func parseJiraComment(value string) Comment {
+	parts := strings.SplitN(value, ";", 3)
+	if len(parts) == 3 {
+		if created, ok := parseTime(parts[0]); ok {
+			return Comment{Author: parts[1], Body: parts[2], CreatedAt: &created}
+		}
+	}
+	return Comment{Body: value}
+}
@@ -0,0 +1,164 @@
+// Package importer reads tasks from CSV files and from Trello and Jira exports
+package importer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sources an import can read
+const (
+	SourceCSV    = "csv"
+	SourceTrello = "trello"
+	SourceJira   = "jira"
+)
+
+// Fields a CSV column can be mapped to
+const (
+	FieldTitle         = "title"
+	FieldDescription   = "description"
+	FieldStatus        = "status"
+	FieldLabels        = "labels"
+	FieldDueDate       = "due_date"
+	FieldAssigneeEmail = "assignee_email"
+	FieldComments      = "comments"
+)
+
+// Fields lists every field a CSV column can be mapped to
+var Fields = []string{FieldTitle, FieldDescription, FieldStatus, FieldLabels, FieldDueDate, FieldAssigneeEmail, FieldComments}
+
+// Comment is a comment read with a task
+type Comment struct {
+	Author    string     `json:"author,omitempty"`
+	Body      string     `json:"body"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// Row is one task read from an import. Problems that stop the task being created are listed
+// in Errors; Warnings describe values that were read differently than written.
+type Row struct {
+	Line          int       `json:"line"` // CSV line, or position of the Trello card
+	Title         string    `json:"title"`
+	Description   string    `json:"description"`
+	Status        string    `json:"status"` // pending, in-progress or done
+	Labels        []string  `json:"labels"`
+	DueDate       string    `json:"due_date,omitempty"` // YYYY-MM-DD
+	AssigneeEmail string    `json:"assignee_email,omitempty"`
+	Comments      []Comment `json:"comments,omitempty"`
+	Errors        []string  `json:"errors,omitempty"`
+	Warnings      []string  `json:"warnings,omitempty"`
+}
+
+// Parse reads the rows of an import. mapping is only used by CSV imports.
+func Parse(source string, data []byte, mapping map[string]string) ([]Row, error) {
+	switch source {
+	case SourceCSV:
+		return ParseCSV(data, mapping)
+	case SourceTrello:
+		return ParseTrello(data)
+	case SourceJira:
+		return ParseJira(data)
+	}
+	return nil, fmt.Errorf("unknown import source %q", source)
+}
+
+// statusNames maps lower-cased status and list names used by other tools to task statuses
+var statusNames = map[string]string{
+	"":                         "pending",
+	"pending":                  "pending",
+	"to do":                    "pending",
+	"todo":                     "pending",
+	"to-do":                    "pending",
+	"open":                     "pending",
+	"new":                      "pending",
+	"backlog":                  "pending",
+	"selected for development": "pending",
+	"in-progress":              "in-progress",
+	"in progress":              "in-progress",
+	"in_progress":              "in-progress",
+	"doing":                    "in-progress",
+	"started":                  "in-progress",
+	"active":                   "in-progress",
+	"review":                   "in-progress",
+	"in review":                "in-progress",
+	"done":                     "done",
+	"closed":                   "done",
+	"resolved":                 "done",
+	"complete":                 "done",
+	"completed":                "done",
+	"finished":                 "done",
+}
+
+// NormalizeStatus maps a status or list name to a task status. Unknown names become pending
+// and ok is false.
+func NormalizeStatus(name string) (status string, ok bool) {
+	status, ok = statusNames[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return "pending", false
+	}
+	return status, true
+}
+
+// dateLayouts are the date formats ParseDate accepts, including Jira's export format
+var dateLayouts = []string{
+	"2006-01-02",
+	time.RFC3339,
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"02/Jan/06 3:04 PM",
+	"02/Jan/06",
+	"Jan 2, 2006",
+	"2 Jan 2006",
+}
+
+// ParseDate reads a date in one of the supported formats and returns it as YYYY-MM-DD
+func ParseDate(value string) (string, bool) {
+	if t, ok := parseTime(value); ok {
+		return t.Format("2006-01-02"), true
+	}
+	return "", false
+}
+
+func parseTime(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// splitLabels splits a cell of labels separated by commas or semicolons
+func splitLabels(value string) []string {
+	var labels []string
+	for _, label := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' }) {
+		if label = strings.TrimSpace(label); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// setStatus sets the row's status from a status name, warning about names it doesn't know
+func (row *Row) setStatus(name string) {
+	status, ok := NormalizeStatus(name)
+	row.Status = status
+	if !ok {
+		row.Warnings = append(row.Warnings, fmt.Sprintf("unknown status %q imported as pending", name))
+	}
+}
+
+// setDueDate sets the row's due date, recording an error when the date can't be read
+func (row *Row) setDueDate(value string) {
+	if strings.TrimSpace(value) == "" {
+		return
+	}
+	date, ok := ParseDate(value)
+	if !ok {
+		row.Errors = append(row.Errors, fmt.Sprintf("invalid due date %q", value))
+		return
+	}
+	row.DueDate = date
+}
@@ -0,0 +1,139 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// trelloBoard is the subset of a Trello board JSON export the importer reads
+type trelloBoard struct {
+	Lists   []trelloList   `json:"lists"`
+	Cards   []trelloCard   `json:"cards"`
+	Members []trelloMember `json:"members"`
+	Actions []trelloAction `json:"actions"`
+}
+
+type trelloList struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Closed bool   `json:"closed"`
+}
+
+type trelloCard struct {
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	Desc        string        `json:"desc"`
+	IDList      string        `json:"idList"`
+	Closed      bool          `json:"closed"`
+	Due         *time.Time    `json:"due"`
+	DueComplete bool          `json:"dueComplete"`
+	Labels      []trelloLabel `json:"labels"`
+	IDMembers   []string      `json:"idMembers"`
+}
+
+type trelloLabel struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// trelloMember is a board member. Trello only includes the email in some exports.
+type trelloMember struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	FullName string `json:"fullName"`
+	Email    string `json:"email"`
+}
+
+type trelloAction struct {
+	Type string    `json:"type"`
+	Date time.Time `json:"date"`
+	Data struct {
+		Text string `json:"text"`
+		Card struct {
+			ID string `json:"id"`
+		} `json:"card"`
+	} `json:"data"`
+	MemberCreator struct {
+		FullName string `json:"fullName"`
+		Username string `json:"username"`
+	} `json:"memberCreator"`
+}
+
+// ParseTrello reads a Trello board JSON export. Each open card becomes a task whose status comes
+// from its list's name; cards with a completed due date are done. Archived cards and cards on
+// archived lists are skipped. The first member of a card is its assignee.
+func ParseTrello(data []byte) ([]Row, error) {
+	var board trelloBoard
+	if err := json.Unmarshal(data, &board); err != nil {
+		return nil, fmt.Errorf("invalid Trello export: %v", err)
+	}
+	if board.Lists == nil && board.Cards == nil {
+		return nil, errors.New("no lists or cards found; is this a Trello board export?")
+	}
+
+	lists := make(map[string]trelloList, len(board.Lists))
+	for _, list := range board.Lists {
+		lists[list.ID] = list
+	}
+	members := make(map[string]trelloMember, len(board.Members))
+	for _, member := range board.Members {
+		members[member.ID] = member
+	}
+
+	// Actions are exported newest first; comments are imported oldest first
+	comments := make(map[string][]Comment)
+	for i := len(board.Actions) - 1; i >= 0; i-- {
+		action := board.Actions[i]
+		if action.Type != "commentCard" {
+			continue
+		}
+		author := action.MemberCreator.FullName
+		if author == "" {
+			author = action.MemberCreator.Username
+		}
+		created := action.Date
+		comments[action.Data.Card.ID] = append(comments[action.Data.Card.ID], Comment{Author: author, Body: action.Data.Text, CreatedAt: &created})
+	}
+
+	var rows []Row
+	for i, card := range board.Cards {
+		list := lists[card.IDList]
+		if card.Closed || list.Closed {
+			continue
+		}
+
+		row := Row{Line: i + 1, Title: card.Name, Description: card.Desc, Comments: comments[card.ID]}
+		if card.DueComplete {
+			row.Status = "done"
+		} else {
+			row.setStatus(list.Name)
+		}
+		if card.Due != nil {
+			row.DueDate = card.Due.Format("2006-01-02")
+		}
+		for _, label := range card.Labels {
+			if label.Name != "" {
+				row.Labels = append(row.Labels, label.Name)
+			} else if label.Color != "" {
+				row.Labels = append(row.Labels, label.Color)
+			}
+		}
+
+		for j, memberID := range card.IDMembers {
+			member := members[memberID]
+			if j > 0 {
+				row.Warnings = append(row.Warnings, fmt.Sprintf("member %q not assigned; tasks have one assignee", member.Username))
+				continue
+			}
+			if member.Email == "" {
+				row.Warnings = append(row.Warnings, fmt.Sprintf("member %q has no email in the export and was not assigned", member.Username))
+				continue
+			}
+			row.AssigneeEmail = member.Email
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
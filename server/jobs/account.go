@@ -0,0 +1,109 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// LoginFailureWindow is how long a failed login counts towards a lockout
+const LoginFailureWindow = 24 * time.Hour
+
+// Account job types. Their handlers are registered by main from the controllers package.
+const (
+	TypeAccountExport   = "account_export"
+	TypeAccountDeletion = "account_deletion"
+)
+
+// AccountExportPayload is the payload of a TypeAccountExport job
+type AccountExportPayload struct {
+	ExportID int `json:"export_id"`
+}
+
+// AccountDeletionPayload is the payload of a TypeAccountDeletion job
+type AccountDeletionPayload struct {
+	UserID int `json:"user_id"`
+}
+
+// ScheduleAccountDeletions queues a deletion job for every account whose grace period is over.
+// Accounts are marked as queued in the same transaction, so each is queued once and can no longer be restored.
+func ScheduleAccountDeletions(db *sql.DB) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("UPDATE users SET deletion_queued_at = CURRENT_TIMESTAMP WHERE deletion_scheduled_for <= $1 AND deletion_queued_at IS NULL RETURNING id", time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, userID := range userIDs {
+		if err := Enqueue(tx, TypeAccountDeletion, AccountDeletionPayload{UserID: userID}); err != nil {
+			return 0, err
+		}
+	}
+	return len(userIDs), tx.Commit()
+}
+
+// PurgeAccountExports deletes account exports whose download link has expired
+func PurgeAccountExports(db *sql.DB) (int64, error) {
+	result, err := db.Exec("DELETE FROM account_exports WHERE expires_at < $1", time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// PurgeLoginAttempts forgets failed logins that are no longer locked and too old to count towards a lockout
+func PurgeLoginAttempts(db *sql.DB, window time.Duration) (int64, error) {
+	result, err := db.Exec(
+		"DELETE FROM login_attempts WHERE last_failed_at < $1 AND (locked_until IS NULL OR locked_until < $2)",
+		time.Now().UTC().Add(-window), time.Now().UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// StartAccountScheduler runs ScheduleAccountDeletions, PurgeAccountExports and PurgeLoginAttempts every interval until ctx is cancelled
+func StartAccountScheduler(ctx context.Context, db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if count, err := ScheduleAccountDeletions(db); err != nil {
+			log.Printf("Account deletion scheduling error: %v", err)
+		} else if count > 0 {
+			log.Printf("Queued %d accounts for deletion", count)
+		}
+		if _, err := PurgeAccountExports(db); err != nil {
+			log.Printf("Account export purge error: %v", err)
+		}
+		if _, err := PurgeLoginAttempts(db, LoginFailureWindow); err != nil {
+			log.Printf("Login attempt purge error: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/lib/pq"
+)
+
+// digestDue matches users whose digest period has elapsed since the last one
+const digestDue = `digest_frequency <> 'off' AND (last_digest_at IS NULL OR last_digest_at <= CURRENT_TIMESTAMP -
+	CASE digest_frequency WHEN 'hourly' THEN INTERVAL '1 hour' ELSE INTERVAL '1 day' END)`
+
+// digestUser is a user who is due a digest
+type digestUser struct {
+	ID         int
+	Frequency  string
+	Timezone   string
+	QuietHours *models.QuietHours
+}
+
+// QueueDigests collects every due user's unread, not yet emailed notifications into a digest
+// and queues its email. It returns the number of digests queued.
+func QueueDigests(db *sql.DB) (int, error) {
+	rows, err := db.Query("SELECT id, digest_frequency, timezone, quiet_hours_start, quiet_hours_end FROM users WHERE " + digestDue)
+	if err != nil {
+		return 0, err
+	}
+
+	var users []digestUser
+	for rows.Next() {
+		var user digestUser
+		var start, end sql.NullString
+		if err := rows.Scan(&user.ID, &user.Frequency, &user.Timezone, &start, &end); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if start.Valid && end.Valid {
+			user.QuietHours = &models.QuietHours{Start: start.String, End: end.String}
+		}
+		users = append(users, user)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	now := time.Now()
+	for _, user := range users {
+		// Hold the digest until quiet hours end
+		if user.QuietHours.Contains(now, user.Timezone) {
+			continue
+		}
+		ok, err := queueDigest(db, user)
+		if err != nil {
+			log.Printf("Digest error for user_id %d: %v", user.ID, err)
+			continue
+		}
+		if ok {
+			queued++
+		}
+	}
+	return queued, nil
+}
+
+// queueDigest builds one user's digest. The notifications are claimed and the email job is
+// enqueued in the same transaction, so each notification lands in exactly one digest email.
+func queueDigest(db *sql.DB, user digestUser) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Re-check the schedule under the lock; another run may have just queued it
+	var id int
+	err = tx.QueryRow("SELECT id FROM users WHERE id = $1 AND "+digestDue+" FOR UPDATE SKIP LOCKED", user.ID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Start the next period now, whether or not there is anything to send
+	if _, err := tx.Exec("UPDATE users SET last_digest_at = CURRENT_TIMESTAMP WHERE id = $1", user.ID); err != nil {
+		return false, err
+	}
+
+	rows, err := tx.Query(`
+	SELECT id FROM notifications
+	WHERE user_id = $1 AND deliver_email AND emailed_at IS NULL AND is_read = false
+	ORDER BY id FOR UPDATE`, user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	var ids []int
+	for rows.Next() {
+		var notificationID int
+		if err := rows.Scan(&notificationID); err != nil {
+			rows.Close()
+			return false, err
+		}
+		ids = append(ids, notificationID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	if len(ids) == 0 {
+		return false, tx.Commit()
+	}
+
+	var digestID int
+	err = tx.QueryRow(
+		"INSERT INTO notification_digests (user_id, frequency, notification_count) VALUES ($1, $2, $3) RETURNING id",
+		user.ID, user.Frequency, len(ids),
+	).Scan(&digestID)
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("UPDATE notifications SET emailed_at = CURRENT_TIMESTAMP, digest_id = $1 WHERE id = ANY($2)", digestID, pq.Array(ids)); err != nil {
+		return false, err
+	}
+	if err := Enqueue(tx, TypeDigestEmail, DigestEmailPayload{DigestID: digestID}); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// StartDigestScheduler runs QueueDigests every interval until ctx is cancelled
+func StartDigestScheduler(ctx context.Context, db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		queued, err := QueueDigests(db)
+		if err != nil {
+			log.Printf("Digest error: %v", err)
+		} else if queued > 0 {
+			log.Printf("Queued %d notification digests", queued)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// TypeTaskDueSoon notifies about a task that is due soon. Its handler is registered by main from the controllers package.
+const TypeTaskDueSoon = "task_due_soon"
+
+// DueSoonDays is how many days ahead a due date counts as soon; 1 covers tasks due today and tomorrow
+const DueSoonDays = 1
+
+// TaskDueSoonPayload is the payload of a TypeTaskDueSoon job
+type TaskDueSoonPayload struct {
+	TaskID  int    `json:"task_id"`
+	DueDate string `json:"due_date"`
+}
+
+// ScheduleDueSoon queues a notification job for every open task due within DueSoonDays (UTC) that hasn't had one
+// for its current due date. Tasks are marked in the same transaction, so each is queued once, and again only if
+// its due date moves. Overdue tasks are left alone.
+func ScheduleDueSoon(db *sql.DB) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	today := time.Now().UTC()
+	rows, err := tx.Query(`
+	UPDATE tasks SET due_soon_notified_on = due_date
+	WHERE deleted_at IS NULL AND status <> 'done' AND due_date BETWEEN $1 AND $2
+		AND due_soon_notified_on IS DISTINCT FROM due_date
+	RETURNING id, to_char(due_date, 'YYYY-MM-DD')`,
+		today.Format("2006-01-02"), today.AddDate(0, 0, DueSoonDays).Format("2006-01-02"),
+	)
+	if err != nil {
+		return 0, err
+	}
+	var payloads []TaskDueSoonPayload
+	for rows.Next() {
+		var p TaskDueSoonPayload
+		if err := rows.Scan(&p.TaskID, &p.DueDate); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		payloads = append(payloads, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, p := range payloads {
+		if err := Enqueue(tx, TypeTaskDueSoon, p); err != nil {
+			return 0, err
+		}
+	}
+	return len(payloads), tx.Commit()
+}
+
+// StartDueSoonScheduler runs ScheduleDueSoon every interval until ctx is cancelled
+func StartDueSoonScheduler(ctx context.Context, db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if count, err := ScheduleDueSoon(db); err != nil {
+			log.Printf("Due soon scheduling error: %v", err)
+		} else if count > 0 {
+			log.Printf("Queued due soon notifications for %d tasks", count)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
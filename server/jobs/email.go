@@ -0,0 +1,204 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/models"
+	"github.com/Inengs/realtime-task-app/utils"
+)
+
+// Email job types
+const (
+	TypeVerificationEmail = "verification_email"
+	TypeNotificationEmail = "notification_email"
+	TypeDigestEmail       = "digest_email"
+	TypeInviteEmail       = "invite_email"
+	TypeUnlockEmail       = "unlock_email"
+)
+
+// VerificationEmailPayload is the payload of a TypeVerificationEmail job
+type VerificationEmailPayload struct {
+	Email string `json:"email"`
+	Token string `json:"token"`
+}
+
+// NotificationEmailPayload is the payload of a TypeNotificationEmail job
+type NotificationEmailPayload struct {
+	NotificationID int `json:"notification_id"`
+}
+
+// DigestEmailPayload is the payload of a TypeDigestEmail job
+type DigestEmailPayload struct {
+	DigestID int `json:"digest_id"`
+}
+
+// InviteEmailPayload is the payload of a TypeInviteEmail job
+type InviteEmailPayload struct {
+	InviteID int `json:"invite_id"`
+}
+
+// UnlockEmailPayload is the payload of a TypeUnlockEmail job
+type UnlockEmailPayload struct {
+	UserID int `json:"user_id"`
+}
+
+// SendVerificationEmail handles TypeVerificationEmail jobs
+func SendVerificationEmail(ctx context.Context, db *sql.DB, payload json.RawMessage) error {
+	var p VerificationEmailPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	return utils.SendVerificationEmail(p.Email, p.Token)
+}
+
+// SendNotificationEmail handles TypeNotificationEmail jobs. Notifications that were
+// already emailed are skipped, so a retried job never sends twice after success.
+func SendNotificationEmail(ctx context.Context, db *sql.DB, payload json.RawMessage) error {
+	var p NotificationEmailPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	var userID int
+	var email, message string
+	var entityType *string
+	var entityID *int
+	err := db.QueryRowContext(ctx, `
+	SELECT u.id, u.email, n.message, n.entity_type, n.entity_id
+	FROM notifications n JOIN users u ON u.id = n.user_id
+	WHERE n.id = $1 AND n.emailed_at IS NULL`, p.NotificationID,
+	).Scan(&userID, &email, &message, &entityType, &entityID)
+	if err == sql.ErrNoRows {
+		return nil // Deleted or already sent
+	}
+	if err != nil {
+		return err
+	}
+
+	// Replies to task emails become comments on the task
+	replyTo := ""
+	if entityType != nil && *entityType == models.EntityTask && entityID != nil {
+		replyTo = utils.ReplyAddress(userID, *entityID)
+	}
+
+	if err := utils.SendNotificationEmail(email, message, models.NotificationLink(entityType, entityID), replyTo); err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "UPDATE notifications SET emailed_at = CURRENT_TIMESTAMP WHERE id = $1", p.NotificationID)
+	return err
+}
+
+// SendDigestEmail handles TypeDigestEmail jobs, emailing the notifications collected into a digest
+func SendDigestEmail(ctx context.Context, db *sql.DB, payload json.RawMessage) error {
+	var p DigestEmailPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	var userID int
+	var username, email, frequency string
+	err := db.QueryRowContext(ctx, `
+	SELECT u.id, u.username, u.email, d.frequency
+	FROM notification_digests d JOIN users u ON u.id = d.user_id
+	WHERE d.id = $1`, p.DigestID,
+	).Scan(&userID, &username, &email, &frequency)
+	if err == sql.ErrNoRows {
+		return nil // The user was deleted
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT message, entity_type, entity_id, created_at FROM notifications WHERE digest_id = $1 ORDER BY id", p.DigestID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var items []utils.DigestItem
+	for rows.Next() {
+		var item utils.DigestItem
+		var entityType *string
+		var entityID *int
+		if err := rows.Scan(&item.Message, &entityType, &entityID, &item.CreatedAt); err != nil {
+			return err
+		}
+		if link := models.NotificationLink(entityType, entityID); link != "" {
+			item.Link = utils.FrontendURL() + link
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	return utils.SendDigestEmail(email, username, frequency, items, utils.SignUnsubscribeToken(userID))
+}
+
+// SendInviteEmail handles TypeInviteEmail jobs. The link is signed with the invite's current expiry,
+// so a job queued before a resend still sends a working link. Accepted and revoked invites are skipped.
+func SendInviteEmail(ctx context.Context, db *sql.DB, payload json.RawMessage) error {
+	var p InviteEmailPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	var email, workspace string
+	var project, inviter *string
+	var expiresAt time.Time
+	err := db.QueryRowContext(ctx, `
+	SELECT i.email, w.name, p.name, u.username, i.expires_at
+	FROM invites i JOIN workspaces w ON w.id = i.workspace_id
+	LEFT JOIN projects p ON p.id = i.project_id LEFT JOIN users u ON u.id = i.invited_by
+	WHERE i.id = $1 AND i.accepted_at IS NULL`, p.InviteID,
+	).Scan(&email, &workspace, &project, &inviter, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil // Accepted or revoked
+	}
+	if err != nil {
+		return err
+	}
+
+	target := fmt.Sprintf("the %s workspace", workspace)
+	if project != nil {
+		target = fmt.Sprintf("the %s project in %s", *project, workspace)
+	}
+	from := "Someone"
+	if inviter != nil {
+		from = *inviter
+	}
+	return utils.SendInviteEmail(email, from, target, utils.SignInviteToken(p.InviteID, expiresAt.Unix()), expiresAt)
+}
+
+// SendUnlockEmail handles TypeUnlockEmail jobs. The link is signed with the current lockout,
+// so nothing is sent once the account has been unlocked or the lock has run out.
+func SendUnlockEmail(ctx context.Context, db *sql.DB, payload json.RawMessage) error {
+	var p UnlockEmailPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	var email string
+	var failures int
+	var lockedUntil int64
+	err := db.QueryRowContext(ctx, `
+	SELECT u.email, a.failures, FLOOR(EXTRACT(EPOCH FROM a.locked_until))::BIGINT
+	FROM users u JOIN login_attempts a ON a.email = LOWER(u.email)
+	WHERE u.id = $1 AND a.locked_until > CURRENT_TIMESTAMP`, p.UserID,
+	).Scan(&email, &failures, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil // Unlocked or expired
+	}
+	if err != nil {
+		return err
+	}
+
+	return utils.SendUnlockEmail(email, utils.SignUnlockToken(p.UserID, lockedUntil), failures, time.Unix(lockedUntil, 0))
+}
@@ -0,0 +1,10 @@
+package jobs
+
+// TypeTaskImport runs a task import. Its handler is registered by main from the controllers
+// package, which owns task creation.
+const TypeTaskImport = "task_import"
+
+// TaskImportPayload is the payload of a TypeTaskImport job
+type TaskImportPayload struct {
+	ImportID int `json:"import_id"`
+}
@@ -0,0 +1,222 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	pollInterval   = time.Second      // How often idle workers look for new jobs
+	jobTimeout     = 5 * time.Minute  // How long a single attempt may run
+	staleLock      = 15 * time.Minute // Running jobs older than this are assumed to belong to a crashed worker
+	baseBackoff    = 30 * time.Second
+	maxBackoff     = 6 * time.Hour
+	doneRetention  = 7 * 24 * time.Hour
+	cleanupEvery   = time.Hour
+	defaultWorkers = 4
+)
+
+// MaxAttempts is how many times a job is tried before it is marked dead. main sets it from config.
+var MaxAttempts = 8
+
+// Handler runs one job. Returning an error schedules a retry.
+type Handler func(ctx context.Context, db *sql.DB, payload json.RawMessage) error
+
+var handlers = map[string]Handler{}
+
+// Register sets the handler for a job type. Call it before StartWorkers.
+func Register(jobType string, handler Handler) {
+	handlers[jobType] = handler
+}
+
+// Execer is satisfied by both *sql.DB and *sql.Tx, so jobs can be enqueued
+// in the same transaction as the write that caused them
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Enqueue adds a job that runs as soon as a worker is free. When db is a transaction
+// the job only becomes visible if it commits.
+func Enqueue(db Execer, jobType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO jobs (type, payload, max_attempts) VALUES ($1, $2, $3)", jobType, data, MaxAttempts)
+	return err
+}
+
+// EnqueueAt adds a job that runs once runAt has passed, like Enqueue
+func EnqueueAt(db Execer, jobType string, payload interface{}, runAt time.Time) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO jobs (type, payload, max_attempts, run_at) VALUES ($1, $2, $3, $4)", jobType, data, MaxAttempts, runAt.UTC())
+	return err
+}
+
+// job is a claimed row from the jobs table
+type job struct {
+	ID          int64
+	Type        string
+	Payload     json.RawMessage
+	Attempts    int
+	MaxAttempts int
+}
+
+// claim locks the next runnable job for this worker, or returns sql.ErrNoRows. A stale running
+// job is only reclaimed while it has attempts left, so one that keeps crashing its worker ends up dead.
+func claim(db *sql.DB) (job, error) {
+	var j job
+	err := db.QueryRow(`
+	UPDATE jobs SET status = 'running', attempts = attempts + 1, locked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE id = (
+		SELECT id FROM jobs
+		WHERE (status = 'pending' AND run_at <= CURRENT_TIMESTAMP)
+			OR (status = 'running' AND locked_at < CURRENT_TIMESTAMP - $1 * INTERVAL '1 second' AND attempts < max_attempts)
+		ORDER BY run_at, id
+		FOR UPDATE SKIP LOCKED
+		LIMIT 1
+	)
+	RETURNING id, type, payload, attempts, max_attempts`, int64(staleLock/time.Second),
+	).Scan(&j.ID, &j.Type, &j.Payload, &j.Attempts, &j.MaxAttempts)
+	return j, err
+}
+
+// backoff returns the delay before the next attempt: 30s, 1m, 2m, ... capped at 6h
+func backoff(attempts int) time.Duration {
+	delay := baseBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// run executes a claimed job and records the outcome. Jobs get their own timeout rather than
+// the worker's context, so a shutdown lets the current job finish instead of failing it.
+func run(db *sql.DB, j job) {
+	handler, ok := handlers[j.Type]
+	var err error
+	if !ok {
+		err = fmt.Errorf("no handler registered for job type %q", j.Type)
+		j.Attempts = j.MaxAttempts // Retrying won't help
+	} else {
+		jobCtx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+		err = handler(jobCtx, db, j.Payload)
+		cancel()
+	}
+
+	if err == nil {
+		_, err = db.Exec("UPDATE jobs SET status = 'done', last_error = NULL, locked_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1", j.ID)
+		if err != nil {
+			log.Printf("Error completing job %d: %v", j.ID, err)
+		}
+		return
+	}
+
+	if j.Attempts >= j.MaxAttempts {
+		log.Printf("Job %d (%s) failed permanently after %d attempts: %v", j.ID, j.Type, j.Attempts, err)
+		_, err = db.Exec("UPDATE jobs SET status = 'dead', last_error = $1, locked_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $2", err.Error(), j.ID)
+	} else {
+		delay := backoff(j.Attempts)
+		log.Printf("Job %d (%s) failed, retrying in %s: %v", j.ID, j.Type, delay, err)
+		_, err = db.Exec(
+			"UPDATE jobs SET status = 'pending', last_error = $1, locked_at = NULL, run_at = CURRENT_TIMESTAMP + $2 * INTERVAL '1 second', updated_at = CURRENT_TIMESTAMP WHERE id = $3",
+			err.Error(), int64(delay/time.Second), j.ID,
+		)
+	}
+	if err != nil {
+		log.Printf("Error recording failure of job %d: %v", j.ID, err)
+	}
+}
+
+// work claims and runs jobs until ctx is cancelled, sleeping only when the queue is empty
+func work(ctx context.Context, db *sql.DB) {
+	for ctx.Err() == nil {
+		j, err := claim(db)
+		if err == nil {
+			run(db, j)
+			continue
+		}
+		if err != sql.ErrNoRows {
+			log.Printf("Job claim error: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// BuryStaleJobs marks running jobs dead once their lock is stale and they have no attempts left,
+// since claim no longer picks them up
+func BuryStaleJobs(db *sql.DB) (int64, error) {
+	result, err := db.Exec(`
+	UPDATE jobs SET status = 'dead', last_error = 'worker stopped while running the last attempt', locked_at = NULL, updated_at = CURRENT_TIMESTAMP
+	WHERE status = 'running' AND locked_at < CURRENT_TIMESTAMP - $1 * INTERVAL '1 second' AND attempts >= max_attempts`,
+		int64(staleLock/time.Second),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// PurgeJobs deletes finished jobs older than the retention period. Dead jobs are kept.
+func PurgeJobs(db *sql.DB) (int64, error) {
+	result, err := db.Exec("DELETE FROM jobs WHERE status = 'done' AND updated_at < CURRENT_TIMESTAMP - $1 * INTERVAL '1 second'", int64(doneRetention/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// StartWorkers runs the given number of queue workers, plus hourly cleanup of stale and old jobs and
+// webhook deliveries, and blocks until ctx is cancelled and every worker has finished its current job
+func StartWorkers(ctx context.Context, db *sql.DB, workers int) {
+	if workers < 1 {
+		workers = defaultWorkers
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work(ctx, db)
+		}()
+	}
+
+	ticker := time.NewTicker(cleanupEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+			if buried, err := BuryStaleJobs(db); err != nil {
+				log.Printf("Stale job error: %v", err)
+			} else if buried > 0 {
+				log.Printf("Marked %d stale jobs dead after their last attempt", buried)
+			}
+			if _, err := PurgeJobs(db); err != nil {
+				log.Printf("Job purge error: %v", err)
+			}
+			if _, err := PurgeWebhookDeliveries(db); err != nil {
+				log.Printf("Webhook delivery purge error: %v", err)
+			}
+		}
+	}
+}
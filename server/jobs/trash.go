@@ -0,0 +1,50 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// PurgeTrash permanently deletes tasks and projects that have been in the trash longer than retention
+func PurgeTrash(db *sql.DB, retention time.Duration) (tasks, projects int64, err error) {
+	// Compare in the database so the cutoff uses the same clock that set deleted_at
+	seconds := int64(retention / time.Second)
+
+	result, err := db.Exec("DELETE FROM tasks WHERE deleted_at IS NOT NULL AND deleted_at < CURRENT_TIMESTAMP - $1 * INTERVAL '1 second'", seconds)
+	if err != nil {
+		return 0, 0, err
+	}
+	tasks, _ = result.RowsAffected()
+
+	// Remaining tasks of a purged project go with it through ON DELETE CASCADE
+	result, err = db.Exec("DELETE FROM projects WHERE deleted_at IS NOT NULL AND deleted_at < CURRENT_TIMESTAMP - $1 * INTERVAL '1 second'", seconds)
+	if err != nil {
+		return tasks, 0, err
+	}
+	projects, _ = result.RowsAffected()
+
+	return tasks, projects, nil
+}
+
+// StartTrashPurger runs PurgeTrash every interval until ctx is cancelled
+func StartTrashPurger(ctx context.Context, db *sql.DB, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		tasks, projects, err := PurgeTrash(db, retention)
+		if err != nil {
+			log.Printf("Trash purge error: %v", err)
+		} else if tasks > 0 || projects > 0 {
+			log.Printf("Purged %d tasks and %d projects from the trash", tasks, projects)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/models"
+)
+
+// TypeWebhookDelivery delivers one webhook_deliveries row
+const TypeWebhookDelivery = "webhook_delivery"
+
+const (
+	webhookTimeout      = 10 * time.Second
+	webhookResponseMax  = 2048 // Bytes of the response body kept in the delivery log
+	deliveryRetention   = 30 * 24 * time.Hour
+	webhookUserAgent    = "TaskFlow-Webhooks/1.0"
+	webhookSignatureAlg = "sha256"
+)
+
+// WebhookDeliveryPayload is the payload of a TypeWebhookDelivery job
+type WebhookDeliveryPayload struct {
+	DeliveryID int64 `json:"delivery_id"`
+}
+
+// WebhookAllowedNetworks are internal networks webhooks may be sent to even though they are private.
+// main sets them from config.
+var WebhookAllowedNetworks []*net.IPNet
+
+// sharedAddressSpace is the carrier-grade NAT range, which net.IP.IsPrivate doesn't cover
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// CheckWebhookIP returns an error unless webhooks may be sent to ip. Loopback, link-local, multicast,
+// unspecified and private addresses are refused, so project owners can't reach the server's own
+// network and read the responses from the delivery log, unless the address is in WebhookAllowedNetworks.
+func CheckWebhookIP(ip net.IP) error {
+	for _, network := range WebhookAllowedNetworks {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() ||
+		ip.IsUnspecified() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("webhook address %s is not allowed", ip)
+	}
+	return nil
+}
+
+// CheckWebhookURL checks a webhook URL uses http or https and that its host resolves only to
+// addresses CheckWebhookIP allows. Deliveries check the address again when they connect, since
+// DNS can change in between.
+func CheckWebhookURL(ctx context.Context, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return fmt.Errorf("webhook URL must use http or https")
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, parsed.Hostname())
+	if err != nil {
+		return fmt.Errorf("webhook host %s could not be resolved", parsed.Hostname())
+	}
+	for _, addr := range addrs {
+		if err := CheckWebhookIP(addr.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webhookDialControl refuses connections to addresses CheckWebhookIP doesn't allow. It runs after
+// DNS resolution, for every address tried, so a hostname can't be pointed somewhere internal later.
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("webhook address %s is not an IP address", host)
+	}
+	return CheckWebhookIP(ip)
+}
+
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	// No proxy, so the dialer sees the receiver's real address
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: webhookTimeout, Control: webhookDialControl}).DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	// Report redirects as failures instead of following them somewhere unexpected
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// SignWebhook returns the X-Webhook-Signature value for a body sent at timestamp:
+// the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the webhook's secret
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return webhookSignatureAlg + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeliverWebhook handles TypeWebhookDelivery jobs. Every attempt is recorded on the delivery;
+// a non-2xx response returns an error so the queue retries with backoff.
+func DeliverWebhook(ctx context.Context, db *sql.DB, payload json.RawMessage) error {
+	var p WebhookDeliveryPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	var event, url, secret string
+	var body []byte
+	var active bool
+	err := db.QueryRowContext(ctx, `
+	SELECT d.event, d.payload, w.url, w.secret, w.active
+	FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+	WHERE d.id = $1`, p.DeliveryID,
+	).Scan(&event, &body, &url, &secret, &active)
+	if err == sql.ErrNoRows {
+		return nil // The webhook was deleted
+	}
+	if err != nil {
+		return err
+	}
+	if !active {
+		return recordDelivery(ctx, db, p.DeliveryID, models.DeliveryFailed, nil, nil, "webhook is disabled", 0)
+	}
+
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return recordDelivery(ctx, db, p.DeliveryID, models.DeliveryFailed, nil, nil, err.Error(), 0)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", webhookUserAgent)
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(p.DeliveryID, 10))
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", SignWebhook(secret, timestamp, body))
+
+	start := time.Now()
+	resp, err := webhookClient.Do(req)
+	duration := time.Since(start)
+	if err != nil {
+		if recordErr := recordDelivery(ctx, db, p.DeliveryID, models.DeliveryFailed, nil, nil, err.Error(), duration); recordErr != nil {
+			return recordErr
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseMax))
+	responseText := string(responseBody)
+	status := resp.StatusCode
+
+	if status < 200 || status > 299 {
+		message := fmt.Sprintf("receiver responded with %d", status)
+		if err := recordDelivery(ctx, db, p.DeliveryID, models.DeliveryFailed, &status, &responseText, message, duration); err != nil {
+			return err
+		}
+		return fmt.Errorf("webhook delivery %d: %s", p.DeliveryID, message)
+	}
+	return recordDelivery(ctx, db, p.DeliveryID, models.DeliverySucceeded, &status, &responseText, "", duration)
+}
+
+// recordDelivery stores the outcome of one delivery attempt
+func recordDelivery(ctx context.Context, db *sql.DB, deliveryID int64, status string, responseStatus *int, responseBody *string, message string, duration time.Duration) error {
+	var errorText interface{}
+	if message != "" {
+		errorText = message
+	}
+	_, err := db.ExecContext(ctx, `
+	UPDATE webhook_deliveries SET status = $1, attempts = attempts + 1, response_status = $2, response_body = $3, error = $4,
+		duration_ms = $5, delivered_at = CASE WHEN $1 = 'succeeded' THEN CURRENT_TIMESTAMP ELSE delivered_at END,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = $6`,
+		status, responseStatus, responseBody, errorText, duration.Milliseconds(), deliveryID,
+	)
+	return err
+}
+
+// PurgeWebhookDeliveries deletes delivery log entries older than the retention period
+func PurgeWebhookDeliveries(db *sql.DB) (int64, error) {
+	result, err := db.Exec("DELETE FROM webhook_deliveries WHERE created_at < CURRENT_TIMESTAMP - $1 * INTERVAL '1 second'", int64(deliveryRetention/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,189 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/db"
+	_ "github.com/lib/pq"
+)
+
+// openTestDB connects to the Postgres database in TEST_DATABASE_URL, skipping the test without one
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	database, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := db.InitDB(database); err != nil {
+		t.Fatalf("init database: %v", err)
+	}
+	return database
+}
+
+func TestCheckWebhookIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+	}
+	for _, tc := range tests {
+		if err := CheckWebhookIP(net.ParseIP(tc.ip)); (err == nil) != tc.allowed {
+			t.Errorf("CheckWebhookIP(%s) = %v, want allowed %v", tc.ip, err, tc.allowed)
+		}
+	}
+
+	_, network, _ := net.ParseCIDR("10.1.0.0/16")
+	WebhookAllowedNetworks = []*net.IPNet{network}
+	t.Cleanup(func() { WebhookAllowedNetworks = nil })
+	if err := CheckWebhookIP(net.ParseIP("10.1.2.3")); err != nil {
+		t.Errorf("CheckWebhookIP in an allowed network = %v, want nil", err)
+	}
+	if err := CheckWebhookIP(net.ParseIP("10.2.0.1")); err == nil {
+		t.Error("CheckWebhookIP outside the allowed network = nil, want an error")
+	}
+}
+
+func TestCheckWebhookURL(t *testing.T) {
+	for _, rawURL := range []string{
+		"ftp://example.com/hook",
+		"http:///hook",
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://[::1]/hook",
+	} {
+		if err := CheckWebhookURL(context.Background(), rawURL); err == nil {
+			t.Errorf("CheckWebhookURL(%s) = nil, want an error", rawURL)
+		}
+	}
+}
+
+// TestWebhookClientRefusesInternalAddresses checks the address is checked when connecting, not only when the
+// URL is saved
+func TestWebhookClientRefusesInternalAddresses(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the loopback receiver was contacted")
+	}))
+	defer receiver.Close()
+
+	if resp, err := webhookClient.Get(receiver.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("request to a loopback receiver succeeded")
+	}
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	database := openTestDB(t)
+
+	// The receiver fails its first request, so the delivery has to be retried
+	var requests int32
+	received := make(chan *http.Request, 2)
+	bodies := make(chan []byte, 2)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+		if atomic.AddInt32(&requests, 1) == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer receiver.Close()
+
+	var userID, projectID, webhookID int
+	var deliveryID int64
+	suffix := time.Now().UnixNano()
+	err := database.QueryRow("INSERT INTO users (username, email, password) VALUES ($1, $2, 'x') RETURNING id",
+		fmt.Sprintf("wh%d", suffix%1e12), fmt.Sprintf("webhook-%d@example.com", suffix)).Scan(&userID)
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() { database.Exec("DELETE FROM users WHERE id = $1", userID) })
+	if err := database.QueryRow("INSERT INTO projects (user_id, name) VALUES ($1, 'Webhooks') RETURNING id", userID).Scan(&projectID); err != nil {
+		t.Fatalf("create project: %v", err)
+	}
+	err = database.QueryRow("INSERT INTO webhooks (project_id, user_id, url, secret, events) VALUES ($1, $2, $3, 'shh', '{task.created}') RETURNING id",
+		projectID, userID, receiver.URL).Scan(&webhookID)
+	if err != nil {
+		t.Fatalf("create webhook: %v", err)
+	}
+	err = database.QueryRow(`INSERT INTO webhook_deliveries (webhook_id, event, payload) VALUES ($1, 'task.created', '{"id": 1}') RETURNING id`,
+		webhookID).Scan(&deliveryID)
+	if err != nil {
+		t.Fatalf("create delivery: %v", err)
+	}
+	payload, _ := json.Marshal(WebhookDeliveryPayload{DeliveryID: deliveryID})
+
+	// The receiver is on loopback, so it is refused until its network is allowed
+	if err := DeliverWebhook(context.Background(), database, payload); err == nil {
+		t.Fatal("delivery to a loopback receiver succeeded")
+	}
+	if atomic.LoadInt32(&requests) != 0 {
+		t.Fatal("the loopback receiver was contacted")
+	}
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	WebhookAllowedNetworks = []*net.IPNet{loopback}
+	t.Cleanup(func() { WebhookAllowedNetworks = nil })
+
+	if err := DeliverWebhook(context.Background(), database, payload); err == nil {
+		t.Fatal("delivery answered with 503 returned nil, want an error so it is retried")
+	}
+	if err := DeliverWebhook(context.Background(), database, payload); err != nil {
+		t.Fatalf("retried delivery: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		r, body := <-received, <-bodies
+		timestamp, err := strconv.ParseInt(r.Header.Get("X-Webhook-Timestamp"), 10, 64)
+		if err != nil {
+			t.Fatalf("X-Webhook-Timestamp %q: %v", r.Header.Get("X-Webhook-Timestamp"), err)
+		}
+		if got, want := r.Header.Get("X-Webhook-Signature"), SignWebhook("shh", timestamp, body); got != want {
+			t.Errorf("X-Webhook-Signature = %s, want %s", got, want)
+		}
+		if r.Header.Get("X-Webhook-Event") != "task.created" || r.Header.Get("X-Webhook-Delivery") != strconv.FormatInt(deliveryID, 10) {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+	}
+
+	var status string
+	var attempts, responseStatus int
+	err = database.QueryRow("SELECT status, attempts, response_status FROM webhook_deliveries WHERE id = $1", deliveryID).Scan(&status, &attempts, &responseStatus)
+	if err != nil {
+		t.Fatalf("load delivery: %v", err)
+	}
+	if status != "succeeded" || attempts != 3 || responseStatus != http.StatusOK {
+		t.Errorf("delivery is %s after %d attempts with %d, want succeeded after 3 with 200", status, attempts, responseStatus)
+	}
+}
@@ -0,0 +1,28 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes each message to an .eml file in a directory, for local development
+type FileMailer struct {
+	dir string
+}
+
+// NewFileMailer creates a file driver writing to dir
+func NewFileMailer(dir string) *FileMailer {
+	return &FileMailer{dir: dir}
+}
+
+// Send writes msg to a new .eml file named after the current time
+func (m *FileMailer) Send(msg Message) error {
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s.eml", time.Now().Format("20060102-150405.000000000"))
+	return os.WriteFile(filepath.Join(m.dir, name), data, 0o644)
+}
@@ -0,0 +1,23 @@
+package mailer
+
+import "log"
+
+// LogMailer prints messages to a logger instead of sending them
+type LogMailer struct {
+	logger *log.Logger
+}
+
+// NewLogMailer creates a log driver
+func NewLogMailer(logger *log.Logger) *LogMailer {
+	return &LogMailer{logger: logger}
+}
+
+// Send logs the rendered message
+func (m *LogMailer) Send(msg Message) error {
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	m.logger.Printf("Email to %v: %s\n%s", msg.To, msg.Subject, data)
+	return nil
+}
@@ -0,0 +1,80 @@
+// Package mailer builds MIME email messages and delivers them through a configurable driver.
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// Drivers selectable with MAIL_DRIVER
+const (
+	DriverSMTP = "smtp"
+	DriverFile = "file"
+	DriverLog  = "log"
+)
+
+// Mailer delivers a message
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Config selects and configures a driver
+type Config struct {
+	Driver string
+	From   string // Used when a message has no From
+
+	// SMTP driver
+	Host     string
+	Port     string
+	Username string
+	Password string
+	TLS      string // TLSStartTLS, TLSImplicit or TLSNone
+
+	// File driver
+	Dir string
+}
+
+// Default is the mailer used by the rest of the app. It logs messages until main
+// replaces it with the configured driver.
+var Default Mailer = withFrom{NewLogMailer(log.New(os.Stdout, "", log.LstdFlags)), "TaskFlow <noreply@localhost>"}
+
+// New creates the mailer for cfg.Driver
+func New(cfg Config) (Mailer, error) {
+	var m Mailer
+	switch cfg.Driver {
+	case DriverSMTP, "":
+		if cfg.Host == "" || cfg.Port == "" {
+			return nil, fmt.Errorf("smtp driver requires a host and port")
+		}
+		m = NewSMTPMailer(cfg.Host, cfg.Port, cfg.Username, cfg.Password, cfg.TLS)
+	case DriverFile:
+		if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+			return nil, err
+		}
+		m = NewFileMailer(cfg.Dir)
+	case DriverLog:
+		m = NewLogMailer(log.New(os.Stdout, "", log.LstdFlags))
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+	return withFrom{m, cfg.From}, nil
+}
+
+// Send delivers msg through Default
+func Send(msg Message) error {
+	return Default.Send(msg)
+}
+
+// withFrom fills in the configured sender on messages that don't set one
+type withFrom struct {
+	Mailer
+	from string
+}
+
+func (w withFrom) Send(msg Message) error {
+	if msg.From == "" {
+		msg.From = w.from
+	}
+	return w.Mailer.Send(msg)
+}
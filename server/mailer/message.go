@@ -0,0 +1,147 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message is an email with a plain-text body, an HTML body or both
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string // Extra headers, e.g. List-Unsubscribe
+}
+
+// Recipients returns the bare addresses of msg.To for the SMTP envelope
+func (msg Message) Recipients() ([]string, error) {
+	recipients := make([]string, 0, len(msg.To))
+	for _, to := range msg.To {
+		address, err := mail.ParseAddress(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %w", to, err)
+		}
+		recipients = append(recipients, address.Address)
+	}
+	return recipients, nil
+}
+
+// sender returns the bare address of msg.From for the SMTP envelope
+func (msg Message) sender() (string, error) {
+	address, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return "", fmt.Errorf("invalid sender %q: %w", msg.From, err)
+	}
+	return address.Address, nil
+}
+
+// Bytes renders msg as an RFC 5322 message. When both Text and HTML are set the body is
+// multipart/alternative with the text part first, so clients that can show HTML prefer it.
+func (msg Message) Bytes() ([]byte, error) {
+	if len(msg.To) == 0 {
+		return nil, fmt.Errorf("message has no recipients")
+	}
+	if msg.Text == "" && msg.HTML == "" {
+		return nil, fmt.Errorf("message has no body")
+	}
+	from, err := msg.sender()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	writeHeader := func(key, value string) {
+		// Drop line breaks so header values can't inject extra headers
+		value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+
+	writeHeader("From", msg.From)
+	writeHeader("To", strings.Join(msg.To, ", "))
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	writeHeader("Date", time.Now().Format(time.RFC1123Z))
+	writeHeader("Message-ID", messageID(from))
+	keys := make([]string, 0, len(msg.Headers))
+	for key := range msg.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeHeader(textproto.CanonicalMIMEHeaderKey(key), msg.Headers[key])
+	}
+	writeHeader("MIME-Version", "1.0")
+
+	// Single-part message
+	if msg.Text == "" || msg.HTML == "" {
+		contentType, body := "text/plain; charset=UTF-8", msg.Text
+		if msg.HTML != "" {
+			contentType, body = "text/html; charset=UTF-8", msg.HTML
+		}
+		writeHeader("Content-Type", contentType)
+		writeHeader("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, body); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", msg.Text},
+		{"text/html; charset=UTF-8", msg.HTML},
+	} {
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	writeHeader("Content-Type", "multipart/alternative; boundary="+writer.Boundary())
+	buf.WriteString("\r\n")
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+// writeQuotedPrintable writes content with quoted-printable encoding
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// messageID returns a unique Message-ID in the sender's domain
+func messageID(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+	random := make([]byte, 12)
+	rand.Read(random)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(random), domain)
+}
@@ -0,0 +1,119 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// TLS modes for the SMTP driver
+const (
+	TLSStartTLS = "starttls" // Plain connection upgraded with STARTTLS (usually port 587)
+	TLSImplicit = "tls"      // TLS from the first byte (usually port 465)
+	TLSNone     = "none"     // No encryption, for local test servers only
+)
+
+// smtpTimeout bounds connecting to the server
+const smtpTimeout = 30 * time.Second
+
+// SMTPMailer delivers messages to an SMTP server
+type SMTPMailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	tlsMode  string
+}
+
+// NewSMTPMailer creates an SMTP driver. An empty tlsMode picks implicit TLS on port 465 and STARTTLS otherwise.
+func NewSMTPMailer(host, port, username, password, tlsMode string) *SMTPMailer {
+	if tlsMode == "" {
+		tlsMode = TLSStartTLS
+		if port == "465" {
+			tlsMode = TLSImplicit
+		}
+	}
+	return &SMTPMailer{host: host, port: port, username: username, password: password, tlsMode: tlsMode}
+}
+
+// Send delivers msg over a new SMTP connection
+func (m *SMTPMailer) Send(msg Message) error {
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	from, err := msg.sender()
+	if err != nil {
+		return err
+	}
+	recipients, err := msg.Recipients()
+	if err != nil {
+		return err
+	}
+
+	client, err := m.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if m.tlsMode == TLSStartTLS {
+		if err := client.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if m.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// dial opens the connection, with TLS from the start in implicit mode
+func (m *SMTPMailer) dial() (*smtp.Client, error) {
+	address := net.JoinHostPort(m.host, m.port)
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+
+	var conn net.Conn
+	var err error
+	switch m.tlsMode {
+	case TLSImplicit:
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: m.host})
+	case TLSStartTLS, TLSNone:
+		conn, err = dialer.Dial("tcp", address)
+	default:
+		return nil, fmt.Errorf("unknown smtp tls mode %q", m.tlsMode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,184 @@
+package mailin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+const maxPartDepth = 10 // Deepest nesting of multipart bodies that is read
+
+// Message is a parsed email
+type Message struct {
+	From        string // Sender address without the display name
+	Subject     string
+	MessageID   string
+	Text        string // The first text/plain part, or the first text/html part converted to text
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to a Message
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+var (
+	htmlDropPattern  = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)>`)
+	htmlBreakPattern = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6])>`)
+	htmlTagPattern   = regexp.MustCompile(`<[^>]*>`)
+	blankLines       = regexp.MustCompile(`\n{3,}`)
+	replyHeader      = regexp.MustCompile(`(?m)^(On .+ wrote:|-+ ?Original Message ?-+|From: .+)\s*$`)
+)
+
+var headerDecoder = new(mime.WordDecoder)
+
+// decodeHeader decodes RFC 2047 encoded words, falling back to the raw value
+func decodeHeader(value string) string {
+	decoded, err := headerDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// Parse parses a raw RFC 5322 message, walking multipart bodies for its text and attachments
+func Parse(data []byte) (*Message, error) {
+	raw, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &Message{
+		Subject:   strings.TrimSpace(decodeHeader(raw.Header.Get("Subject"))),
+		MessageID: strings.Trim(raw.Header.Get("Message-Id"), "<> "),
+	}
+	if from, err := mail.ParseAddress(raw.Header.Get("From")); err == nil {
+		msg.From = from.Address
+	}
+
+	var htmlText string
+	if err := msg.readPart(textproto.MIMEHeader(raw.Header), raw.Body, &htmlText, 0); err != nil {
+		return nil, err
+	}
+	if msg.Text == "" && htmlText != "" {
+		msg.Text = htmlToText(htmlText)
+	}
+	msg.Text = strings.TrimSpace(strings.ReplaceAll(msg.Text, "\r\n", "\n"))
+	return msg, nil
+}
+
+// readPart reads one body part, recursing into multipart ones
+func (msg *Message) readPart(header textproto.MIMEHeader, body io.Reader, htmlText *string, depth int) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxPartDepth {
+			return nil
+		}
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := msg.readPart(part.Header, part, htmlText, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := io.ReadAll(decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return err
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	filename = decodeHeader(filename)
+
+	switch {
+	case disposition == "attachment" || filename != "" || !strings.HasPrefix(mediaType, "text/"):
+		if filename == "" {
+			filename = "attachment"
+		}
+		msg.Attachments = append(msg.Attachments, Attachment{Filename: filename, ContentType: mediaType, Data: content})
+	case mediaType == "text/plain" && msg.Text == "":
+		msg.Text = decodeCharset(content, params["charset"])
+	case mediaType == "text/html" && *htmlText == "":
+		*htmlText = decodeCharset(content, params["charset"])
+	}
+	return nil
+}
+
+// decodeTransferEncoding undoes base64 and quoted-printable encoding. Parts read through
+// multipart.Reader already have quoted-printable removed, along with the header.
+func decodeTransferEncoding(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+// decodeCharset converts Latin-1 text to UTF-8. Other charsets are assumed to be UTF-8 compatible.
+func decodeCharset(content []byte, charset string) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1":
+		runes := make([]rune, len(content))
+		for i, b := range content {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	}
+	return strings.ToValidUTF8(string(content), "�")
+}
+
+// htmlToText roughly converts an HTML body to plain text for messages without a text part
+func htmlToText(body string) string {
+	body = htmlDropPattern.ReplaceAllString(body, "")
+	body = htmlBreakPattern.ReplaceAllString(body, "\n")
+	body = htmlTagPattern.ReplaceAllString(body, "")
+	body = html.UnescapeString(body)
+	return blankLines.ReplaceAllString(strings.TrimSpace(body), "\n\n")
+}
+
+// StripReply returns the new text of a reply, dropping the quoted message below it,
+// quoted lines and the signature
+func StripReply(text string) string {
+	if loc := replyHeader.FindStringIndex(text); loc != nil {
+		text = text[:loc[0]]
+	}
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimRight(line, " ") == "--" {
+			break // Signature delimiter
+		}
+		if strings.HasPrefix(line, ">") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,403 @@
+// Package mailin is a small SMTP and LMTP server for receiving mail into the application.
+// It supports neither TLS nor authentication, so run it behind an MTA or on a private network.
+package mailin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported protocols
+const (
+	ProtocolSMTP = "smtp"
+	ProtocolLMTP = "lmtp"
+)
+
+const (
+	commandTimeout       = 5 * time.Minute
+	maxCommandLine       = 2048     // RFC 5321 allows 512 bytes, plus room for extension parameters
+	defaultMaxSize       = 10 << 20 // 10 MB
+	defaultMaxRecipients = 50
+)
+
+// Config configures a Server
+type Config struct {
+	Addr     string // Listen address, e.g. ":2525"; the server is disabled when empty
+	Protocol string // smtp (default) or lmtp
+	Hostname string // Name in the greeting; defaults to the machine's hostname
+	MaxSize  int64  // Largest accepted message in bytes
+}
+
+// Error is an SMTP reply a Backend returns to reject a recipient or message
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Message)
+}
+
+// Backend decides which recipients are accepted and receives their messages
+type Backend interface {
+	// Recipient is called for each RCPT TO; returning an error rejects the address
+	Recipient(addr string) error
+	// Deliver is called once per accepted recipient with the raw message. Clients retry messages that
+	// failed, so it should accept a message it already delivered to rcpt without delivering it twice.
+	Deliver(from, rcpt string, data []byte) error
+}
+
+// Server accepts mail and hands it to its Backend
+type Server struct {
+	Config  Config
+	Backend Backend
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+// NewServer returns a server for cfg, filling in defaults
+func NewServer(cfg Config, backend Backend) (*Server, error) {
+	switch cfg.Protocol {
+	case "":
+		cfg.Protocol = ProtocolSMTP
+	case ProtocolSMTP, ProtocolLMTP:
+	default:
+		return nil, fmt.Errorf("unknown mail listener protocol %q", cfg.Protocol)
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname, _ = os.Hostname()
+		if cfg.Hostname == "" {
+			cfg.Hostname = "localhost"
+		}
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = defaultMaxSize
+	}
+	return &Server{Config: cfg, Backend: backend, conns: make(map[net.Conn]bool)}, nil
+}
+
+// ListenAndServe listens on the configured address and serves until ctx is cancelled
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.Config.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts connections until ctx is cancelled. Sessions in the middle of a delivery finish
+// it; idle sessions are closed. It returns once every session has ended.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.SetReadDeadline(time.Now()) // Unblock sessions waiting for a command
+		}
+		s.mu.Unlock()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(ctx, conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+var errLineTooLong = errors.New("line too long")
+
+// lineLimiter fails reads once more than max bytes arrive without a newline, so a client can't make the
+// session buffer an endless command line. max is 0 while a message body is read, which is limited by size instead.
+type lineLimiter struct {
+	r        io.Reader
+	max      int
+	n        int  // Bytes since the last newline
+	exceeded bool // bufio hands back the partial line rather than the error, so it is also recorded here
+}
+
+func (l *lineLimiter) Read(p []byte) (int, error) {
+	if l.max > 0 {
+		if l.n >= l.max {
+			l.exceeded = true
+			return 0, errLineTooLong
+		}
+		if len(p) > l.max-l.n {
+			p = p[:l.max-l.n]
+		}
+	}
+	n, err := l.r.Read(p)
+	if i := bytes.LastIndexByte(p[:n], '\n'); i >= 0 {
+		l.n = n - i - 1
+	} else {
+		l.n += n
+	}
+	return n, err
+}
+
+// session is the state of one connection
+type session struct {
+	server *Server
+	conn   net.Conn
+	limit  *lineLimiter
+	text   *textproto.Conn
+	helo   bool
+	from   string
+	mail   bool // MAIL FROM was accepted; from may be empty for bounces
+	rcpts  []string
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	limit := &lineLimiter{r: conn, max: maxCommandLine}
+	sess := &session{server: s, conn: conn, limit: limit, text: textproto.NewConn(struct {
+		io.Reader
+		io.WriteCloser
+	}{limit, conn})}
+
+	greeting := "ESMTP"
+	if s.Config.Protocol == ProtocolLMTP {
+		greeting = "LMTP"
+	}
+	sess.reply(220, fmt.Sprintf("%s %s ready", s.Config.Hostname, greeting))
+
+	for ctx.Err() == nil {
+		conn.SetReadDeadline(time.Now().Add(commandTimeout))
+		line, err := sess.text.ReadLine()
+		if sess.limit.exceeded {
+			sess.reply(500, "Line too long")
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		if !sess.handle(strings.ToUpper(verb), strings.TrimSpace(arg)) {
+			return
+		}
+	}
+	sess.reply(421, fmt.Sprintf("%s shutting down", s.Config.Hostname))
+}
+
+// handle runs one command and reports whether the session should continue
+func (sess *session) handle(verb, arg string) bool {
+	lmtp := sess.server.Config.Protocol == ProtocolLMTP
+
+	switch verb {
+	case "HELO", "EHLO", "LHLO":
+		if lmtp != (verb == "LHLO") {
+			sess.reply(500, "Use "+sess.helloVerb())
+			return true
+		}
+		sess.reset()
+		sess.helo = true
+		if verb == "HELO" {
+			sess.reply(250, sess.server.Config.Hostname)
+			return true
+		}
+		sess.reply(250, sess.server.Config.Hostname, "PIPELINING", "8BITMIME", "SIZE "+strconv.FormatInt(sess.server.Config.MaxSize, 10))
+
+	case "MAIL":
+		if !sess.helo {
+			sess.reply(503, "Send "+sess.helloVerb()+" first")
+			return true
+		}
+		if sess.mail {
+			sess.reply(503, "Sender already given")
+			return true
+		}
+		from, params, ok := parsePath(arg, "FROM:")
+		if !ok {
+			sess.reply(501, "Syntax: MAIL FROM:<address>")
+			return true
+		}
+		if size, err := strconv.ParseInt(params["SIZE"], 10, 64); err == nil && size > sess.server.Config.MaxSize {
+			sess.reply(552, "Message too large")
+			return true
+		}
+		sess.from, sess.mail = from, true
+		sess.reply(250, "OK")
+
+	case "RCPT":
+		if !sess.mail {
+			sess.reply(503, "Send MAIL first")
+			return true
+		}
+		rcpt, _, ok := parsePath(arg, "TO:")
+		if !ok || rcpt == "" {
+			sess.reply(501, "Syntax: RCPT TO:<address>")
+			return true
+		}
+		if len(sess.rcpts) >= defaultMaxRecipients {
+			sess.reply(452, "Too many recipients")
+			return true
+		}
+		if err := sess.server.Backend.Recipient(rcpt); err != nil {
+			sess.replyError(err)
+			return true
+		}
+		sess.rcpts = append(sess.rcpts, rcpt)
+		sess.reply(250, "OK")
+
+	case "DATA":
+		if len(sess.rcpts) == 0 {
+			sess.reply(503, "Send RCPT first")
+			return true
+		}
+		sess.data()
+
+	case "RSET":
+		sess.reset()
+		sess.reply(250, "OK")
+
+	case "NOOP":
+		sess.reply(250, "OK")
+
+	case "VRFY":
+		sess.reply(252, "Cannot verify user")
+
+	case "QUIT":
+		sess.reply(221, "Bye")
+		return false
+
+	default:
+		sess.reply(502, "Command not implemented")
+	}
+	return true
+}
+
+// data reads the message and delivers it to every recipient. SMTP sends one reply for the
+// message; LMTP sends one per recipient, in RCPT order.
+func (sess *session) data() {
+	defer sess.reset()
+	sess.reply(354, "End data with <CR><LF>.<CR><LF>")
+
+	// The message body gets its own timeout rather than sharing the DATA command's
+	sess.conn.SetReadDeadline(time.Now().Add(commandTimeout))
+	sess.limit.max = 0
+	defer func() { sess.limit.max = maxCommandLine }()
+	reader := sess.text.DotReader()
+	data, err := io.ReadAll(io.LimitReader(reader, sess.server.Config.MaxSize+1))
+	if err != nil {
+		return
+	}
+	if int64(len(data)) > sess.server.Config.MaxSize {
+		io.Copy(io.Discard, reader)
+		sess.reply(552, "Message too large")
+		return
+	}
+
+	if sess.server.Config.Protocol == ProtocolLMTP {
+		for _, rcpt := range sess.rcpts {
+			if err := sess.server.Backend.Deliver(sess.from, rcpt, data); err != nil {
+				sess.replyError(err)
+				continue
+			}
+			sess.reply(250, "Delivered to "+rcpt)
+		}
+		return
+	}
+
+	// A failure fails the whole message, so the client sends it again to the recipients that
+	// were already delivered; Backend.Deliver must accept those repeats without duplicating them
+	for _, rcpt := range sess.rcpts {
+		if err := sess.server.Backend.Deliver(sess.from, rcpt, data); err != nil {
+			sess.replyError(err)
+			return
+		}
+	}
+	sess.reply(250, "OK")
+}
+
+// helloVerb is the greeting command the protocol expects
+func (sess *session) helloVerb() string {
+	if sess.server.Config.Protocol == ProtocolLMTP {
+		return "LHLO"
+	}
+	return "EHLO"
+}
+
+func (sess *session) reset() {
+	sess.from, sess.mail, sess.rcpts = "", false, nil
+}
+
+// reply writes a single or multiline reply
+func (sess *session) reply(code int, lines ...string) {
+	for i, line := range lines {
+		separator := " "
+		if i < len(lines)-1 {
+			separator = "-"
+		}
+		sess.text.PrintfLine("%d%s%s", code, separator, line)
+	}
+}
+
+// replyError replies with a Backend's *Error, or a temporary failure for anything else
+func (sess *session) replyError(err error) {
+	var mailErr *Error
+	if errors.As(err, &mailErr) {
+		sess.reply(mailErr.Code, mailErr.Message)
+		return
+	}
+	log.Printf("Inbound mail error: %v", err)
+	sess.reply(451, "Temporary failure, try again later")
+}
+
+// parsePath parses "FROM:<address> PARAM=value ..." and returns the address and parameters
+func parsePath(arg, prefix string) (string, map[string]string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", nil, false
+	}
+	end := strings.IndexByte(arg, '>')
+	if end < 0 {
+		return "", nil, false
+	}
+
+	params := make(map[string]string)
+	for _, field := range strings.Fields(arg[end+1:]) {
+		key, value, _ := strings.Cut(field, "=")
+		params[strings.ToUpper(key)] = value
+	}
+	return arg[1:end], params, true
+}
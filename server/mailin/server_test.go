@@ -0,0 +1,107 @@
+package mailin
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testBackend accepts mail for @example.com and records what it delivers
+type testBackend struct {
+	mu        sync.Mutex
+	delivered map[string][]byte
+}
+
+func (b *testBackend) Recipient(addr string) error {
+	if !strings.HasSuffix(addr, "@example.com") {
+		return &Error{Code: 550, Message: "No such mailbox"}
+	}
+	return nil
+}
+
+func (b *testBackend) Deliver(from, rcpt string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.delivered[rcpt] = data
+	return nil
+}
+
+// startServer serves backend on a local port until the test ends and returns its address
+func startServer(t *testing.T, backend Backend) string {
+	t.Helper()
+	server, err := NewServer(Config{Hostname: "mail.test"}, backend)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		server.Serve(ctx, listener)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return listener.Addr().String()
+}
+
+func TestSendMailRoundTrip(t *testing.T) {
+	backend := &testBackend{delivered: map[string][]byte{}}
+	addr := startServer(t, backend)
+
+	message := "From: alice@sender.test\r\nSubject: Hello\r\n\r\nFirst line\r\n.Leading dot\r\n"
+	err := smtp.SendMail(addr, nil, "alice@sender.test", []string{"project-1+token@example.com"}, []byte(message))
+	if err != nil {
+		t.Fatalf("SendMail: %v", err)
+	}
+
+	backend.mu.Lock()
+	got := string(backend.delivered["project-1+token@example.com"])
+	backend.mu.Unlock()
+	// The message comes back with LF line endings and dot-stuffing removed
+	if want := strings.ReplaceAll(message, "\r\n", "\n"); got != want {
+		t.Errorf("delivered %q, want %q", got, want)
+	}
+
+	err = smtp.SendMail(addr, nil, "alice@sender.test", []string{"someone@elsewhere.test"}, []byte(message))
+	if err == nil || !strings.HasPrefix(err.Error(), "550") {
+		t.Errorf("SendMail to a rejected recipient = %v, want a 550 error", err)
+	}
+}
+
+func TestLongCommandLineIsRejected(t *testing.T) {
+	addr := startServer(t, &testBackend{delivered: map[string][]byte{}})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatalf("read greeting: %v", err)
+	}
+
+	// Written in the background, since the server stops reading partway through
+	go conn.Write([]byte("EHLO " + strings.Repeat("a", 10*maxCommandLine) + "\r\n"))
+
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read reply: %v", err)
+	}
+	if !strings.HasPrefix(reply, "500 ") {
+		t.Errorf("reply to a long line = %q, want 500", reply)
+	}
+	if _, err := reader.ReadString('\n'); err == nil {
+		t.Error("connection stayed open after a long line")
+	}
+}
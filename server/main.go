@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata" // Embedded zone data for user timezones
 
 	"github.com/Inengs/realtime-task-app/config"
+	"github.com/Inengs/realtime-task-app/controllers"
 	"github.com/Inengs/realtime-task-app/db"
+	"github.com/Inengs/realtime-task-app/jobs"
+	"github.com/Inengs/realtime-task-app/mailer"
+	"github.com/Inengs/realtime-task-app/mailin"
 	"github.com/Inengs/realtime-task-app/middleware"
 	"github.com/Inengs/realtime-task-app/routes"
+	"github.com/Inengs/realtime-task-app/utils"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/sessions"
@@ -18,11 +29,11 @@ import (
 func validateEnv() {
     required := []string{
         "SESSION_SECRET",
-        "EMAIL_FROM",
-        "EMAIL_USERNAME",
-        "EMAIL_PASSWORD",
-        "EMAIL_SMTP_HOST",
-        "EMAIL_SMTP_PORT",
+    }
+
+    // SMTP settings are only needed when mail is actually sent; the file and log drivers work without them
+    if config.MailConfig().Driver == mailer.DriverSMTP {
+        required = append(required, "EMAIL_FROM", "EMAIL_SMTP_HOST", "EMAIL_SMTP_PORT")
     }
     
     for _, key := range required {
@@ -35,6 +46,13 @@ func validateEnv() {
 func main() {
 	validateEnv()
 
+	// Set up the mail driver
+	mail, err := mailer.New(config.MailConfig())
+	if err != nil {
+		log.Fatalf("Failed to configure mailer: %v", err)
+	}
+	mailer.Default = mail
+
 	// Connect to database
 	database, err := config.ConnectDB()
 	if err != nil {
@@ -47,9 +65,17 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	// Promote the system administrators listed in ADMIN_EMAILS
+	if err := controllers.BootstrapAdmins(database, config.AdminEmails()); err != nil {
+		log.Printf("Failed to bootstrap admins: %v", err)
+	}
+
 	// Set up Gin router
 	router := gin.Default()
 
+	// Tag every request with an ID for logs and the audit log
+	router.Use(middleware.RequestIDMiddleware())
+
 	// Attach database to context
 	router.Use(func(c *gin.Context) {
 		c.Set("db", database)
@@ -65,8 +91,8 @@ func main() {
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:5173", "http://localhost:3000"}, // Add Vite default port
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Cookie"},
-		ExposeHeaders:    []string{"Set-Cookie"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Cookie", "If-Match", "If-None-Match", middleware.WorkspaceHeader, middleware.RequestIDHeader},
+		ExposeHeaders:    []string{"Set-Cookie", "ETag", middleware.RequestIDHeader, "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
 		AllowCredentials: true,
 	}))
 
@@ -76,6 +102,9 @@ func main() {
 		log.Fatalf("Failed to initialize session store")
 	}
 
+	// Rate limit policies are looked up when the routes are registered
+	middleware.RateLimitPolicies = config.RateLimitPolicies()
+
 	// Register routes
 	routes.RegisterAuthRoutes(router)
 	routes.UserAuthRoutes(router)
@@ -83,19 +112,99 @@ func main() {
 	routes.ProjectAuthRoutes(router)
 	routes.WsAuthRoutes(router)
 	routes.NotificationsAuthRoutes(router)
+	routes.SearchAuthRoutes(router)
+	routes.TrashAuthRoutes(router)
+	routes.CalendarAuthRoutes(router)
+	routes.AccountAuthRoutes(router)
+	routes.WorkspaceAuthRoutes(router)
+	routes.InviteAuthRoutes(router)
+	routes.AdminAuthRoutes(router)
 
 	// Set trusted proxies
 	if err := router.SetTrustedProxies([]string{"127.0.0.1"}); err != nil {
 		log.Fatalf("Failed to set trusted proxies: %v", err)
 	}
 
+	// Background jobs stop when the server receives SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go jobs.StartTrashPurger(ctx, database, config.TrashRetention(), time.Hour)
+	go jobs.StartDigestScheduler(ctx, database, 5*time.Minute)
+	go jobs.StartAccountScheduler(ctx, database, 15*time.Minute)
+	go jobs.StartDueSoonScheduler(ctx, database, 15*time.Minute)
+	go middleware.StartRateLimitCleanup(ctx, 10*time.Minute)
+
+	// Start background job workers
+	jobs.MaxAttempts = config.JobMaxAttempts()
+	jobs.WebhookAllowedNetworks = config.WebhookAllowedNetworks()
+	controllers.AccountExportTTL = config.AccountExportTTL()
+	controllers.AccountDeletionGrace = config.AccountDeletionGrace()
+	controllers.InviteTTL = config.InviteTTL()
+	controllers.ImpersonationTTL = config.ImpersonationTTL()
+	controllers.LoginMaxFailures = config.LoginMaxFailures()
+	controllers.LoginLockout = config.LoginLockout()
+	controllers.LoginBackoff = config.LoginBackoff()
+	jobs.Register(jobs.TypeVerificationEmail, jobs.SendVerificationEmail)
+	jobs.Register(jobs.TypeNotificationEmail, jobs.SendNotificationEmail)
+	jobs.Register(jobs.TypeUnlockEmail, jobs.SendUnlockEmail)
+	jobs.Register(jobs.TypeDigestEmail, jobs.SendDigestEmail)
+	jobs.Register(jobs.TypeInviteEmail, jobs.SendInviteEmail)
+	jobs.Register(jobs.TypeWebhookDelivery, jobs.DeliverWebhook)
+	jobs.Register(jobs.TypeTaskDueSoon, controllers.SendDueSoonNotification)
+	jobs.Register(jobs.TypeTaskImport, controllers.RunTaskImport)
+	jobs.Register(jobs.TypeAccountExport, controllers.BuildAccountExport)
+	jobs.Register(jobs.TypeAccountDeletion, controllers.RunAccountDeletion)
+	workersDone := make(chan struct{})
+	go func() {
+		jobs.StartWorkers(ctx, database, config.JobWorkers())
+		close(workersDone)
+	}()
+
+	// Start the inbound mail listener that turns emails into tasks and comments, if configured
+	mailInDone := make(chan struct{})
+	if mailInConfig := config.InboundMailConfig(); mailInConfig.Addr != "" {
+		mailServer, err := mailin.NewServer(mailInConfig, controllers.MailBackend{DB: database, Domain: utils.InboundMailDomain()})
+		if err != nil {
+			log.Fatalf("Failed to configure inbound mail: %v", err)
+		}
+		go func() {
+			defer close(mailInDone)
+			log.Printf("Inbound mail (%s) listening on %s", mailServer.Config.Protocol, mailInConfig.Addr)
+			if err := mailServer.ListenAndServe(ctx); err != nil {
+				log.Fatalf("Inbound mail failed: %v", err)
+			}
+		}()
+	} else {
+		close(mailInDone)
+	}
+
 	// Start server
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
-	log.Printf("Server starting on :%s", port)
-	if err := router.Run(":" + port); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
+	}
+	go func() {
+		log.Printf("Server starting on :%s", port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	// Wait for a shutdown signal, then give in-flight requests time to finish
+	<-ctx.Done()
+	log.Println("Shutting down server...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown error: %v", err)
 	}
+
+	// Let deliveries and workers finish what they are doing before the database closes
+	<-mailInDone
+	<-workersDone
 }
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminMiddleware only lets system administrators through. It must run after AuthMiddleware.
+// Impersonation sessions are refused, so an admin acting as a user can't use admin routes.
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("is_admin") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
+		if _, impersonating := c.Get("impersonator_id"); impersonating {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Stop impersonating to use admin routes"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -1,8 +1,10 @@
 package middleware
 
 import (
+	"database/sql"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/sessions"
@@ -43,8 +45,54 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Check the account still exists and isn't disabled, so disabling takes effect on live sessions
+		db := c.MustGet("db").(*sql.DB)
+		userID, _ := session.Values["user_id"].(int)
+		var disabled, isAdmin bool
+		err = db.QueryRow("SELECT disabled_at IS NOT NULL, is_admin FROM users WHERE id = $1", userID).Scan(&disabled, &isAdmin)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			c.Abort()
+			return
+		}
+		if disabled {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled"})
+			c.Abort()
+			return
+		}
+
+		// An admin impersonating this user; the session ends when it expires or the admin loses their role
+		if impersonatorID, ok := session.Values["impersonator_id"].(int); ok {
+			impersonationID, _ := session.Values["impersonation_id"].(int)
+			expires, _ := session.Values["impersonation_expires"].(int64)
+			var stillAdmin bool
+			err = db.QueryRow("SELECT is_admin AND disabled_at IS NULL FROM users WHERE id = $1", impersonatorID).Scan(&stillAdmin)
+			if err != nil && err != sql.ErrNoRows {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				c.Abort()
+				return
+			}
+			if !stillAdmin || time.Now().Unix() >= expires {
+				db.Exec("UPDATE impersonations SET ended_at = CURRENT_TIMESTAMP WHERE id = $1 AND ended_at IS NULL", impersonationID)
+				session.Values = make(map[interface{}]interface{})
+				session.Options.MaxAge = -1
+				session.Save(c.Request, c.Writer)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Impersonation session ended"})
+				c.Abort()
+				return
+			}
+			c.Set("impersonator_id", impersonatorID)
+			c.Set("impersonation_id", impersonationID)
+		}
+
 		// Set user_id in Gin context for access in downstream handlers
-		c.Set("user_id", session.Values["user_id"])
+		c.Set("user_id", userID)
+		c.Set("is_admin", isAdmin)
 
 		// go to next handler
 		c.Next()
@@ -1,7 +1,13 @@
 package middleware
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
@@ -9,14 +15,42 @@ import (
 	"golang.org/x/time/rate"
 )
 
+// Rate limit policy names
 const (
-	requestsPerMinute = 10
-	cleanupInterval   = 10 * time.Minute
+	RateLimitAuth      = "auth"  // Register, login, unlock and resend verification
+	RateLimitWrite     = "write" // Creates, updates and deletes by signed-in users
+	RateLimitWebSocket = "ws"    // WebSocket upgrades
+	RateLimitToken     = "token" // Links and URLs authenticated by a token instead of a session
 )
 
+// What a policy counts requests by
+const (
+	RateLimitByIP    = "ip"
+	RateLimitByUser  = "user"  // The signed-in user, or the IP when there is none
+	RateLimitByToken = "token" // The IP and the token in the path or query, or the IP when there is none
+)
+
+// RateLimitPolicy allows Requests per Period for each IP, user or IP and token, with bursts of up to Requests
+type RateLimitPolicy struct {
+	Requests int // Zero turns the policy off
+	Period   time.Duration
+	Key      string
+}
+
+// RateLimitPolicies holds the policies by name. Defaults are overridden from config in main before
+// the routes are registered. Token routes are public, so they count by IP: every guessed token would
+// get a fresh bucket of its own otherwise.
+var RateLimitPolicies = map[string]RateLimitPolicy{
+	RateLimitAuth:      {Requests: 10, Period: time.Minute, Key: RateLimitByIP},
+	RateLimitWrite:     {Requests: 120, Period: time.Minute, Key: RateLimitByUser},
+	RateLimitWebSocket: {Requests: 30, Period: time.Minute, Key: RateLimitByUser},
+	RateLimitToken:     {Requests: 60, Period: time.Minute, Key: RateLimitByIP},
+}
+
 type Client struct {
 	Limiter  *rate.Limiter
 	LastSeen time.Time
+	Period   time.Duration
 }
 
 var (
@@ -24,52 +58,123 @@ var (
 	mu      sync.Mutex
 )
 
-// getLimiter retrieves or creates a rate limiter for the IP
-func getLimiter(ip string) *rate.Limiter {
-	mu.Lock()
-	defer mu.Unlock()
+// rateLimitKey returns what the request is counted by under a policy keyed by by
+func rateLimitKey(c *gin.Context, by string) string {
+	switch by {
+	case RateLimitByUser:
+		if userID := c.GetInt("user_id"); userID > 0 {
+			return "user:" + strconv.Itoa(userID)
+		}
+	case RateLimitByToken:
+		token := c.Param("token")
+		if token == "" {
+			token = c.Query("token")
+		}
+		if token == "" {
+			token = c.Query("signature")
+		}
+		if token != "" {
+			// Hashed so the limiter doesn't keep secrets in memory. Keyed by IP too, so one client
+			// can't use up a token shared with others.
+			sum := sha256.Sum256([]byte(token))
+			return "token:" + c.ClientIP() + ":" + hex.EncodeToString(sum[:])
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
 
-	client, exists := clients[ip]
+// allowRequest counts the request against the policy and sets the RateLimit headers. It writes
+// the 429 and returns false once the limit is reached.
+func allowRequest(c *gin.Context, name string, policy RateLimitPolicy) bool {
+	key := name + ":" + rateLimitKey(c, policy.Key)
+	now := time.Now()
+
+	mu.Lock()
+	client, exists := clients[key]
 	if !exists {
-		limiter := rate.NewLimiter(rate.Every(time.Minute/time.Duration(requestsPerMinute)), requestsPerMinute)
-		clients[ip] = &Client{
-			Limiter:  limiter,
-			LastSeen: time.Now(),
+		client = &Client{
+			Limiter: rate.NewLimiter(rate.Every(policy.Period/time.Duration(policy.Requests)), policy.Requests),
+			Period:  policy.Period,
 		}
-		return limiter
+		clients[key] = client
 	}
+	client.LastSeen = now
+	allowed := client.Limiter.AllowN(now, 1)
+	tokens := client.Limiter.TokensAt(now)
+	mu.Unlock()
 
-	client.LastSeen = time.Now()
-	return client.Limiter
+	// A request is allowed back every Period/Requests; Reset is when the full quota is available again
+	interval := policy.Period.Seconds() / float64(policy.Requests)
+	c.Header("RateLimit-Limit", strconv.Itoa(policy.Requests))
+	c.Header("RateLimit-Remaining", strconv.Itoa(int(math.Max(0, math.Floor(tokens)))))
+	c.Header("RateLimit-Reset", strconv.Itoa(int(math.Ceil((float64(policy.Requests)-tokens)*interval))))
+
+	if !allowed {
+		retryAfter := math.Max(1, math.Ceil((1-tokens)*interval))
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter)))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"error": "Too many requests, try again later.",
+		})
+		return false
+	}
+	return true
+}
+
+// rateLimitPolicy looks up a policy by name. An unknown name is a programming error.
+func rateLimitPolicy(name string) RateLimitPolicy {
+	policy, ok := RateLimitPolicies[name]
+	if !ok {
+		log.Fatalf("Unknown rate limit policy %q", name)
+	}
+	return policy
+}
+
+// RateLimitMiddleware limits every request under the named policy. Put it after AuthMiddleware
+// for policies keyed by user.
+func RateLimitMiddleware(name string) gin.HandlerFunc {
+	policy := rateLimitPolicy(name)
+
+	return func(c *gin.Context) {
+		if policy.Requests <= 0 || allowRequest(c, name, policy) {
+			c.Next()
+		}
+	}
 }
 
-// RateLimitMiddleware creates a Gin middleware handler
-func RateLimitMiddleware() gin.HandlerFunc {
-	go cleanupStaleClients()
+// WriteRateLimitMiddleware limits requests under the named policy except GET, HEAD and OPTIONS,
+// so it can be applied to a whole route group
+func WriteRateLimitMiddleware(name string) gin.HandlerFunc {
+	policy := rateLimitPolicy(name)
 
 	return func(c *gin.Context) {
-		ip := c.ClientIP()
-		limiter := getLimiter(ip)
-
-		if !limiter.Allow() {
-			c.Header("Retry-After", "60")
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
-				"error": "Too many registration attempts, try again later.",
-			})
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
 			return
 		}
-		c.Next()
+		if policy.Requests <= 0 || allowRequest(c, name, policy) {
+			c.Next()
+		}
 	}
 }
 
-// cleanupStaleClients periodically removes old IP entries
-func cleanupStaleClients() {
+// StartRateLimitCleanup forgets idle clients every interval until ctx is cancelled. A client idle
+// for its policy's period has a full quota again, so forgetting it changes nothing.
+func StartRateLimitCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
 	for {
-		time.Sleep(cleanupInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
 		mu.Lock()
-		for ip, client := range clients {
-			if time.Since(client.LastSeen) > cleanupInterval {
-				delete(clients, ip)
+		for key, client := range clients {
+			if time.Since(client.LastSeen) > client.Period {
+				delete(clients, key)
 			}
 		}
 		mu.Unlock()
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WorkspaceHeader selects the active workspace when the route has no :workspaceId
+const WorkspaceHeader = "X-Workspace-ID"
+
+// WorkspaceMiddleware resolves the active workspace from the :workspaceId path parameter or the
+// X-Workspace-ID header and checks the user is a member of it. Without either it falls back to the
+// user's oldest workspace, creating a personal one if they have none. It must run after AuthMiddleware
+// and sets workspace_id and workspace_role for downstream handlers.
+func WorkspaceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := c.MustGet("db").(*sql.DB)
+		userID, _ := c.Get("user_id")
+		userIDInt, _ := userID.(int)
+
+		selected := c.Param("workspaceId")
+		if selected == "" {
+			selected = c.GetHeader(WorkspaceHeader)
+		}
+
+		var workspaceID int
+		var role string
+		if selected == "" {
+			var err error
+			workspaceID, role, err = defaultWorkspace(db, userIDInt)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				c.Abort()
+				return
+			}
+		} else {
+			id, err := strconv.Atoi(selected)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workspace ID"})
+				c.Abort()
+				return
+			}
+			err = db.QueryRow("SELECT role FROM workspace_members WHERE workspace_id = $1 AND user_id = $2", id, userIDInt).Scan(&role)
+			if err == sql.ErrNoRows {
+				// Workspaces the user isn't a member of are indistinguishable from missing ones
+				c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Workspace with ID %d not found", id)})
+				c.Abort()
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				c.Abort()
+				return
+			}
+			workspaceID = id
+		}
+
+		c.Set("workspace_id", workspaceID)
+		c.Set("workspace_role", role)
+		c.Next()
+	}
+}
+
+// defaultWorkspace returns the user's oldest workspace and their role in it, creating a personal
+// workspace they administer when they don't belong to any
+func defaultWorkspace(db *sql.DB, userID int) (int, string, error) {
+	var workspaceID int
+	var role string
+	err := db.QueryRow("SELECT workspace_id, role FROM workspace_members WHERE user_id = $1 ORDER BY created_at, workspace_id LIMIT 1", userID).Scan(&workspaceID, &role)
+	if err != sql.ErrNoRows {
+		return workspaceID, role, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	// Lock the user so concurrent first requests create a single workspace
+	var username string
+	if err := tx.QueryRow("SELECT username FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&username); err != nil {
+		return 0, "", err
+	}
+	err = tx.QueryRow("SELECT workspace_id, role FROM workspace_members WHERE user_id = $1 ORDER BY created_at, workspace_id LIMIT 1", userID).Scan(&workspaceID, &role)
+	if err != sql.ErrNoRows {
+		return workspaceID, role, err
+	}
+
+	if err := tx.QueryRow("INSERT INTO workspaces (name, created_by) VALUES ($1, $2) RETURNING id", username+"'s workspace", userID).Scan(&workspaceID); err != nil {
+		return 0, "", err
+	}
+	if _, err := tx.Exec("INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, 'admin')", workspaceID, userID); err != nil {
+		return 0, "", err
+	}
+	return workspaceID, "admin", tx.Commit()
+}
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// Account export statuses
+const (
+	AccountExportPending = "pending"
+	AccountExportReady   = "ready"
+)
+
+// What happens to a deleted account's projects
+const (
+	ProjectPolicyTransfer = "transfer"
+	ProjectPolicyDelete   = "delete"
+)
+
+// AccountExport is a ZIP of everything stored about a user, built by a background job
+type AccountExport struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	Status      string     `json:"status"`
+	Size        *int       `json:"size"`
+	DownloadURL string     `json:"download_url,omitempty"` // Signed link, set while a ready export can be downloaded
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// DeleteAccountInput schedules the deletion of the current user's account
+type DeleteAccountInput struct {
+	Password string `json:"password" validate:"required"`
+	// Projects defaults to transfer: projects in shared workspaces are given to another member
+	Projects   string `json:"projects" validate:"omitempty,oneof=transfer delete"`
+	TransferTo *int   `json:"transfer_to" validate:"omitempty,gt=0"`
+}
+
+// AccountDeletion describes a scheduled account deletion
+type AccountDeletion struct {
+	ScheduledFor time.Time `json:"scheduled_for"`
+	Projects     string    `json:"projects"`
+	TransferTo   *int      `json:"transfer_to"`
+}
@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// AdminUser is a user account as system administrators see it
+type AdminUser struct {
+	ID                   int        `json:"id"`
+	Username             string     `json:"username"`
+	Email                string     `json:"email"`
+	Verified             bool       `json:"verified"`
+	IsAdmin              bool       `json:"is_admin"`
+	DisabledAt           *time.Time `json:"disabled_at"`
+	DisabledReason       *string    `json:"disabled_reason"`
+	DeletionScheduledFor *time.Time `json:"deletion_scheduled_for"`
+	CreatedAt            time.Time  `json:"created_at"`
+}
+
+// DisableUserInput disables an account, optionally saying why
+type DisableUserInput struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+// ImpersonateInput starts a support session as another user. The reason is kept in the audit trail.
+type ImpersonateInput struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+// Impersonation is an audited support session in which an admin acted as another user
+type Impersonation struct {
+	ID            int        `json:"id"`
+	AdminID       *int       `json:"admin_id"`
+	AdminUsername *string    `json:"admin_username"`
+	UserID        *int       `json:"user_id"`
+	Username      *string    `json:"username"`
+	Reason        string     `json:"reason"`
+	IPAddress     *string    `json:"ip_address"`
+	StartedAt     time.Time  `json:"started_at"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	EndedAt       *time.Time `json:"ended_at"`
+}
+
+// AdminStats summarises the instance for system administrators
+type AdminStats struct {
+	Users struct {
+		Total           int `json:"total"`
+		Verified        int `json:"verified"`
+		Admins          int `json:"admins"`
+		Disabled        int `json:"disabled"`
+		PendingDeletion int `json:"pending_deletion"`
+		NewLast7Days    int `json:"new_last_7_days"`
+	} `json:"users"`
+	Workspaces int `json:"workspaces"`
+	Projects   struct {
+		Active  int `json:"active"`
+		Trashed int `json:"trashed"`
+	} `json:"projects"`
+	Tasks struct {
+		Total    int            `json:"total"`
+		Trashed  int            `json:"trashed"`
+		ByStatus map[string]int `json:"by_status"`
+	} `json:"tasks"`
+	Jobs                 map[string]int `json:"jobs"` // Count per job status
+	ActiveConnections    int            `json:"active_connections"`
+	ActiveImpersonations int            `json:"active_impersonations"`
+}
@@ -0,0 +1,64 @@
+package models
+
+import "time"
+
+// Audit log actions, named <area>.<event>
+const (
+	AuditLoginSucceeded           = "auth.login"
+	AuditLoginFailed              = "auth.login_failed"
+	AuditLogout                   = "auth.logout"
+	AuditRegistered               = "auth.register"
+	AuditAccountLocked            = "auth.account_locked"
+	AuditAccountUnlocked          = "auth.account_unlocked"
+	AuditCalendarTokenCreated     = "token.calendar_created"
+	AuditCalendarTokenRevoked     = "token.calendar_revoked"
+	AuditInboundTokenCreated      = "token.inbound_created"
+	AuditInboundTokenRevoked      = "token.inbound_revoked"
+	AuditWebhookCreated           = "token.webhook_created"
+	AuditWebhookDeleted           = "token.webhook_deleted"
+	AuditWorkspaceMemberAdded     = "role.workspace_member_added"
+	AuditWorkspaceRoleChanged     = "role.workspace_role_changed"
+	AuditWorkspaceMemberRemoved   = "role.workspace_member_removed"
+	AuditProjectMemberAdded       = "role.project_member_added"
+	AuditProjectRoleChanged       = "role.project_role_changed"
+	AuditProjectMemberRemoved     = "role.project_member_removed"
+	AuditInviteCreated            = "role.invite_created"
+	AuditInviteResent             = "role.invite_resent"
+	AuditInviteRevoked            = "role.invite_revoked"
+	AuditInviteAccepted           = "role.invite_accepted"
+	AuditProjectDeleted           = "delete.project"
+	AuditTaskDeleted              = "delete.task"
+	AuditAccountDeletionRequested = "delete.account_requested"
+	AuditAccountDeletionCancelled = "delete.account_cancelled"
+	AuditAccountDeleted           = "delete.account"
+	AuditAccountExportRequested   = "account.export_requested"
+	AuditAdminUserDisabled        = "admin.user_disabled"
+	AuditAdminUserEnabled         = "admin.user_enabled"
+	AuditAdminUserVerified        = "admin.user_verified"
+	AuditImpersonationStarted     = "admin.impersonation_started"
+	AuditImpersonationStopped     = "admin.impersonation_stopped"
+	AuditLogExported              = "admin.audit_exported"
+)
+
+// Target types an audit entry can point at, besides EntityTask and EntityProject
+const (
+	AuditTargetUser      = "user"
+	AuditTargetWorkspace = "workspace"
+	AuditTargetWebhook   = "webhook"
+)
+
+// AuditEntry is one row of the append-only security audit log
+type AuditEntry struct {
+	ID             int64                  `json:"id"`
+	Action         string                 `json:"action"`
+	ActorID        *int                   `json:"actor_id"`
+	ActorUsername  *string                `json:"actor_username"`
+	ImpersonatorID *int                   `json:"impersonator_id,omitempty"`
+	TargetType     *string                `json:"target_type"`
+	TargetID       *int                   `json:"target_id"`
+	Details        map[string]interface{} `json:"details"`
+	IPAddress      *string                `json:"ip_address"`
+	UserAgent      *string                `json:"user_agent"`
+	RequestID      *string                `json:"request_id"`
+	CreatedAt      time.Time              `json:"created_at"`
+}
@@ -0,0 +1,32 @@
+package models
+
+// Bulk task operations accepted by POST /tasks/bulk
+const (
+	BulkSetStatus   = "set_status"
+	BulkMove        = "move"
+	BulkAssign      = "assign"
+	BulkAddLabel    = "add_label"
+	BulkRemoveLabel = "remove_label"
+	BulkDelete      = "delete"
+)
+
+// BulkTaskInput applies one operation to many tasks
+type BulkTaskInput struct {
+	TaskIDs   []int  `json:"task_ids" binding:"required" validate:"required,min=1,max=500,dive,gt=0"`
+	Operation string `json:"operation" binding:"required" validate:"required,oneof=set_status move assign add_label remove_label delete"`
+	Status    string `json:"status" validate:"required_if=Operation set_status,omitempty,oneof=pending in-progress done"`
+	ProjectID int    `json:"project_id" validate:"required_if=Operation move,omitempty,gt=0"`
+	// AssigneeID of null or 0 unassigns the tasks
+	AssigneeID *int   `json:"assignee_id" validate:"omitempty,gte=0"`
+	Label      string `json:"label" validate:"required_if=Operation add_label,required_if=Operation remove_label,omitempty,max=50"`
+	// Atomic rolls back every change if any task fails
+	Atomic bool `json:"atomic"`
+}
+
+// BulkItemResult reports the outcome for one task of a bulk operation
+type BulkItemResult struct {
+	TaskID  int    `json:"task_id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	Task    *Task  `json:"task,omitempty"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// Where a comment was written
+const (
+	CommentSourceAPI    = "api"
+	CommentSourceEmail  = "email"
+	CommentSourceImport = "import"
+)
+
+// DeletedUserName stands in for the author of comments and files whose account was deleted
+const DeletedUserName = "deleted user"
+
+// TaskComment is a comment on a task
+type TaskComment struct {
+	ID        int       `json:"id"`
+	TaskID    int       `json:"task_id"`
+	UserID    *int      `json:"user_id"` // nil once the author's account is deleted
+	Body      string    `json:"body"`
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CommentInput adds a comment to a task
+type CommentInput struct {
+	Body string `json:"body" validate:"required,max=10000"`
+}
+
+// TaskAttachment is a file attached to a task, optionally through one of its comments.
+// The content is served separately.
+type TaskAttachment struct {
+	ID          int       `json:"id"`
+	TaskID      int       `json:"task_id"`
+	CommentID   *int      `json:"comment_id"`
+	UserID      *int      `json:"user_id"` // nil once the uploader's account is deleted
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int       `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+}
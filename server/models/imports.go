@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Task import statuses
+const (
+	ImportPending   = "pending"
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
+
+// TaskImport is an uploaded file of tasks being imported into a project by a background job
+type TaskImport struct {
+	ID         int               `json:"id"`
+	ProjectID  int               `json:"project_id"`
+	UserID     int               `json:"user_id"`
+	Source     string            `json:"source"`
+	Mapping    map[string]string `json:"mapping,omitempty"`
+	DryRun     bool              `json:"dry_run"`
+	Status     string            `json:"status"`
+	Total      int               `json:"total"`
+	Processed  int               `json:"processed"`
+	Created    int               `json:"created"`
+	Failed     int               `json:"failed"`
+	Error      *string           `json:"error"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+	FinishedAt *time.Time        `json:"finished_at"`
+}
+
+// ImportRowResult reports what an import did, or in a dry run would do, with one row
+type ImportRowResult struct {
+	Line       int      `json:"line"`
+	Title      string   `json:"title"`
+	Status     string   `json:"status"`
+	Labels     []string `json:"labels"`
+	DueDate    *string  `json:"due_date"`
+	AssigneeID *int     `json:"assignee_id"`
+	Comments   int      `json:"comments"`
+	TaskID     *int     `json:"task_id"` // Set once the task is created
+	Errors     []string `json:"errors,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
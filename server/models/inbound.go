@@ -0,0 +1,25 @@
+package models
+
+// InboundTaskInput is the generic JSON payload posted to a project's inbound URL to file a task
+type InboundTaskInput struct {
+	Title       string `json:"title" validate:"required,max=255"`
+	Description string `json:"description"`
+	// Status defaults to pending
+	Status     string   `json:"status" validate:"omitempty,oneof=pending in-progress done"`
+	AssigneeID *int     `json:"assignee_id" validate:"omitempty,gte=0"`
+	Labels     []string `json:"labels" validate:"omitempty,max=20,dive,min=1,max=50"`
+	DueDate    *string  `json:"due_date" validate:"omitnil,len=0|datetime=2006-01-02"`
+}
+
+// PushPayload is the subset of a GitHub or Gitea push event the inbound URL reads
+type PushPayload struct {
+	Ref     string       `json:"ref"`
+	Commits []PushCommit `json:"commits"`
+}
+
+// PushCommit is one commit of a PushPayload
+type PushCommit struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	URL     string `json:"url"`
+}
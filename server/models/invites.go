@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Invite is an emailed invitation to join a workspace, and optionally one of its projects, with a role
+type Invite struct {
+	ID          int        `json:"id"`
+	WorkspaceID int        `json:"workspace_id"`
+	ProjectID   *int       `json:"project_id"`
+	Email       string     `json:"email"`
+	Role        string     `json:"role"` // Workspace role given to invitees who aren't members yet
+	InvitedBy   *int       `json:"invited_by"`
+	SentCount   int        `json:"sent_count"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	AcceptedAt  *time.Time `json:"accepted_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// InviteInput invites someone by email
+type InviteInput struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+	Role  string `json:"role" validate:"required,oneof=admin member guest"`
+}
+
+// InvitePreview is what the invite link shows before it is accepted
+type InvitePreview struct {
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	Workspace string    `json:"workspace"`
+	Project   *string   `json:"project"`
+	InvitedBy *string   `json:"invited_by"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -1,14 +1,68 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
+
+// NotificationType identifies what a notification is about so clients can render and link it
+type NotificationType string
+
+const (
+	NotificationSystem             NotificationType = "system"
+	NotificationTaskCreated        NotificationType = "task_created"
+	NotificationTaskUpdated        NotificationType = "task_updated"
+	NotificationTaskStatusChanged  NotificationType = "task_status_changed"
+	NotificationTaskDeleted        NotificationType = "task_deleted"
+	NotificationTaskRestored       NotificationType = "task_restored"
+	NotificationTaskAssigned       NotificationType = "task_assigned"
+	NotificationTaskCommented      NotificationType = "task_commented"
+	NotificationTaskMentioned      NotificationType = "task_mentioned"
+	NotificationTaskDueSoon        NotificationType = "task_due_soon"
+	NotificationTasksBulkUpdated   NotificationType = "tasks_bulk_updated"
+	NotificationTasksBulkAssigned  NotificationType = "tasks_bulk_assigned"
+	NotificationProjectCreated     NotificationType = "project_created"
+	NotificationProjectUpdated     NotificationType = "project_updated"
+	NotificationProjectDeleted     NotificationType = "project_deleted"
+	NotificationProjectRestored    NotificationType = "project_restored"
+	NotificationProjectTransferred NotificationType = "project_transferred"
+	NotificationAccountExportReady NotificationType = "account_export_ready"
+	NotificationInviteAccepted     NotificationType = "invite_accepted"
+)
+
+// Entity types a notification can point at
+const (
+	EntityTask    = "task"
+	EntityProject = "project"
+)
+
+// NotificationLink returns the frontend path a notification should open
+func NotificationLink(entityType *string, entityID *int) string {
+	if entityType == nil || entityID == nil {
+		return ""
+	}
+	switch *entityType {
+	case EntityTask:
+		return fmt.Sprintf("/tasks/%d", *entityID)
+	case EntityProject:
+		return fmt.Sprintf("/projects/%d", *entityID)
+	}
+	return ""
+}
 
 type Notifications struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"userID"`
-	Message   string    `json:"message"`
-	IsRead    bool      `json:"isRead"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID         int                    `json:"id"`
+	UserID     int                    `json:"userID"`
+	Type       NotificationType       `json:"type"`
+	ActorID    *int                   `json:"actorID"`
+	EntityType *string                `json:"entityType"`
+	EntityID   *int                   `json:"entityID"`
+	Data       map[string]interface{} `json:"data"`
+	Link       string                 `json:"link,omitempty"` // Frontend path of the entity, e.g. /tasks/12
+	Message    string                 `json:"message"`
+	IsRead     bool                   `json:"isRead"`
+	CreatedAt  time.Time              `json:"createdAt"`
+	UpdatedAt  time.Time              `json:"updatedAt"`
 }
 
 type NotificationInput struct {
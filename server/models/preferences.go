@@ -0,0 +1,167 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// PreferenceEvent groups notification types into the categories users set preferences for
+type PreferenceEvent string
+
+const (
+	EventAssigned       PreferenceEvent = "assigned"
+	EventCommented      PreferenceEvent = "commented"
+	EventMentioned      PreferenceEvent = "mentioned"
+	EventStatusChanged  PreferenceEvent = "status_changed"
+	EventDueSoon        PreferenceEvent = "due_soon"
+	EventProjectChanges PreferenceEvent = "project_changes"
+	EventTaskChanges    PreferenceEvent = "task_changes"
+)
+
+// NotificationChannel says where notifications for an event are delivered
+type NotificationChannel string
+
+const (
+	ChannelInApp NotificationChannel = "in_app"
+	ChannelEmail NotificationChannel = "email"
+	ChannelBoth  NotificationChannel = "both"
+	ChannelNone  NotificationChannel = "none"
+)
+
+// InApp reports whether the channel stores and pushes in-app notifications
+func (c NotificationChannel) InApp() bool {
+	return c == ChannelInApp || c == ChannelBoth
+}
+
+// Email reports whether the channel sends email
+func (c NotificationChannel) Email() bool {
+	return c == ChannelEmail || c == ChannelBoth
+}
+
+// PreferenceEvents lists every event users can configure, with its default channel
+var PreferenceEvents = map[PreferenceEvent]NotificationChannel{
+	EventAssigned:       ChannelBoth,
+	EventCommented:      ChannelInApp,
+	EventMentioned:      ChannelBoth,
+	EventStatusChanged:  ChannelInApp,
+	EventDueSoon:        ChannelBoth,
+	EventProjectChanges: ChannelInApp,
+	EventTaskChanges:    ChannelInApp,
+}
+
+// DigestFrequency says how often notification emails are batched into a digest
+type DigestFrequency string
+
+const (
+	DigestOff    DigestFrequency = "off" // Send each notification email immediately
+	DigestHourly DigestFrequency = "hourly"
+	DigestDaily  DigestFrequency = "daily"
+)
+
+// QuietHours is a daily window, in the user's timezone, during which no email is sent.
+// Start and End use 24-hour "15:04" format; the window wraps past midnight when End is before Start.
+type QuietHours struct {
+	Start string `json:"start" validate:"required,datetime=15:04"`
+	End   string `json:"end" validate:"required,datetime=15:04"`
+}
+
+// minuteOfDay parses a "15:04" time into minutes since midnight
+func minuteOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Contains reports whether now falls inside the quiet hours in the given timezone.
+// A nil QuietHours never contains anything.
+func (q *QuietHours) Contains(now time.Time, timezone string) bool {
+	if q == nil {
+		return false
+	}
+	start, err := minuteOfDay(q.Start)
+	if err != nil {
+		return false
+	}
+	end, err := minuteOfDay(q.End)
+	if err != nil {
+		return false
+	}
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	// The window wraps past midnight, e.g. 22:00-07:00
+	return minute >= start || minute < end
+}
+
+// EndAfter returns when the quiet hours that contain now end: the next End in the given timezone
+func (q *QuietHours) EndAfter(now time.Time, timezone string) time.Time {
+	end, err := minuteOfDay(q.End)
+	if err != nil {
+		return now
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := now.In(location)
+	endsAt := time.Date(local.Year(), local.Month(), local.Day(), end/60, end%60, 0, 0, location)
+	if !endsAt.After(local) {
+		endsAt = endsAt.AddDate(0, 0, 1)
+	}
+	return endsAt
+}
+
+// NotificationPreferences is a user's full preference set
+type NotificationPreferences struct {
+	Timezone   string                                  `json:"timezone"`
+	QuietHours *QuietHours                             `json:"quiet_hours"`
+	Digest     DigestFrequency                         `json:"digest"`
+	Events     map[PreferenceEvent]NotificationChannel `json:"events"`
+}
+
+// NotificationPreferencesInput updates preferences; omitted fields are left unchanged.
+// QuietHours is kept raw so that an explicit null (turn quiet hours off) can be told apart from omission.
+type NotificationPreferencesInput struct {
+	Timezone   *string                                 `json:"timezone" validate:"omitempty,min=1,max=64"`
+	QuietHours json.RawMessage                         `json:"quiet_hours"`
+	Digest     *DigestFrequency                        `json:"digest" validate:"omitempty,oneof=off hourly daily"`
+	Events     map[PreferenceEvent]NotificationChannel `json:"events" validate:"omitempty,dive,oneof=in_app email both none"`
+}
+
+// notificationTypeEvents maps each notification type to the preference that controls it.
+// Types missing here (system notifications) are always delivered in-app.
+var notificationTypeEvents = map[NotificationType]PreferenceEvent{
+	NotificationTaskAssigned:       EventAssigned,
+	NotificationTasksBulkAssigned:  EventAssigned,
+	NotificationTaskCommented:      EventCommented,
+	NotificationTaskMentioned:      EventMentioned,
+	NotificationTaskDueSoon:        EventDueSoon,
+	NotificationTaskStatusChanged:  EventStatusChanged,
+	NotificationTaskCreated:        EventTaskChanges,
+	NotificationTaskUpdated:        EventTaskChanges,
+	NotificationTaskDeleted:        EventTaskChanges,
+	NotificationTaskRestored:       EventTaskChanges,
+	NotificationTasksBulkUpdated:   EventTaskChanges,
+	NotificationProjectCreated:     EventProjectChanges,
+	NotificationProjectUpdated:     EventProjectChanges,
+	NotificationProjectDeleted:     EventProjectChanges,
+	NotificationProjectRestored:    EventProjectChanges,
+	NotificationProjectTransferred: EventProjectChanges,
+	NotificationInviteAccepted:     EventProjectChanges,
+}
+
+// Event returns the preference event that controls delivery of this notification type
+func (t NotificationType) Event() (PreferenceEvent, bool) {
+	event, ok := notificationTypeEvents[t]
+	return event, ok
+}
@@ -4,12 +4,15 @@ import "time"
 
 // Project represents a project entity in the database
 type Project struct {
-	ID          int       `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	UserID      int       `json:"user_id"` // Creator or owner
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	UserID      int        `json:"user_id"` // Creator or owner
+	WorkspaceID int        `json:"workspace_id"`
+	Version     int        `json:"version"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
 }
 
 // ProjectInput is used for creating/updating a project from a request body
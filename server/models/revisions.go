@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Revision actions recorded in task_revisions
+const (
+	RevisionCreated       = "created"
+	RevisionUpdated       = "updated"
+	RevisionStatusChanged = "status_changed"
+	RevisionDeleted       = "deleted"
+	RevisionRestored      = "restored"
+)
+
+// FieldChange holds the before and after value of a single task field
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// TaskRevision is one entry in a task's change history
+type TaskRevision struct {
+	ID            int                    `json:"id"`
+	TaskID        int                    `json:"task_id"`
+	ProjectID     int                    `json:"project_id"`
+	ActorID       *int                   `json:"actor_id"`
+	ActorUsername *string                `json:"actor_username"`
+	Action        string                 `json:"action"`
+	Changes       map[string]FieldChange `json:"changes"`
+	CreatedAt     time.Time              `json:"created_at"`
+}
@@ -0,0 +1,12 @@
+package models
+
+// SearchResult is a single ranked hit returned by GET /search
+type SearchResult struct {
+	Type      string  `json:"type"` // "task" or "project"
+	ID        int     `json:"id"`
+	ProjectID int     `json:"project_id"`
+	Title     string  `json:"title"`
+	Status    string  `json:"status,omitempty"`
+	Snippet   string  `json:"snippet"`
+	Rank      float64 `json:"rank"`
+}
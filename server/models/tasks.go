@@ -3,14 +3,19 @@ package models
 import "time"
 
 type Task struct {
-	ID          int       `json:"id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Status      string    `json:"status"`
-	UserID      int       `json:"user_id"`
-	ProjectID   int       `json:"project_id"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          int        `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Status      string     `json:"status"`
+	UserID      int        `json:"user_id"`
+	ProjectID   int        `json:"project_id"`
+	AssigneeID  *int       `json:"assignee_id"`
+	Labels      []string   `json:"labels"`
+	DueDate     *string    `json:"due_date"` // YYYY-MM-DD
+	Version     int        `json:"version"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
 }
 
 type TaskInput struct {
@@ -18,6 +23,11 @@ type TaskInput struct {
 	Description string `json:"description"`
 	Status      string `json:"status" binding:"required" validate:"required,oneof=pending in-progress done"`
 	ProjectID   int    `json:"project_id" binding:"required" validate:"required,gt=0"`
+	// AssigneeID and Labels are left unchanged on update when omitted; an assignee of 0 unassigns
+	AssigneeID *int     `json:"assignee_id" validate:"omitempty,gte=0"`
+	Labels     []string `json:"labels" validate:"omitempty,max=20,dive,min=1,max=50"`
+	// DueDate is YYYY-MM-DD, left unchanged on update when omitted; "" clears it
+	DueDate *string `json:"due_date" validate:"omitnil,len=0|datetime=2006-01-02"`
 }
 
 type StatusInput struct {
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook event types
+const (
+	WebhookTaskCreated       = "task.created"
+	WebhookTaskUpdated       = "task.updated"
+	WebhookTaskDeleted       = "task.deleted"
+	WebhookTaskStatusChanged = "task.status_changed"
+	WebhookProjectUpdated    = "project.updated"
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a URL that receives a project's events
+type Webhook struct {
+	ID        int       `json:"id"`
+	ProjectID int       `json:"project_id"`
+	UserID    int       `json:"user_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Secret    string    `json:"secret,omitempty"` // Only returned when the webhook is created
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookInput registers a webhook
+type WebhookInput struct {
+	URL    string   `json:"url" validate:"required,url,max=2048"`
+	Events []string `json:"events" validate:"required,min=1,dive,oneof=task.created task.updated task.deleted task.status_changed project.updated"`
+}
+
+// WebhookDelivery is one attempt, with retries, to deliver an event to a webhook
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int             `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus *int            `json:"response_status"`
+	ResponseBody   *string         `json:"response_body"`
+	Error          *string         `json:"error"`
+	DurationMS     *int            `json:"duration_ms"`
+	RedeliveryOf   *int64          `json:"redelivery_of,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+}
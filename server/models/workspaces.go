@@ -0,0 +1,72 @@
+package models
+
+import "time"
+
+// Workspace member roles
+const (
+	RoleAdmin  = "admin"  // Manages the workspace, its members and all of its projects
+	RoleMember = "member" // Sees every project in the workspace and creates new ones
+	RoleGuest  = "guest"  // Only sees the projects they are a member of
+)
+
+// Project roles. Owner is not stored: it is the project's creator, unless they are a workspace guest.
+const (
+	ProjectRoleOwner  = "owner"  // Manages the project and its members
+	ProjectRoleEditor = "editor" // Creates, changes and deletes tasks
+	ProjectRoleViewer = "viewer" // Reads tasks and comments on them
+)
+
+// Workspace groups projects and the users who work on them
+type Workspace struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedBy *int      `json:"created_by"`
+	Role      string    `json:"role,omitempty"` // The current user's role, when listing their workspaces
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WorkspaceInput creates or renames a workspace
+type WorkspaceInput struct {
+	Name string `json:"name" validate:"required,max=255"`
+}
+
+// WorkspaceMember is a user's membership of a workspace
+type WorkspaceMember struct {
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WorkspaceMemberInput adds an existing user to a workspace by email
+type WorkspaceMemberInput struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"required,oneof=admin member guest"`
+}
+
+// WorkspaceRoleInput changes a member's role
+type WorkspaceRoleInput struct {
+	Role string `json:"role" validate:"required,oneof=admin member guest"`
+}
+
+// ProjectMember is a workspace member added to a project with a project role, which is how guests get access to it
+type ProjectMember struct {
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ProjectMemberInput adds a workspace member to a project. Role defaults to editor.
+type ProjectMemberInput struct {
+	UserID int    `json:"user_id" validate:"required,gt=0"`
+	Role   string `json:"role" validate:"omitempty,oneof=editor viewer"`
+}
+
+// ProjectRoleInput changes a project member's role
+type ProjectRoleInput struct {
+	Role string `json:"role" validate:"required,oneof=editor viewer"`
+}
@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"github.com/Inengs/realtime-task-app/controllers"
+	"github.com/Inengs/realtime-task-app/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func AccountAuthRoutes(router *gin.Engine) {
+	account := router.Group("/account", middleware.AuthMiddleware(), middleware.WriteRateLimitMiddleware(middleware.RateLimitWrite))
+	{
+		account.POST("/export", controllers.RequestAccountExport)
+		account.GET("/exports", controllers.ListAccountExports)
+		account.DELETE("", controllers.DeleteAccount)
+		account.POST("/restore", controllers.RestoreAccount)
+	}
+
+	// Export download; authenticated by the signed, expiring link instead of a session
+	router.GET("/account/exports/:id/download", middleware.RateLimitMiddleware(middleware.RateLimitToken), controllers.DownloadAccountExport)
+}
@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"github.com/Inengs/realtime-task-app/controllers"
+	"github.com/Inengs/realtime-task-app/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func AdminAuthRoutes(router *gin.Engine) {
+	// System administration, open only to users with the global is_admin flag
+	admin := router.Group("/admin", middleware.AuthMiddleware(), middleware.AdminMiddleware(), middleware.WriteRateLimitMiddleware(middleware.RateLimitWrite))
+	{
+		admin.GET("/users", controllers.ListAdminUsers)
+		admin.GET("/users/:id", controllers.GetAdminUser)
+		admin.POST("/users/:id/disable", controllers.DisableUser)
+		admin.POST("/users/:id/enable", controllers.EnableUser)
+		admin.POST("/users/:id/verify", controllers.VerifyUser)
+		admin.POST("/users/:id/impersonate", controllers.ImpersonateUser)
+		admin.GET("/impersonations", controllers.ListImpersonations)
+		admin.GET("/stats", controllers.AdminStatsFunc)
+		admin.GET("/audit", controllers.ListAuditLog)
+		admin.GET("/audit/export", controllers.ExportAuditLog)
+	}
+}
@@ -9,10 +9,12 @@ import (
 func RegisterAuthRoutes(router *gin.Engine) {
 	auth := router.Group("/auth")
 
-	auth.POST("/register", middleware.RateLimitMiddleware(), controllers.RegisterFunc)
-	auth.POST("/login", middleware.RateLimitMiddleware(), controllers.LoginFunc)
+	auth.POST("/register", middleware.RateLimitMiddleware(middleware.RateLimitAuth), controllers.RegisterFunc)
+	auth.POST("/login", middleware.RateLimitMiddleware(middleware.RateLimitAuth), controllers.LoginFunc)
 	auth.POST("/logout", middleware.AuthMiddleware(), controllers.LogoutFunc)
 	auth.GET("/me", middleware.AuthMiddleware(), controllers.MeFunc)
-	auth.GET("/verify-email", controllers.VerifyEmail)
-	auth.POST("/resend-verification", middleware.RateLimitMiddleware(), controllers.ResendVerificationEmail)
+	auth.POST("/impersonation/stop", middleware.AuthMiddleware(), controllers.StopImpersonation)
+	auth.GET("/verify-email", middleware.RateLimitMiddleware(middleware.RateLimitToken), controllers.VerifyEmail)
+	auth.POST("/unlock", middleware.RateLimitMiddleware(middleware.RateLimitAuth), controllers.UnlockAccount)
+	auth.POST("/resend-verification", middleware.RateLimitMiddleware(middleware.RateLimitAuth), controllers.ResendVerificationEmail)
 }
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/Inengs/realtime-task-app/controllers"
+	"github.com/Inengs/realtime-task-app/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func CalendarAuthRoutes(router *gin.Engine) {
+	calendar := router.Group("/calendar")
+	{
+		calendar.GET("/feed", middleware.AuthMiddleware(), controllers.GetCalendarFeedURL)
+		calendar.POST("/feed", middleware.AuthMiddleware(), middleware.RateLimitMiddleware(middleware.RateLimitWrite), controllers.RotateCalendarToken)
+		calendar.DELETE("/feed", middleware.AuthMiddleware(), middleware.RateLimitMiddleware(middleware.RateLimitWrite), controllers.DisableCalendarFeed)
+
+		// The feed itself is authenticated by the secret token in its URL, since calendar apps can't log in
+		calendar.GET("/:token", middleware.RateLimitMiddleware(middleware.RateLimitToken), controllers.CalendarFeed)
+	}
+}
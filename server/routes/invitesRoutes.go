@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/Inengs/realtime-task-app/controllers"
+	"github.com/Inengs/realtime-task-app/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func InviteAuthRoutes(router *gin.Engine) {
+	invites := router.Group("/invites")
+	{
+		// The signed token authenticates the preview, so invitees can see it before they log in or register
+		invites.GET("/:token", middleware.RateLimitMiddleware(middleware.RateLimitToken), controllers.GetInvite)
+		invites.POST("/:token/accept", middleware.AuthMiddleware(), middleware.RateLimitMiddleware(middleware.RateLimitWrite), controllers.AcceptInvite)
+	}
+}
@@ -7,9 +7,21 @@ import (
 )
 
 func NotificationsAuthRoutes(router *gin.Engine) {
-	notifications := router.Group("/notifications", middleware.AuthMiddleware())
+	notifications := router.Group("/notifications", middleware.AuthMiddleware(), middleware.WriteRateLimitMiddleware(middleware.RateLimitWrite))
 	{
+		notifications.GET("/me", controllers.GetMyNotifications)
+		notifications.GET("/unread-count", controllers.GetUnreadCount)
+		notifications.PATCH("/me/read", controllers.MarkMyNotificationsRead)
+		notifications.GET("/preferences", controllers.GetNotificationPreferences)
+		notifications.PUT("/preferences", controllers.UpdateNotificationPreferences)
+
+		// Legacy routes; the ID must match the session user
 		notifications.GET("/:id", controllers.GetUserNotifications)
 		notifications.PATCH("/read/:id", controllers.MarkNotificationsRead)
 	}
+
+	// Unsubscribe from email links; authenticated by a signed token instead of a session. GET only
+	// shows a confirmation, so fetching the link doesn't unsubscribe anyone.
+	router.GET("/notifications/unsubscribe", middleware.RateLimitMiddleware(middleware.RateLimitToken), controllers.UnsubscribeConfirmation)
+	router.POST("/notifications/unsubscribe", middleware.RateLimitMiddleware(middleware.RateLimitToken), controllers.UnsubscribeFromEmails)
 }
@@ -1,19 +1,48 @@
 package routes
 
 import (
+	"github.com/Inengs/realtime-task-app/authz"
 	"github.com/Inengs/realtime-task-app/controllers"
 	"github.com/Inengs/realtime-task-app/middleware"
 	"github.com/gin-gonic/gin"
 )
 
 func ProjectAuthRoutes(router *gin.Engine) {
-	projects := router.Group("/projects", middleware.AuthMiddleware())
+	projects := router.Group("/projects", middleware.AuthMiddleware(), middleware.WorkspaceMiddleware(), middleware.WriteRateLimitMiddleware(middleware.RateLimitWrite))
 
 	{
 		projects.GET("/", controllers.ListProjects)
 		projects.GET("/:id", controllers.ProjectDetails)
-		projects.POST("/", controllers.CreateProject)
+		projects.POST("/", authz.Require(authz.ProjectCreate), controllers.CreateProject)
 		projects.PUT("/:id", controllers.UpdateProject)
 		projects.DELETE("/:id", controllers.DeleteProject)
+		projects.GET("/:id/activity", controllers.ProjectActivity)
+
+		projects.GET("/:id/members", controllers.ListProjectMembers)
+		projects.POST("/:id/members", controllers.AddProjectMember)
+		projects.PATCH("/:id/members/:userId", controllers.UpdateProjectMember)
+		projects.DELETE("/:id/members/:userId", controllers.RemoveProjectMember)
+		projects.GET("/:id/invites", controllers.ListProjectInvites)
+		projects.POST("/:id/invites", controllers.CreateProjectInvite)
+		projects.DELETE("/:id/invites/:inviteId", controllers.RevokeProjectInvite)
+		projects.POST("/:id/invites/:inviteId/resend", controllers.ResendProjectInvite)
+
+		projects.POST("/:id/webhooks", controllers.CreateWebhook)
+		projects.GET("/:id/webhooks", controllers.ListWebhooks)
+		projects.DELETE("/:id/webhooks/:webhookId", controllers.DeleteWebhook)
+		projects.GET("/:id/webhooks/:webhookId/deliveries", controllers.ListWebhookDeliveries)
+		projects.POST("/:id/webhooks/:webhookId/deliveries/:deliveryId/redeliver", controllers.RedeliverWebhook)
+
+		projects.GET("/:id/inbound", controllers.GetInboundURL)
+		projects.POST("/:id/inbound", controllers.RotateInboundURL)
+		projects.DELETE("/:id/inbound", controllers.DisableInboundURL)
+
+		projects.GET("/:id/export", controllers.ExportProjectTasks)
+		projects.POST("/:id/import", controllers.CreateTaskImport)
+		projects.GET("/:id/imports", controllers.ListTaskImports)
+		projects.GET("/:id/imports/:importId", controllers.GetTaskImport)
 	}
+
+	// Inbound task URL; authenticated by the secret token in the path instead of a session
+	router.POST("/hooks/inbound/:token", middleware.RateLimitMiddleware(middleware.RateLimitToken), controllers.ReceiveInbound)
 }
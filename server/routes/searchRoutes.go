@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"github.com/Inengs/realtime-task-app/controllers"
+	"github.com/Inengs/realtime-task-app/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func SearchAuthRoutes(router *gin.Engine) {
+	router.GET("/search", middleware.AuthMiddleware(), middleware.WorkspaceMiddleware(), controllers.SearchFunc)
+}
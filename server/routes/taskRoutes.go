@@ -7,13 +7,20 @@ import (
 )
 
 func TaskAuthRoutes(router *gin.Engine) {
-	tasks := router.Group("/tasks", middleware.AuthMiddleware())
+	tasks := router.Group("/tasks", middleware.AuthMiddleware(), middleware.WorkspaceMiddleware(), middleware.WriteRateLimitMiddleware(middleware.RateLimitWrite))
 	{
 		tasks.GET("/", controllers.TaskListFunc)
+		tasks.GET("/export", controllers.ExportAllTasks)
 		tasks.GET("/:id", controllers.TaskDetailsFunc)
 		tasks.POST("/", controllers.CreateNewTask)
+		tasks.POST("/bulk", controllers.BulkUpdateTasks)
 		tasks.PUT("/:id", controllers.UpdateTask)
 		tasks.DELETE("/:id", controllers.DeleteTask)
 		tasks.PATCH("/:id/status", controllers.UpdateTaskStatus)
+		tasks.GET("/:id/history", controllers.TaskHistory)
+		tasks.GET("/:id/comments", controllers.ListTaskComments)
+		tasks.POST("/:id/comments", controllers.CreateTaskComment)
+		tasks.GET("/:id/attachments", controllers.ListTaskAttachments)
+		tasks.GET("/:id/attachments/:attachmentId", controllers.DownloadTaskAttachment)
 	}
 }
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/Inengs/realtime-task-app/controllers"
+	"github.com/Inengs/realtime-task-app/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func TrashAuthRoutes(router *gin.Engine) {
+	trash := router.Group("/trash", middleware.AuthMiddleware(), middleware.WorkspaceMiddleware(), middleware.WriteRateLimitMiddleware(middleware.RateLimitWrite))
+	{
+		trash.GET("/", controllers.ListTrash)
+		trash.POST("/:type/:id/restore", controllers.RestoreFromTrash)
+	}
+}
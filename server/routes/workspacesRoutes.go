@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"github.com/Inengs/realtime-task-app/authz"
+	"github.com/Inengs/realtime-task-app/controllers"
+	"github.com/Inengs/realtime-task-app/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func WorkspaceAuthRoutes(router *gin.Engine) {
+	workspaces := router.Group("/workspaces", middleware.AuthMiddleware(), middleware.WriteRateLimitMiddleware(middleware.RateLimitWrite))
+	{
+		workspaces.GET("", controllers.ListWorkspaces)
+		workspaces.POST("", controllers.CreateWorkspace)
+	}
+
+	// The workspace in the path is the active one; WorkspaceMiddleware checks the caller belongs to it
+	// and authz checks their role allows the action. Members can remove themselves, so that is checked in the handler.
+	workspace := router.Group("/workspaces/:workspaceId", middleware.AuthMiddleware(), middleware.WorkspaceMiddleware(), middleware.WriteRateLimitMiddleware(middleware.RateLimitWrite))
+	{
+		workspace.PUT("", authz.Require(authz.WorkspaceUpdate), controllers.UpdateWorkspace)
+		workspace.GET("/members", authz.Require(authz.MemberView), controllers.ListWorkspaceMembers)
+		workspace.POST("/members", authz.Require(authz.MemberInvite), controllers.AddWorkspaceMember)
+		workspace.PATCH("/members/:userId", authz.Require(authz.MemberManage), controllers.UpdateWorkspaceMember)
+		workspace.DELETE("/members/:userId", controllers.RemoveWorkspaceMember)
+		workspace.GET("/invites", authz.Require(authz.MemberInvite), controllers.ListWorkspaceInvites)
+		workspace.POST("/invites", authz.Require(authz.MemberInvite), controllers.CreateWorkspaceInvite)
+		workspace.DELETE("/invites/:inviteId", authz.Require(authz.MemberInvite), controllers.RevokeWorkspaceInvite)
+		workspace.POST("/invites/:inviteId/resend", authz.Require(authz.MemberInvite), controllers.ResendWorkspaceInvite)
+	}
+}
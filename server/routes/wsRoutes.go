@@ -9,8 +9,8 @@ import (
 func WsAuthRoutes(router *gin.Engine) {
 	ws := router.Group("/ws")
 	{
-		ws.GET("/notifications", middleware.AuthMiddleware(), controllers.WebSocketHandler)
-		ws.GET("/tasks", middleware.AuthMiddleware(), controllers.WebSocketTaskHandler)
-		ws.GET("/projects", middleware.AuthMiddleware(), controllers.WebSocketProjectHandler)
+		ws.GET("/notifications", middleware.AuthMiddleware(), middleware.RateLimitMiddleware(middleware.RateLimitWebSocket), controllers.WebSocketHandler)
+		ws.GET("/tasks", middleware.AuthMiddleware(), middleware.RateLimitMiddleware(middleware.RateLimitWebSocket), controllers.WebSocketTaskHandler)
+		ws.GET("/projects", middleware.AuthMiddleware(), middleware.RateLimitMiddleware(middleware.RateLimitWebSocket), controllers.WebSocketProjectHandler)
 	}
 }
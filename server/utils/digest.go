@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"text/template"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/mailer"
+)
+
+// DigestItem is one notification listed in a digest email
+type DigestItem struct {
+	Message   string
+	Link      string // Absolute URL, empty if the notification has no entity
+	CreatedAt time.Time
+}
+
+// digestData is what the digest templates are rendered with
+type digestData struct {
+	Username       string
+	Frequency      string
+	Items          []DigestItem
+	AppURL         string
+	UnsubscribeURL string
+}
+
+var digestHTMLTemplate = htmltemplate.Must(htmltemplate.New("digest").Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+    <style>
+        body { font-family: Arial, sans-serif; background-color: #f4f4f4; padding: 20px; }
+        .container { max-width: 600px; margin: 0 auto; background: white; padding: 30px; border-radius: 10px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); }
+        .header { text-align: center; color: #4CAF50; font-size: 24px; font-weight: bold; margin-bottom: 20px; }
+        .content { color: #333; line-height: 1.6; }
+        .item { padding: 10px 0; border-bottom: 1px solid #eee; }
+        .item a { color: #4CAF50; }
+        .time { color: #999; font-size: 12px; }
+        .button { display: inline-block; padding: 12px 30px; background-color: #4CAF50; color: white; text-decoration: none; border-radius: 5px; margin: 20px 0; }
+        .footer { text-align: center; color: #999; font-size: 12px; margin-top: 20px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">TaskFlow - Your {{.Frequency}} digest</div>
+        <div class="content">
+            <p>Hello {{.Username}},</p>
+            <p>You have {{len .Items}} unread notification{{if ne (len .Items) 1}}s{{end}}:</p>
+            {{range .Items}}
+            <div class="item">
+                {{if .Link}}<a href="{{.Link}}">{{.Message}}</a>{{else}}{{.Message}}{{end}}
+                <div class="time">{{.CreatedAt.Format "Jan 2, 15:04 MST"}}</div>
+            </div>
+            {{end}}
+            <p style="text-align: center;">
+                <a href="{{.AppURL}}" class="button">Open TaskFlow</a>
+            </p>
+        </div>
+        <div class="footer">
+            <p>Don't want these emails? <a href="{{.UnsubscribeURL}}">Unsubscribe</a></p>
+            © 2025 TaskFlow. All rights reserved.
+        </div>
+    </div>
+</body>
+</html>
+`))
+
+var digestTextTemplate = template.Must(template.New("digest").Parse(`Hello {{.Username}},
+
+You have {{len .Items}} unread notification{{if ne (len .Items) 1}}s{{end}}:
+{{range .Items}}
+- {{.Message}} ({{.CreatedAt.Format "Jan 2, 15:04 MST"}}){{if .Link}}
+  {{.Link}}{{end}}
+{{end}}
+Open TaskFlow: {{.AppURL}}
+
+Don't want these emails? Unsubscribe: {{.UnsubscribeURL}}
+`))
+
+// SendDigestEmail sends a digest of notifications with plain-text and HTML parts
+// and a one-click unsubscribe link
+func SendDigestEmail(toEmail, username, frequency string, items []DigestItem, unsubscribeToken string) error {
+	unsubscribeURL := fmt.Sprintf("%s/notifications/unsubscribe?token=%s", APIBaseURL(), unsubscribeToken)
+	data := digestData{
+		Username:       username,
+		Frequency:      frequency,
+		Items:          items,
+		AppURL:         FrontendURL(),
+		UnsubscribeURL: unsubscribeURL,
+	}
+
+	var text, html bytes.Buffer
+	if err := digestTextTemplate.Execute(&text, data); err != nil {
+		return err
+	}
+	if err := digestHTMLTemplate.Execute(&html, data); err != nil {
+		return err
+	}
+
+	err := mailer.Send(mailer.Message{
+		To:      []string{toEmail},
+		Subject: fmt.Sprintf("Your TaskFlow %s digest (%d)", frequency, len(items)),
+		Text:    text.String(),
+		HTML:    html.String(),
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+	if err != nil {
+		log.Printf("Email Send Error: %v", err)
+		return err
+	}
+	return nil
+}
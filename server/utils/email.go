@@ -3,29 +3,55 @@ package utils
 import (
 	"fmt"
 	"log"
-	"net/smtp"
+	"net/url"
 	"os"
+	"time"
+
+	"github.com/Inengs/realtime-task-app/mailer"
 )
 
-func SendVerificationEmail(toEmail, token string) error {
-	from := os.Getenv("EMAIL_FROM")
-	username := os.Getenv("EMAIL_USERNAME")
-	password := os.Getenv("EMAIL_PASSWORD")
-	smtpHost := os.Getenv("EMAIL_SMTP_HOST")
-	smtpPort := os.Getenv("EMAIL_SMTP_PORT")
-	AppBaseURL := os.Getenv("APP_BASE_URL")
-
-	// Validate environment variables
-	if from == "" || username == "" || password == "" || smtpHost == "" || smtpPort == "" {
-		return fmt.Errorf("missing SMTP environment variables")
+// FrontendURL returns the frontend URL used in email links
+func FrontendURL() string {
+	if url := os.Getenv("APP_BASE_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:5173" // Frontend URL, not backend
+}
+
+// InboundMailDomain returns the domain the inbound mail listener accepts mail for,
+// or "" when email ingestion is not set up
+func InboundMailDomain() string {
+	return os.Getenv("MAIL_INBOUND_DOMAIN")
+}
+
+// ProjectMailAddress returns the address that files emails as tasks in a project
+func ProjectMailAddress(projectID int, inboundToken string) string {
+	if InboundMailDomain() == "" {
+		return ""
+	}
+	return fmt.Sprintf("project-%d+%s@%s", projectID, inboundToken, InboundMailDomain())
+}
+
+// ReplyAddress returns the Reply-To address of task emails sent to userID, so replies become
+// comments by that user, or "" when email ingestion is not set up
+func ReplyAddress(userID, taskID int) string {
+	if InboundMailDomain() == "" {
+		return ""
 	}
+	return fmt.Sprintf("reply+%s@%s", SignReplyToken(userID, taskID), InboundMailDomain())
+}
 
-	if AppBaseURL == "" {
-		AppBaseURL = "http://localhost:5173" // Frontend URL, not backend
+// APIBaseURL returns the public URL of this server, used for links handled by the API itself
+func APIBaseURL() string {
+	if url := os.Getenv("API_BASE_URL"); url != "" {
+		return url
 	}
+	return "http://localhost:8080"
+}
 
+func SendVerificationEmail(toEmail, token string) error {
 	// Verification link goes to frontend
-	verificationURL := fmt.Sprintf("%s/verify-email?token=%s", AppBaseURL, token)
+	verificationURL := fmt.Sprintf("%s/verify-email?token=%s", FrontendURL(), token)
 
 	// HTML email template
 	htmlBody := fmt.Sprintf(`
@@ -63,24 +89,113 @@ func SendVerificationEmail(toEmail, token string) error {
 </html>
 `, verificationURL, verificationURL)
 
-	// Email headers and body
-	message := []byte(
-		"From: " + from + "\r\n" +
-			"To: " + toEmail + "\r\n" +
-			"Subject: Verify Your TaskFlow Account\r\n" +
-			"MIME-Version: 1.0\r\n" +
-			"Content-Type: text/html; charset=UTF-8\r\n" +
-			"\r\n" +
-			htmlBody + "\r\n")
-
-	// SMTP authentication
-	auth := smtp.PlainAuth("", username, password, smtpHost)
-	err := smtp.SendMail(smtpHost+":"+smtpPort, auth, from, []string{toEmail}, message)
+	// Plain-text alternative for clients that don't show HTML
+	textBody := fmt.Sprintf(`Hello,
+
+Thank you for registering with TaskFlow! Please verify your email address by opening this link:
+
+%s
+
+This link will expire in 24 hours.
+
+If you didn't create an account, please ignore this email.
+`, verificationURL)
+
+	err := mailer.Send(mailer.Message{
+		To:      []string{toEmail},
+		Subject: "Verify Your TaskFlow Account",
+		Text:    textBody,
+		HTML:    htmlBody,
+	})
 	if err != nil {
-		log.Printf("SMTP Send Error: %v", err)
+		log.Printf("Email Send Error: %v", err)
 		return err
 	}
-	
+
 	log.Printf("Verification email sent to %s with token %s", toEmail, token)
 	return nil
-}
\ No newline at end of file
+}
+
+// SendNotificationEmail emails a notification message with a link back to the app.
+// When replyTo is set, replying to the email adds a comment to the task.
+func SendNotificationEmail(toEmail, message, link, replyTo string) error {
+	body := message + "\n"
+	if link != "" {
+		body += "\nOpen in TaskFlow: " + FrontendURL() + link + "\n"
+	}
+	if replyTo != "" {
+		body += "\nReply to this email to comment on the task.\n"
+	}
+	body += "\nYou can change which emails you receive in your notification preferences.\n"
+
+	msg := mailer.Message{
+		To:      []string{toEmail},
+		Subject: "TaskFlow: " + message,
+		Text:    body,
+	}
+	if replyTo != "" {
+		msg.Headers = map[string]string{"Reply-To": replyTo}
+	}
+	err := mailer.Send(msg)
+	if err != nil {
+		log.Printf("Email Send Error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// SendInviteEmail emails an invite to join a workspace or project. The link goes to the frontend,
+// which accepts the invite for a logged-in user or passes the token on to registration.
+func SendInviteEmail(toEmail, inviter, target, token string, expiresAt time.Time) error {
+	inviteURL := fmt.Sprintf("%s/invites/accept?token=%s", FrontendURL(), url.QueryEscape(token))
+
+	body := fmt.Sprintf(`Hello,
+
+%s invited you to join %s on TaskFlow. Open this link to accept:
+
+%s
+
+If you don't have an account yet, you can create one with this email address from the same link.
+
+The invite expires on %s. If you weren't expecting it, you can ignore this email.
+`, inviter, target, inviteURL, expiresAt.UTC().Format("January 2, 2006"))
+
+	err := mailer.Send(mailer.Message{
+		To:      []string{toEmail},
+		Subject: fmt.Sprintf("%s invited you to %s on TaskFlow", inviter, target),
+		Text:    body,
+	})
+	if err != nil {
+		log.Printf("Email Send Error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// SendUnlockEmail tells an account's owner it was locked after failed logins. The link goes to the frontend,
+// which posts the token to /auth/unlock.
+func SendUnlockEmail(toEmail, token string, failures int, lockedUntil time.Time) error {
+	unlockURL := fmt.Sprintf("%s/unlock-account?token=%s", FrontendURL(), url.QueryEscape(token))
+
+	body := fmt.Sprintf(`Hello,
+
+Someone entered the wrong password for your TaskFlow account %d times in a row, so logging in is blocked until %s UTC.
+
+If it was you, open this link to unlock your account now:
+
+%s
+
+If it wasn't you, someone may be trying to guess your password. The account stays locked until then, and you may want to choose a stronger password.
+`, failures, lockedUntil.UTC().Format("January 2, 2006 15:04"), unlockURL)
+
+	err := mailer.Send(mailer.Message{
+		To:      []string{toEmail},
+		Subject: "Your TaskFlow account was locked after failed logins",
+		Text:    body,
+	})
+	if err != nil {
+		log.Printf("Email Send Error: %v", err)
+		return err
+	}
+	return nil
+}
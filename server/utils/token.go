@@ -1,8 +1,16 @@
 package utils
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func GenerateVerificationToken() (string, error) {
@@ -13,3 +21,149 @@ func GenerateVerificationToken() (string, error) {
 	}
 	return hex.EncodeToString(bytes), nil
 }
+
+// GenerateWebhookSecret returns a random secret used to sign a webhook's deliveries
+func GenerateWebhookSecret() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(bytes), nil
+}
+
+// ErrInvalidToken is returned when a signed token is malformed or its signature doesn't match
+var ErrInvalidToken = errors.New("invalid token")
+
+// signature returns the hex HMAC-SHA256 of purpose and value, keyed with SESSION_SECRET
+func signature(purpose, value string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("SESSION_SECRET")))
+	mac.Write([]byte(purpose + ":" + value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignUnsubscribeToken returns a token for the one-click unsubscribe link in notification emails.
+// It does not expire, so links in old emails keep working.
+func SignUnsubscribeToken(userID int) string {
+	value := strconv.Itoa(userID)
+	return fmt.Sprintf("%s.%s", value, signature("unsubscribe", value))
+}
+
+// replySignatureLength is how much of the signature goes into reply addresses, whose
+// part before the @ may only be 64 characters long
+const replySignatureLength = 32
+
+// SignReplyToken returns the token in the reply address of a task's emails to userID
+func SignReplyToken(userID, taskID int) string {
+	value := fmt.Sprintf("%d.%d", userID, taskID)
+	return fmt.Sprintf("%s.%s", value, signature("reply", value)[:replySignatureLength])
+}
+
+// VerifyReplyToken checks a token from SignReplyToken and returns its user and task IDs
+func VerifyReplyToken(token string) (int, int, error) {
+	dot := strings.LastIndex(token, ".")
+	if dot < 0 {
+		return 0, 0, ErrInvalidToken
+	}
+	value, sig := token[:dot], token[dot+1:]
+	if !hmac.Equal([]byte(sig), []byte(signature("reply", value)[:replySignatureLength])) {
+		return 0, 0, ErrInvalidToken
+	}
+	userPart, taskPart, _ := strings.Cut(value, ".")
+	userID, err := strconv.Atoi(userPart)
+	if err != nil {
+		return 0, 0, ErrInvalidToken
+	}
+	taskID, err := strconv.Atoi(taskPart)
+	if err != nil {
+		return 0, 0, ErrInvalidToken
+	}
+	return userID, taskID, nil
+}
+
+// VerifyUnsubscribeToken checks a token from SignUnsubscribeToken and returns its user ID
+func VerifyUnsubscribeToken(token string) (int, error) {
+	value, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signature("unsubscribe", value))) {
+		return 0, ErrInvalidToken
+	}
+	userID, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+	return userID, nil
+}
+
+// SignExportDownload returns the signature of the download link of an account export,
+// which is only valid until expires (Unix seconds)
+func SignExportDownload(exportID int, expires int64) string {
+	return signature("export", fmt.Sprintf("%d.%d", exportID, expires))
+}
+
+// VerifyExportDownload checks a signature from SignExportDownload and that the link has not expired
+func VerifyExportDownload(exportID int, expires int64, sig string) error {
+	if !hmac.Equal([]byte(sig), []byte(SignExportDownload(exportID, expires))) {
+		return ErrInvalidToken
+	}
+	if time.Now().Unix() >= expires {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// SignInviteToken returns the token of an invite's emailed link, which is only valid until expires (Unix seconds).
+// Resending an invite changes expires, so links in earlier emails stop working.
+func SignInviteToken(inviteID int, expires int64) string {
+	value := fmt.Sprintf("%d.%d", inviteID, expires)
+	return fmt.Sprintf("%s.%s", value, signature("invite", value))
+}
+
+// VerifyInviteToken checks a token from SignInviteToken and that it has not expired, and returns its invite ID and expiry
+func VerifyInviteToken(token string) (int, int64, error) {
+	dot := strings.LastIndex(token, ".")
+	if dot < 0 {
+		return 0, 0, ErrInvalidToken
+	}
+	value, sig := token[:dot], token[dot+1:]
+	if !hmac.Equal([]byte(sig), []byte(signature("invite", value))) {
+		return 0, 0, ErrInvalidToken
+	}
+	invitePart, expiresPart, _ := strings.Cut(value, ".")
+	inviteID, err := strconv.Atoi(invitePart)
+	if err != nil {
+		return 0, 0, ErrInvalidToken
+	}
+	expires, err := strconv.ParseInt(expiresPart, 10, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		return 0, 0, ErrInvalidToken
+	}
+	return inviteID, expires, nil
+}
+
+// SignUnlockToken returns the token of the link emailed when an account is locked after failed logins.
+// It only unlocks that lockout, which ends at lockedUntil (Unix seconds), so it stops working once the lock is over.
+func SignUnlockToken(userID int, lockedUntil int64) string {
+	value := fmt.Sprintf("%d.%d", userID, lockedUntil)
+	return fmt.Sprintf("%s.%s", value, signature("unlock", value))
+}
+
+// VerifyUnlockToken checks a token from SignUnlockToken and that the lockout hasn't ended, and returns its user ID and lock end
+func VerifyUnlockToken(token string) (int, int64, error) {
+	dot := strings.LastIndex(token, ".")
+	if dot < 0 {
+		return 0, 0, ErrInvalidToken
+	}
+	value, sig := token[:dot], token[dot+1:]
+	if !hmac.Equal([]byte(sig), []byte(signature("unlock", value))) {
+		return 0, 0, ErrInvalidToken
+	}
+	userPart, lockedPart, _ := strings.Cut(value, ".")
+	userID, err := strconv.Atoi(userPart)
+	if err != nil {
+		return 0, 0, ErrInvalidToken
+	}
+	lockedUntil, err := strconv.ParseInt(lockedPart, 10, 64)
+	if err != nil || time.Now().Unix() >= lockedUntil {
+		return 0, 0, ErrInvalidToken
+	}
+	return userID, lockedUntil, nil
+}